@@ -0,0 +1,419 @@
+package bibtex
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A calendar date from a biblatex date field. Month and Day are zero when the
+// date is only given to the year or the month.
+type Date struct {
+	Year        int
+	Month       int
+	Day         int
+	Uncertain   bool // written with a trailing '?' or '%'
+	Approximate bool // written with a trailing '~' or '%'
+}
+
+func (d Date) String() string {
+	var s string
+	if d.Year < 0 {
+		s = fmt.Sprintf("-%04d", -d.Year)
+	} else {
+		s = fmt.Sprintf("%04d", d.Year)
+	}
+	if d.Month != 0 {
+		s += fmt.Sprintf("-%02d", d.Month)
+		if d.Day != 0 {
+			s += fmt.Sprintf("-%02d", d.Day)
+		}
+	}
+	switch {
+	case d.Uncertain && d.Approximate:
+		s += "%"
+	case d.Uncertain:
+		s += "?"
+	case d.Approximate:
+		s += "~"
+	}
+	return s
+}
+
+// A date or a range of dates as written in a biblatex date field, such as
+// "2019-03", "1998/2001" or the open-ended "1988/". A single date has End
+// equal to Start. When one side of a range is open, that side is the zero
+// Date.
+type DateRange struct {
+	Start     Date
+	End       Date
+	OpenStart bool
+	OpenEnd   bool
+}
+
+// Reports whether the range is a single date
+func (r DateRange) Single() bool {
+	return !r.OpenStart && !r.OpenEnd && r.Start == r.End
+}
+
+// Return the year the range is filed under: the start year, or the end year
+// when the start is open
+func (r DateRange) Year() int {
+	if r.OpenStart {
+		return r.End.Year
+	}
+	return r.Start.Year
+}
+
+func (r DateRange) String() string {
+	if r.Single() {
+		return r.Start.String()
+	}
+	var start, end string
+	if !r.OpenStart {
+		start = r.Start.String()
+	}
+	if !r.OpenEnd {
+		end = r.End.String()
+	}
+	return start + "/" + end
+}
+
+// Parse a date in the format biblatex uses: "YYYY", "YYYY-MM" or
+// "YYYY-MM-DD", optionally followed by '?', '~' or '%', or a range of two
+// such dates separated by '/'. Either side of a range may be left empty or
+// written as ".." to leave it open. A time after the day is ignored.
+func ParseDate(s string) (DateRange, error) {
+	var r DateRange
+	s = strings.TrimSpace(s)
+	pieces := strings.Split(s, "/")
+	var err error
+	switch len(pieces) {
+	case 1:
+		r.Start, err = parseSingleDate(pieces[0])
+		r.End = r.Start
+	case 2:
+		start, end := strings.TrimSpace(pieces[0]), strings.TrimSpace(pieces[1])
+		r.OpenStart = start == "" || start == ".."
+		r.OpenEnd = end == "" || end == ".."
+		if r.OpenStart && r.OpenEnd {
+			return r, errors.New("both ends of the range are open")
+		}
+		if !r.OpenStart {
+			if r.Start, err = parseSingleDate(start); err != nil {
+				return r, err
+			}
+		}
+		if !r.OpenEnd {
+			r.End, err = parseSingleDate(end)
+		}
+	default:
+		err = errors.New("too many '/' separators")
+	}
+	return r, err
+}
+
+func parseSingleDate(s string) (Date, error) {
+	var d Date
+	if s == "" {
+		return d, errors.New("missing date")
+	}
+	switch s[len(s)-1] {
+	case '?':
+		d.Uncertain = true
+	case '~':
+		d.Approximate = true
+	case '%':
+		d.Uncertain, d.Approximate = true, true
+	}
+	if d.Uncertain || d.Approximate {
+		s = s[:len(s)-1]
+	}
+	if i := strings.IndexByte(s, 'T'); i != -1 {
+		s = s[:i]
+	}
+
+	negative := strings.HasPrefix(s, "-")
+	if negative {
+		s = s[1:]
+	}
+	parts := strings.Split(s, "-")
+	if len(parts) > 3 || len(parts[0]) != 4 {
+		return d, fmt.Errorf("%q is not of the form YYYY-MM-DD", s)
+	}
+	var numbers [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || (i > 0 && len(part) != 2) {
+			return d, fmt.Errorf("%q is not of the form YYYY-MM-DD", s)
+		}
+		numbers[i] = n
+	}
+	d.Year, d.Month, d.Day = numbers[0], numbers[1], numbers[2]
+	if negative {
+		d.Year = -d.Year
+	}
+	if len(parts) > 1 && (d.Month < 1 || d.Month > 12) {
+		return d, fmt.Errorf("month %d is out of range", d.Month)
+	}
+	if len(parts) > 2 {
+		// let time normalize the date, and see whether it moved
+		t := time.Date(d.Year, time.Month(d.Month), d.Day, 0, 0, 0, 0, time.UTC)
+		if d.Day < 1 || t.Day() != d.Day {
+			return d, fmt.Errorf("day %d is out of range", d.Day)
+		}
+	}
+	return d, nil
+}
+
+// Return the number of a month written as a number, a name or an
+// abbreviation, or zero if it isn't one
+func parseMonth(s string) int {
+	s = strings.ToLower(strings.TrimSuffix(UnicodeBibValue(s), "."))
+	if n, err := strconv.Atoi(s); err == nil {
+		if n >= 1 && n <= 12 {
+			return n
+		}
+		return 0
+	}
+	if len(s) < 3 {
+		return 0
+	}
+	for i, name := range monthNames {
+		if strings.HasPrefix(strings.ToLower(name), s) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+var monthNames = []string{
+	"January", "February", "March", "April", "May", "June", "July",
+	"August", "September", "October", "November", "December",
+}
+
+// A year with a letter after it, as in "2019a", or a second year, as in
+// "1998/99", possibly in brackets
+var suffixedYear = regexp.MustCompile(`^\[?([0-9]{3,4})(?:[a-z]|\s*[-/–]\s*[0-9]{2,4})?\]?$`)
+
+// Return the year in the value of a year field, allowing the forms that
+// suffixedYear matches. The result reports whether there was a year.
+func parseYear(s string) (int, bool) {
+	if year, err := strconv.Atoi(s); err == nil {
+		return year, true
+	}
+	if m := suffixedYear.FindStringSubmatch(s); m != nil {
+		year, _ := strconv.Atoi(m[1])
+		return year, true
+	}
+	return 0, false
+}
+
+// Publication states, written in the year field or the biblatex pubstate
+// field, of work that isn't published yet
+var pendingStates = map[string]bool{
+	"accepted": true, "forthcoming": true, "in preparation": true, "in press": true,
+	"in review": true, "inpreparation": true, "inpress": true, "prepublished": true,
+	"submitted": true, "to appear": true, "under review": true,
+}
+
+// Reports whether an entry is for work that isn't published yet, going by
+// its pubstate field or a year such as "in press"
+func (e Entry) Pending() bool {
+	for _, name := range []string{"pubstate", "year"} {
+		if pendingStates[matchText(e.Field(name))] {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the publication date of an entry from its biblatex date field, or
+// else from its year and month fields, as for a date field that can't be
+// read. The result reports whether a date could be found.
+func (e Entry) Date() (DateRange, bool) {
+	if v, ok := e.Fields.Get("date"); ok {
+		if r, err := ParseDate(UnicodeBibValue(v)); err == nil {
+			return r, true
+		}
+	}
+	year, ok := parseYear(UnicodeBibValue(e.Field("year")))
+	if !ok {
+		return DateRange{}, false
+	}
+	d := Date{Year: year, Month: parseMonth(e.Field("month"))}
+	return DateRange{Start: d, End: d}, true
+}
+
+// Reports whether the entry has a date field that Date can read
+func (e Entry) hasDateField() bool {
+	v, ok := e.Fields.Get("date")
+	if !ok {
+		return false
+	}
+	_, err := ParseDate(UnicodeBibValue(v))
+	return err == nil
+}
+
+// Return the archive and identifier of an electronic preprint, using the
+// biblatex eprinttype field or the older archiveprefix, e.g. "arXiv" and
+// "1901.01234"
+func (e Entry) Eprint() (string, string) {
+	archive := e.Field("eprinttype")
+	if archive == "" {
+		archive = e.Field("archiveprefix")
+	}
+	return UnicodeBibValue(archive), UnicodeBibValue(e.Field("eprint"))
+}
+
+// Fields that biblatex renames, as pairs of the BibTeX name and the biblatex
+// name
+var biblatexFieldAliases = [][2]string{
+	{"address", "location"},
+	{"annote", "annotation"},
+	{"archiveprefix", "eprinttype"},
+	{"journal", "journaltitle"},
+	{"key", "sortkey"},
+	{"primaryclass", "eprintclass"},
+}
+
+// BibTeX entry types that biblatex folds into one of its own types, along
+// with the value of the type field that keeps the distinction
+var biblatexTypeAliases = map[string][2]string{
+	"conference":    {"inproceedings", ""},
+	"electronic":    {"online", ""},
+	"www":           {"online", ""},
+	"mastersthesis": {"thesis", "mathesis"},
+	"phdthesis":     {"thesis", "phdthesis"},
+	"techreport":    {"report", "techreport"},
+}
+
+// Nearest BibTeX type for biblatex types the standard styles don't know
+var bibtexFallbackTypes = map[string]string{
+	"online":         "misc",
+	"mvbook":         "book",
+	"bookinbook":     "inbook",
+	"suppbook":       "inbook",
+	"collection":     "book",
+	"mvcollection":   "book",
+	"suppcollection": "incollection",
+	"reference":      "book",
+	"mvreference":    "book",
+	"inreference":    "incollection",
+	"mvproceedings":  "proceedings",
+	"periodical":     "misc",
+	"suppperiodical": "article",
+	"patent":         "misc",
+	"dataset":        "misc",
+	"software":       "misc",
+	"report":         "techreport",
+	"thesis":         "phdthesis",
+}
+
+// Return a copy of an entry using biblatex conventions: legacy types become
+// @online, @report and @thesis, renamed fields take their biblatex names,
+// and a numeric year and month are combined into a date field. Anything
+// biblatex already understands is left as it is.
+func ToBibLaTeX(e Entry) Entry {
+	e.Fields = e.Fields.Copy()
+	if alias, ok := biblatexTypeAliases[e.Type]; ok {
+		e.Type = alias[0]
+		if alias[1] != "" && !e.Fields.Has("type") {
+			e.Fields.Set("type", alias[1])
+		}
+	}
+	for _, alias := range biblatexFieldAliases {
+		e.Fields.Rename(alias[0], alias[1])
+	}
+	e.Fields.Rename("school", "institution")
+
+	year, hasYear := e.Fields.Get("year")
+	if e.Fields.Has("date") || !hasYear {
+		return e
+	}
+	n, err := strconv.Atoi(UnicodeBibValue(year))
+	if err != nil || n < 0 || n > 9999 {
+		// e.g. "in press", which biblatex would reject as a date
+		return e
+	}
+	d := Date{Year: n}
+	if month, ok := e.Fields.Get("month"); ok {
+		if d.Month = parseMonth(month); d.Month != 0 {
+			e.Fields.Delete("month")
+		}
+	}
+	e.Fields[e.Fields.index("year")] = Field{"date", d.String()}
+	return e
+}
+
+// Return a copy of an entry using classic BibTeX conventions, the reverse of
+// ToBibLaTeX. Types without a BibTeX equivalent become the nearest standard
+// type, and a date field is split into year and month. BibTeX has no field
+// for the day, so it is dropped, and a range over several years is written
+// as "1998--2001".
+func ToBibTeX(e Entry) Entry {
+	e.Fields = e.Fields.Copy()
+	subtype := strings.ToLower(UnicodeBibValue(e.Field("type")))
+	switch e.Type {
+	case "report":
+		if subtype == "techreport" {
+			e.Fields.Delete("type")
+		}
+	case "thesis":
+		if subtype == "mathesis" {
+			e.Type = "mastersthesis"
+			e.Fields.Delete("type")
+		} else if subtype == "phdthesis" {
+			e.Fields.Delete("type")
+		}
+	}
+	if typ, ok := bibtexFallbackTypes[e.Type]; ok {
+		e.Type = typ
+	}
+	for _, alias := range biblatexFieldAliases {
+		e.Fields.Rename(alias[1], alias[0])
+	}
+	if e.Type == "phdthesis" || e.Type == "mastersthesis" {
+		e.Fields.Rename("institution", "school")
+	}
+
+	value, ok := e.Fields.Get("date")
+	if !ok {
+		return e
+	}
+	r, err := ParseDate(UnicodeBibValue(value))
+	if err != nil {
+		return e
+	}
+	i := e.Fields.index("date")
+	if e.Fields.Has("year") {
+		e.Fields.Delete("date")
+		return e
+	}
+	year := strconv.Itoa(r.Year())
+	closed := !r.OpenStart && !r.OpenEnd
+	if !closed || r.Start.Year != r.End.Year {
+		year = yearRange(r)
+	}
+	e.Fields[i] = Field{"year", year}
+	sameMonth := closed && r.Start.Year == r.End.Year && r.Start.Month == r.End.Month
+	if month := r.Start.Month; month != 0 && sameMonth && !e.Fields.Has("month") {
+		e.Fields = append(e.Fields[:i+1], append(Fields{{"month", monthNames[month-1]}}, e.Fields[i+1:]...)...)
+	}
+	return e
+}
+
+// Write the years of a range as BibTeX does, e.g. "1998--2001" or "1988--"
+func yearRange(r DateRange) string {
+	var start, end string
+	if !r.OpenStart {
+		start = strconv.Itoa(r.Start.Year)
+	}
+	if !r.OpenEnd {
+		end = strconv.Itoa(r.End.Year)
+	}
+	return start + "--" + end
+}
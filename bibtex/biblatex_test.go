@@ -0,0 +1,125 @@
+package bibtex
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseDate(t *testing.T) {
+	valid := map[string]DateRange{
+		"2019":             {Start: Date{Year: 2019}, End: Date{Year: 2019}},
+		"2019-03":          {Start: Date{Year: 2019, Month: 3}, End: Date{Year: 2019, Month: 3}},
+		"2019-03-04T10:00": {Start: Date{Year: 2019, Month: 3, Day: 4}, End: Date{Year: 2019, Month: 3, Day: 4}},
+		"1998/2001-06":     {Start: Date{Year: 1998}, End: Date{Year: 2001, Month: 6}},
+		"1988/":            {Start: Date{Year: 1988}, OpenEnd: true},
+		"../1988":          {End: Date{Year: 1988}, OpenStart: true},
+		"1850?":            {Start: Date{Year: 1850, Uncertain: true}, End: Date{Year: 1850, Uncertain: true}},
+		"-0044-03-15":      {Start: Date{Year: -44, Month: 3, Day: 15}, End: Date{Year: -44, Month: 3, Day: 15}},
+	}
+	for s, expected := range valid {
+		r, err := ParseDate(s)
+		if err != nil || r != expected {
+			fmt.Println("parsing", s, "gave", r, err)
+			t.Fail()
+		}
+	}
+	for _, s := range []string{"", "/", "19", "2019-13", "2019-02-30", "2019-3", "March 2019", "1/2/3"} {
+		if _, err := ParseDate(s); err == nil {
+			fmt.Println("expected an error for", s)
+			t.Fail()
+		}
+	}
+	for _, s := range []string{"2019-03", "1998/2001-06", "1988/", "-0044-03-15", "1850?"} {
+		r, _ := ParseDate(s)
+		if strings.TrimPrefix(s, "..") != r.String() {
+			fmt.Println(s, "written as", r.String())
+			t.Fail()
+		}
+	}
+}
+
+func TestReadBibLaTeX(t *testing.T) {
+	src := `@online{a, title = {A page}, date = {2019-03}, url = {https://example.com}, urldate = {2020-01-02}}
+@article{b, journaltitle = {The Cryosphere}, date = {/2004}, eprint = {1901.01234}, eprinttype = {arXiv}}
+@report{c, date = {March 2019}, year = 2018}
+@misc{d, date = {2019-13}}`
+	bib, err := parseBibliography("", src)
+	if errs, _ := err.(ErrorList); len(errs) != 1 || errs.HasErrors() {
+		fmt.Println("expected a warning about the date of d but got", err)
+		t.FailNow()
+	}
+	a, b, c := bib.Entries[0], bib.Entries[1], bib.Entries[2]
+	if a.Year != 2019 || a.Type != "online" {
+		fmt.Println("unexpected year or type:", a)
+		t.Fail()
+	}
+	if date, ok := a.Date(); !ok || date.Start.Month != 3 {
+		fmt.Println("unexpected date:", date)
+		t.Fail()
+	}
+	if b.Year != 2004 || b.Journal != "The Cryosphere" {
+		fmt.Println("unexpected year or journal:", b)
+		t.Fail()
+	}
+	if archive, id := b.Eprint(); archive != "arXiv" || id != "1901.01234" {
+		fmt.Println("unexpected eprint:", archive, id)
+		t.Fail()
+	}
+	if c.Year != 2018 {
+		fmt.Println("expected the year field to be used but got", c.Year)
+		t.Fail()
+	}
+	if date, ok := c.Date(); !ok || date.Start.Year != 2018 {
+		fmt.Println("expected the date to come from the year but got", date, ok)
+		t.Fail()
+	}
+}
+
+func TestConvertBibLaTeX(t *testing.T) {
+	entries := readtoarray("test.bib")
+	converted := ToBibLaTeX(entries[0])
+	names := []string{"Title", "Author", "journaltitle", "date", "Pages", "Volume", "Doi"}
+	if strings.Join(converted.Fields.Names(), ",") != strings.Join(names, ",") {
+		fmt.Println("unexpected fields:", converted.Fields.Names())
+		t.Fail()
+	}
+	if converted.Field("date") != "2013" || entries[0].Field("journal") == "" {
+		fmt.Println("unexpected date, or the original was changed:", converted.Fields)
+		t.Fail()
+	}
+	back := ToBibTeX(converted)
+	for i, f := range back.Fields {
+		if !strings.EqualFold(f.Name, entries[0].Fields[i].Name) || f.Value != entries[0].Fields[i].Value {
+			fmt.Println("round trip changed", entries[0].Fields[i], "to", f)
+			t.Fail()
+		}
+	}
+
+	thesis := Entry{Type: "mastersthesis", Fields: Fields{{"school", "UBC"}, {"year", "2012"}, {"month", "sep"}}}
+	thesis = ToBibLaTeX(thesis)
+	if thesis.Type != "thesis" || thesis.Field("type") != "mathesis" || thesis.Field("institution") != "UBC" ||
+		thesis.Field("date") != "2012-09" || thesis.Fields.Has("month") {
+		fmt.Println("unexpected conversion:", thesis.Type, thesis.Fields)
+		t.Fail()
+	}
+	thesis = ToBibTeX(thesis)
+	if thesis.Type != "mastersthesis" || thesis.Fields.Has("type") || thesis.Field("school") != "UBC" ||
+		thesis.Field("year") != "2012" || thesis.Field("month") != "September" {
+		fmt.Println("unexpected conversion:", thesis.Type, thesis.Fields)
+		t.Fail()
+	}
+
+	// file is what JabRef and Zotero read in BibTeX too
+	online := ToBibTeX(Entry{Type: "online", Fields: Fields{{"date", "1998/2001"}, {"location", "Paris"}, {"file", ":a.pdf:PDF"}}})
+	if online.Type != "misc" || online.Field("year") != "1998--2001" || online.Field("address") != "Paris" ||
+		online.Field("file") != ":a.pdf:PDF" || online.Fields.Has("pdf") {
+		fmt.Println("unexpected conversion:", online.Type, online.Fields)
+		t.Fail()
+	}
+	inPress := ToBibLaTeX(Entry{Type: "article", Fields: Fields{{"year", "in press"}}})
+	if inPress.Field("year") != "in press" || inPress.Fields.Has("date") {
+		fmt.Println("unexpected conversion:", inPress.Fields)
+		t.Fail()
+	}
+}
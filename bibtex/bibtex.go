@@ -1,19 +1,30 @@
-package main
+// Package bibtex reads, searches and writes BibTeX bibliographies.
+package bibtex
 
 import (
+	"compress/gzip"
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"regexp"
-	"strconv"
+	"os"
 	"strings"
 )
 
+// A BibTeX entry. Title, Author, Year and Journal are decoded copies of the
+// corresponding fields kept for convenience, while Fields holds every field
+// of the entry as written. For biblatex entries Year comes from the date
+// field and Journal from journaltitle when the BibTeX fields are missing.
 type Entry struct {
 	Title     string
 	Author    string
 	Year      int
 	Journal   string
 	BibTeXkey string
+	Type      string // entry type in lower case, e.g. "article"
+	Fields    Fields
+
+	src *entrySource // where the entry was read from, if anywhere
 }
 
 // Interface for sorting
@@ -23,6 +34,44 @@ func (a ByYear) Len() int           { return len(a) }
 func (a ByYear) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a ByYear) Less(i, j int) bool { return a[i].Year < a[j].Year }
 
+// Sorts by key, ignoring case as BibTeX does
+type ByKey []Entry
+
+func (a ByKey) Len() int      { return len(a) }
+func (a ByKey) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ByKey) Less(i, j int) bool {
+	return strings.ToLower(a[i].BibTeXkey) < strings.ToLower(a[j].BibTeXkey)
+}
+
+// Sorts by the last name of the first author, or editor if there is no
+// author, and then by year. Entries without either come last.
+type ByAuthor []Entry
+
+func (a ByAuthor) Len() int      { return len(a) }
+func (a ByAuthor) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ByAuthor) Less(i, j int) bool {
+	x, y := sortName(a[i]), sortName(a[j])
+	if x == "" || y == "" {
+		return x != "" && y == ""
+	}
+	if x != y {
+		return x < y
+	}
+	return a[i].Year < a[j].Year
+}
+
+// Return the first author's or editor's names in the order they sort by
+func sortName(e Entry) string {
+	people := e.Authors()
+	if len(people) == 0 {
+		people = e.Editors()
+	}
+	if len(people) == 0 {
+		return ""
+	}
+	return matchText(people[0].Surname() + " " + people[0].First)
+}
+
 func (entry Entry) String() string {
 	return fmt.Sprintf("@%v\nTitle: \"%v\"\nAuthor: %v\nYear: %v\nJournal: %v\n",
 		entry.BibTeXkey,
@@ -32,222 +81,500 @@ func (entry Entry) String() string {
 		entry.Journal)
 }
 
+// Return the raw value of a field, or an empty string if it is missing
+func (e Entry) Field(name string) string {
+	v, _ := e.Fields.Get(name)
+	return v
+}
+
+// Return where the entry starts in the file it was read from, or the zero Pos
+// if it wasn't read from a file
+func (e Entry) Pos() Pos {
+	if e.src == nil {
+		return Pos{}
+	}
+	return e.src.block.start
+}
+
+// Return where a field starts in the file the entry was read from, or where
+// the entry starts if the field wasn't read from the file
+func (e Entry) FieldPos(name string) Pos {
+	if e.src == nil {
+		return Pos{}
+	}
+	for _, f := range e.src.block.fields {
+		if strings.EqualFold(f.name, name) {
+			return f.pos
+		}
+	}
+	return e.src.block.start
+}
+
+// Return the line of the source that *pos* is on, if it is in the entry
+func (src *entrySource) lineAt(pos Pos) string {
+	b := src.block
+	off := pos.Offset - b.start.Offset
+	if off < 0 || off > len(b.raw) {
+		return ""
+	}
+	start := strings.LastIndexByte(b.raw[:off], '\n') + 1
+	end := strings.IndexByte(b.raw[off:], '\n')
+	if end == -1 {
+		end = len(b.raw)
+	} else {
+		end += off
+	}
+	line := b.raw[start:end]
+	if start == 0 {
+		// the entry may start part way along its first line
+		line = b.leading[strings.LastIndexByte(b.leading, '\n')+1:] + line
+	}
+	return strings.TrimRight(line, "\r")
+}
+
+// Return the people in the author field
+func (e Entry) Authors() []Person {
+	return ParseNames(e.Field("author"))
+}
+
+// Return the people in the editor field
+func (e Entry) Editors() []Person {
+	return ParseNames(e.Field("editor"))
+}
+
+// Test whether the author field contains some text, ignoring case, accents
+// and LaTeX markup
 func (e Entry) TestAuthor(auth string) bool {
-	return auth == "" || strings.Contains(e.Author, auth)
+	return includesText(e.Author, auth)
 }
 
+// Test whether any author has exactly the given surname, ignoring case
+func (e Entry) TestAuthorSurname(surname string) bool {
+	if surname == "" {
+		return true
+	}
+	for _, person := range e.Authors() {
+		if person.HasSurname(surname) {
+			return true
+		}
+	}
+	return false
+}
+
+// Test whether the first author has the given surname, ignoring case
+func (e Entry) TestFirstAuthor(surname string) bool {
+	if surname == "" {
+		return true
+	}
+	authors := e.Authors()
+	return len(authors) > 0 && authors[0].HasSurname(surname)
+}
+
+// Test whether the title contains some text, ignoring case, accents and
+// LaTeX markup
 func (e Entry) TestTitle(title string) bool {
-	titleLower := strings.ToLower(title)
-	return titleLower == "" || strings.Contains(strings.ToLower(e.Title), titleLower)
+	return includesText(e.Title, title)
 }
 
 func (e Entry) TestYear(year int) bool {
 	return year == -1000000 || e.Year == year
 }
 
+func (e Entry) TestType(typ string) bool {
+	return typ == "" || strings.EqualFold(e.Type, typ)
+}
+
+// Test whether a field contains some text, ignoring case, accents and LaTeX
+// markup
+func (e Entry) TestField(name, text string) bool {
+	v, ok := e.Fields.Get(name)
+	if !ok {
+		return false
+	}
+	return includesText(v, text)
+}
+
+// A problem found while reading a BibTeX file. Line and Column are 1-based
+// and zero when the problem isn't tied to a place in the file.
 type ParseError struct {
+	File    string
+	Line    int
+	Column  int
+	Key     string // key of the entry the problem is in, if known
+	Snippet string // the line of source the problem is on
 	Message string
+	Warning bool // the file was still read correctly
 }
 
 func (err ParseError) Error() string {
-	return fmt.Sprintf(err.Message)
+	var buf strings.Builder
+	if err.File != "" {
+		buf.WriteString(err.File + ":")
+	}
+	if err.Line != 0 {
+		fmt.Fprintf(&buf, "%d:%d:", err.Line, err.Column)
+	}
+	if buf.Len() != 0 {
+		buf.WriteString(" ")
+	}
+	if err.Warning {
+		buf.WriteString("warning: ")
+	}
+	buf.WriteString(err.Message)
+	if err.Key != "" {
+		fmt.Fprintf(&buf, " (in entry %s)", err.Key)
+	}
+	return buf.String()
+}
+
+// All the problems found in a file, in the order they were found
+type ErrorList []ParseError
+
+func (list ErrorList) Error() string {
+	switch len(list) {
+	case 0:
+		return "no errors"
+	case 1:
+		return list[0].Error()
+	}
+	return fmt.Sprintf("%v (and %d more problems)", list[0], len(list)-1)
+}
+
+// Return the list as an error, or nil if it is empty
+func (list ErrorList) Err() error {
+	if len(list) == 0 {
+		return nil
+	}
+	return list
+}
+
+// Reports whether any of the problems is more serious than a warning
+func (list ErrorList) HasErrors() bool {
+	for _, err := range list {
+		if !err.Warning {
+			return true
+		}
+	}
+	return false
 }
 
 // Given a string that is a valid BibTeX value, return a unicode representation
 func UnicodeBibValue(str string) string {
-	var ustr string
-	ustr = strings.Replace(str, "\\\"o", "ő", -1)
-	ustr = strings.Replace(ustr, "\\\"u", "ű", -1)
-	ustr = strings.Replace(ustr, "{\\ae}", "æ", -1)
-	ustr = strings.Replace(ustr, "{", "", -1)
-	ustr = strings.Replace(ustr, "}", "", -1)
-	ustr = strings.Trim(ustr, " \t,\"")
-	return ustr
-}
-
-// Given a line from a BibTeX file, attempt to return a key : value pair
-func parseLine(s string) (string, string, error) {
-	var key, value string
-	var err error
-	if strings.ContainsRune(s, '=') {
-		pieces := strings.Split(s, "=")
-		key = strings.ToLower(strings.Trim(pieces[0], " \t"))
-		value = UnicodeBibValue(pieces[1])
-	} else if strings.HasPrefix(s, "@") {
-		key = "BibTeXkey"
-		value = strings.TrimSuffix(strings.Split(s, "{")[1], ",")
-	} else {
-		err = ParseError{"not a valid key-value"}
+	return strings.TrimSpace(DecodeLaTeX(str))
+}
+
+// The contents of a BibTeX file
+type Bibliography struct {
+	Entries   []Entry
+	Strings   Fields   // @string macro definitions, in order
+	Preambles []string // @preamble values
+	Comments  []string // bodies of @comment blocks
+
+	// the blocks of the file as read, for writing in PreserveMode
+	source  []sourceBlock
+	trailer string
+}
+
+// Macros that BibTeX styles predefine
+var monthMacros = map[string]string{
+	"jan": "January",
+	"feb": "February",
+	"mar": "March",
+	"apr": "April",
+	"may": "May",
+	"jun": "June",
+	"jul": "July",
+	"aug": "August",
+	"sep": "September",
+	"oct": "October",
+	"nov": "November",
+	"dec": "December",
+}
+
+// Reads the blocks of a BibTeX file, collecting @string macros as they are
+// defined so that they can be expanded in the entries that follow, and
+// collecting any problems found along the way
+type bibReader struct {
+	p      *parser
+	file   string
+	macros map[string]string
+	errs   ErrorList
+}
+
+func newBibReader(fnm string, rd io.Reader) *bibReader {
+	macros := make(map[string]string, len(monthMacros))
+	for k, v := range monthMacros {
+		macros[k] = v
 	}
-	return key, value, err
+	return &bibReader{p: newParser(rd), file: fnm, macros: macros}
 }
 
-// Given an array of lines representing a complete BibTeX entry, return an Entry
-// type
-func parseEntry(lines []string) (Entry, error) {
-	var err error
-	var title, author, journal, key string
-	var year int
-	for _, line := range lines {
-		switch k, v, _ := parseLine(line); k {
+// Return the next block, or io.EOF when there are none left. Blocks with
+// syntax errors are recorded and skipped, and @string blocks are recorded
+// before they are returned. Any other error comes from the underlying reader.
+func (r *bibReader) next() (block, error) {
+	for {
+		b, err := r.p.next()
+		if _, ok := err.(ParseError); ok {
+			r.report(err)
+			continue
+		} else if err != nil {
+			return b, err
+		}
+		if b.kind == stringBlock {
+			field := b.fields[0]
+			r.checkMacros(field, "")
+			r.macros[strings.ToLower(field.name)] = field.expand(r.macros)
+		}
+		return b, nil
+	}
+}
+
+func (r *bibReader) report(err error) {
+	perr, ok := err.(ParseError)
+	if !ok {
+		perr = ParseError{Message: err.Error()}
+	}
+	perr.File = r.file
+	r.errs = append(r.errs, perr)
+}
+
+// Record a warning about something at *pos* in entry *key*
+func (r *bibReader) warn(pos Pos, key, msg string) {
+	r.errs = append(r.errs, ParseError{
+		File:    r.file,
+		Line:    pos.Line,
+		Column:  pos.Column,
+		Key:     key,
+		Snippet: r.p.s.lineAt(pos.Offset),
+		Message: msg,
+		Warning: true,
+	})
+}
+
+// Warn about macros in a field value that haven't been defined
+func (r *bibReader) checkMacros(field rawField, key string) {
+	for _, part := range field.parts {
+		if _, ok := r.macros[strings.ToLower(part.text)]; part.kind == macroPart && !ok {
+			r.warn(field.pos, key, fmt.Sprintf("undefined macro %q in field %s", part.text, field.name))
+		}
+	}
+}
+
+// Given a parsed entry block, return an Entry type. Problems with field
+// values are recorded as warnings and don't stop the entry being read.
+func (r *bibReader) parseEntry(b block) Entry {
+	entry := Entry{
+		BibTeXkey: b.key,
+		Type:      strings.ToLower(b.typ),
+		Fields:    make(Fields, 0, len(b.fields)),
+	}
+	hasYear := false
+	var dateField *rawField
+	var dateErr error
+	for i, field := range b.fields {
+		r.checkMacros(field, b.key)
+		value := field.expand(r.macros)
+		entry.Fields = append(entry.Fields, Field{field.name, value})
+		v := UnicodeBibValue(value)
+		switch strings.ToLower(field.name) {
 		case "author":
-			author = v
+			entry.Author = v
 		case "title":
-			title = v
+			entry.Title = v
 		case "year":
-			year, err = strconv.Atoi(strings.Trim(v, "\""))
+			year, ok := parseYear(v)
+			if !ok && !pendingStates[matchText(v)] {
+				r.warn(field.pos, b.key, fmt.Sprintf("year %q is not a number", v))
+			}
+			entry.Year = year
+			hasYear = true
+		case "date":
+			// biblatex, though older files use it for free text
+			date, err := ParseDate(v)
 			if err != nil {
-				break
+				dateField, dateErr = &b.fields[i], err
+			} else if !hasYear {
+				entry.Year = date.Year()
 			}
 		case "journal":
-			journal = v
-		case "BibTeXkey":
-			key = v
+			entry.Journal = v
+		case "journaltitle":
+			if entry.Journal == "" {
+				entry.Journal = v
+			}
 		}
 	}
-	entry := Entry{title, author, year, journal, key}
-	return entry, err
+	if dateErr != nil && !hasYear {
+		v := UnicodeBibValue(dateField.expand(r.macros))
+		r.warn(dateField.pos, b.key, fmt.Sprintf("date %q is not valid: %v", v, dateErr))
+	}
+	entry.src = &entrySource{b, entry.Type, entry.BibTeXkey, entry.Fields.Copy()}
+	return entry
 }
 
-func combinerunninglines(lines []string) []string {
-	var depth, start int
-	var joinedline string
-	var linestojoin []string
-	joinedlines := make([]string, 0)
-	intag := false
-	inquote := false
+// Parse BibTeX source into a Bibliography, keeping @string, @preamble and
+// @comment blocks alongside the entries. *fnm* is used in error messages. If
+// the source could be read but had problems, the error is an ErrorList and
+// the Bibliography holds everything that could be parsed.
+func ParseBibliography(rd io.Reader, fnm string) (Bibliography, error) {
+	var bib Bibliography
+	r := newBibReader(fnm, rd)
+	for {
+		b, err := r.next()
+		if err == io.EOF {
+			bib.trailer = r.p.trailer
+			return bib, r.errs.Err()
+		} else if err != nil {
+			return bib, err
+		}
+		source := sourceBlock{block: b}
+		switch b.kind {
+		case stringBlock:
+			source.value = r.macros[strings.ToLower(b.fields[0].name)]
+			bib.Strings = append(bib.Strings, Field{b.fields[0].name, source.value})
+		case preambleBlock:
+			source.value = b.fields[0].expand(r.macros)
+			bib.Preambles = append(bib.Preambles, source.value)
+		case commentBlock:
+			source.value = b.key
+			bib.Comments = append(bib.Comments, b.key)
+		case entryBlock:
+			entry := r.parseEntry(b)
+			source.entry = entry.src
+			bib.Entries = append(bib.Entries, entry)
+		}
+		bib.source = append(bib.source, source)
+	}
+}
 
-	re_quote := regexp.MustCompile(`[^\\]\"`)
+func parseBibliography(fnm, src string) (Bibliography, error) {
+	return ParseBibliography(strings.NewReader(src), fnm)
+}
 
-	for i := range lines {
-		depth += strings.Count(lines[i], "{")
-		depth -= strings.Count(lines[i], "}")
+// Open a BibTeX file for reading. The name "-" stands for standard input, and
+// files ending in ".gz" are decompressed.
+func OpenBibTeX(fnm string) (io.ReadCloser, error) {
+	if fnm == "-" {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	f, err := os.Open(fnm)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(fnm, ".gz") {
+		return f, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return gzipFile{gz, f}, nil
+}
 
-		if len(re_quote.FindAllString(lines[i], -1)) == 1 {
-			inquote = !inquote
-			if inquote {
-				depth += 1
-			} else {
-				depth -= 1
-			}
-		}
+// Create a BibTeX file for writing, compressing it if the name ends in
+// ".gz". The permissions of a file that already exists are kept.
+func CreateBibTeX(fnm string) (io.WriteCloser, error) {
+	f, err := os.Create(fnm)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(fnm, ".gz") {
+		return f, nil
+	}
+	return gzipWriter{gzip.NewWriter(f), f}, nil
+}
 
-		if depth > 1 {
-			// inside a tag
-			if !intag {
-				start = i
-				intag = true
-			}
-		} else {
-			if intag {
-				linestojoin = lines[start : i+1]
-				for j, line := range linestojoin {
-					line = strings.TrimSpace(line)
-					linestojoin[j] = line
-				}
-				joinedline = strings.Join(linestojoin, " ")
-				intag = false
-			} else {
-				joinedline = lines[i]
-			}
-			joinedlines = append(joinedlines, joinedline)
-		}
+// Closes both the compressor and the file underneath it
+type gzipWriter struct {
+	*gzip.Writer
+	f *os.File
+}
+
+func (g gzipWriter) Close() error {
+	err := g.Writer.Close()
+	if ferr := g.f.Close(); err == nil {
+		err = ferr
 	}
-	return joinedlines
-}
-
-func parseEntries(lines []string, entries chan Entry) error {
-	var depth, start int
-	var err error
-	joinedlines := combinerunninglines(lines)
-	inentry := false
-	for i, line := range joinedlines {
-		depth += strings.Count(line, "{")
-		depth -= strings.Count(line, "}")
-		if depth == 0 {
-			if inentry {
-				// end of entry
-				inentry = false
-				entry, err := parseEntry(joinedlines[start : i+1])
-				if err == nil {
-					entries <- entry
-				} else {
-					fmt.Println(err)
-				}
-			}
-		} else if depth == 1 {
-			// inside entry but not tag
-			if !inentry {
-				inentry = true
-				start = i
-			}
-		} else {
-			// should never happen
-			if depth > 1 {
-				err = ParseError{"running lines"}
-			} else if depth < 1 {
-				err = ParseError{"malformed BibTeX: negative bracket depth"}
-			}
-			break
-		}
-		i++
-		if i == len(lines) {
-			break
-		}
+	return err
+}
+
+// Closes both the decompressor and the file underneath it
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g gzipFile) Close() error {
+	err := g.Reader.Close()
+	if ferr := g.f.Close(); err == nil {
+		err = ferr
 	}
 	return err
 }
 
-// Open and read a BibTeX database and return an array of BibTeX entries
-// This prints any errors raised
-func ReadBibTeX(fnm string, entries chan Entry) {
+// Open and read a BibTeX database and send its entries to *entries*, closing
+// the channel at the end. Problems are added to *diagnostics*, if it isn't
+// nil, before the channel is closed. The whole file must be consumed; use a
+// Scanner to be able to stop early.
+func ReadBibTeX(fnm string, entries chan Entry, diagnostics *ErrorList) {
 	defer close(entries)
-	data, err := ioutil.ReadFile(fnm)
+	var errs ErrorList
+	f, err := OpenBibTeX(fnm)
 	if err != nil {
-		fmt.Println(err)
-		return
+		errs = ErrorList{ParseError{File: fnm, Message: err.Error()}}
+	} else {
+		defer f.Close()
+		s := NewScanner(context.Background(), f, fnm)
+		for s.Next() {
+			entries <- s.Entry()
+		}
+		errs = s.Diagnostics()
+		if err := s.Err(); err != nil {
+			errs = append(errs, ParseError{File: fnm, Message: err.Error()})
+		}
+	}
+	if diagnostics != nil {
+		*diagnostics = append(*diagnostics, errs...)
 	}
-	lines := strings.Split(string(data), "\n")
-	err = parseEntries(lines, entries)
+}
+
+// Open and read a complete BibTeX database, including its macros, preambles
+// and comments. If the file could be read but had problems, the error is an
+// ErrorList and the Bibliography holds everything that could be parsed.
+func ReadBibliography(fnm string) (Bibliography, error) {
+	f, err := OpenBibTeX(fnm)
 	if err != nil {
-		fmt.Println(err)
+		return Bibliography{}, err
 	}
+	defer f.Close()
+	return ParseBibliography(f, fnm)
 }
 
-// Removes LaTeX-y symbols from *s*.
+// Removes LaTeX-y symbols from *s*, leaving the characters they stand for
 func sanitize(s string) string {
-	out := s
-	if strings.Contains(s, "\\\"") {
-		out = strings.Replace(out, "\\\"", "", -1)
-	}
-	if strings.ContainsAny(s, "{}\\'") {
-		out = strings.Replace(out, "{", "", -1)
-		out = strings.Replace(out, "}", "", -1)
-		out = strings.Replace(out, "\\", "", -1)
-		out = strings.Replace(out, "'", "", -1)
-	}
-	return out
+	return DecodeLaTeX(s)
 }
 
-// Search a slice of BibTeX entries for author text matching a substring
+// Search a slice of BibTeX entries for author text matching a substring,
+// ignoring case, accents and LaTeX markup
 func SearchAuthor(entries []Entry, s string) []Entry {
 	found := make([]Entry, 0)
 	for _, entry := range entries {
-		auth := sanitize(entry.Author)
-		if strings.Contains(auth, s) {
+		if entry.TestAuthor(s) {
 			found = append(found, entry)
 		}
 	}
 	return found
 }
 
-// Search a slice of BibTeX entries for title text matching a substring
+// Search a slice of BibTeX entries for title text matching a substring,
+// ignoring case, accents and LaTeX markup
 func SearchTitle(entries []Entry, s string) []Entry {
 	found := make([]Entry, 0)
-	s = strings.ToLower(s)
 	for _, entry := range entries {
-		title := strings.ToLower(sanitize(entry.Title))
-		if strings.Contains(title, s) {
+		if entry.TestTitle(s) {
 			found = append(found, entry)
 		}
 	}
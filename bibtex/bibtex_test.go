@@ -1,8 +1,8 @@
-package main
+package bibtex
 
 import (
+	"bytes"
 	"fmt"
-	"io/ioutil"
 	"sort"
 	"strings"
 	"testing"
@@ -10,7 +10,7 @@ import (
 
 func readtoarray(fnm string) []Entry {
 	entries := make(chan Entry)
-	go Read(fnm, entries)
+	go ReadBibTeX(fnm, entries, nil)
 	entriesArray := make([]Entry, 0)
 	for entry := range entries {
 		entriesArray = append(entriesArray, entry)
@@ -20,7 +20,7 @@ func readtoarray(fnm string) []Entry {
 
 func TestReadEntries(t *testing.T) {
 	entries := make(chan Entry)
-	go Read("test.bib", entries)
+	go ReadBibTeX("test.bib", entries, nil)
 	i := 0
 	for {
 		_, ok := <-entries
@@ -38,7 +38,7 @@ func TestReadEntries(t *testing.T) {
 
 func TestReadEntriesMacsyma(t *testing.T) {
 	entries := make(chan Entry)
-	go Read("macsyma.bib", entries)
+	go ReadBibTeX("macsyma.bib", entries, nil)
 	i := 0
 	for {
 		_, ok := <-entries
@@ -48,8 +48,8 @@ func TestReadEntriesMacsyma(t *testing.T) {
 			i += 1
 		}
 	}
-	if i != 446 {
-		fmt.Println(i, "entries read (should be 446)")
+	if i != 491 {
+		fmt.Println(i, "entries read (should be 491)")
 		t.Fail()
 	}
 }
@@ -65,11 +65,19 @@ func TestSearchAuthor(t *testing.T) {
 	}
 
 	// Test 2 - make sure accents are accounted for
-	results = SearchAuthor(entries, "Maartensson")
+	results = SearchAuthor(entries, "Mårtensson")
 	if len(results) != 1 {
-		fmt.Println(len(results), "entries found matching 'Maartensson' (should be 1)")
+		fmt.Println(len(results), "entries found matching 'Mårtensson' (should be 1)")
 		t.Fail()
 	}
+
+	// Test 3 - and ignored, along with case and LaTeX markup
+	for _, name := range []string{"martensson", "MARTENSSON", `M{\aa}rtensson`, "padget"} {
+		if results = SearchAuthor(entries, name); len(results) != 1 {
+			fmt.Println(len(results), "entries found matching", name, "(should be 1)")
+			t.Fail()
+		}
+	}
 }
 
 func TestSearchTitle(t *testing.T) {
@@ -99,22 +107,22 @@ func TestSearchYear(t *testing.T) {
 	entries := readtoarray("test.bib")
 	results := SearchYear(entries, 2005, 2013)
 	if len(results) != 2 {
-		fmt.Sprintln("%v entries found for 2005-2013 (should be 2)", len(results))
+		fmt.Println(len(results), "entries found for 2005-2013 (should be 2)")
 		t.Fail()
 	}
 }
 
 func TestSanitize(t *testing.T) {
 	difficult_name := "Bengt M{\\aa}rtensson"
-	if sanitize(difficult_name) != "Bengt Maartensson" {
-		fmt.Println("expected 'Bengt Maartensson' but got",
+	if sanitize(difficult_name) != "Bengt Mårtensson" {
+		fmt.Println("expected 'Bengt Mårtensson' but got",
 			sanitize(difficult_name))
 		t.Fail()
 	}
 
 	difficult_word := "M\\'elange"
-	if strings.ToLower(sanitize(difficult_word)) != "melange" {
-		fmt.Println("expected 'melange' but got",
+	if strings.ToLower(sanitize(difficult_word)) != "mélange" {
+		fmt.Println("expected 'mélange' but got",
 			strings.ToLower(sanitize(difficult_word)))
 		t.Fail()
 	}
@@ -132,108 +140,196 @@ func TestUnicodeBibValue(t *testing.T) {
 
 	testStr = "\t{M\\\"unchow}"
 	outStr = UnicodeBibValue(testStr)
-	if outStr != "Műnchow" {
+	if outStr != "Münchow" {
 		fmt.Println(outStr)
 		t.Fail()
 	}
 }
 
-func TestCombineRunningLinesBraces(t *testing.T) {
-	entry_str := `@Article{Roache:1985:NAG,
-  author =       {Patrick J. Roache and Stanly Steinberg},
-  title =        {New Approach to Grid Generation Using a Variational
-                 Formulation},
-  journal =      {AIAA Paper},
-  pages =        {360--370},
-  year =         {1985},
-  CODEN =        {AAPRAQ},
-  ISSN =         {0146-3705},
-  bibdate =      {Wed Jan 15 15:35:13 MST 1997},
-  bibsource =    {Compendex database;
-                 http://www.math.utah.edu/pub/tex/bib/macsyma.bib},
-  acknowledgement = ack-nhfb,
-  affiliationaddress = {Ecodynamics Research Associates, Albuquerque,
-                 NM, USA},
-  classification = {631; 921; 931},
-  conference =   {Collection of Technical Papers --- AIAA 7th
-                 Computational Fluid Dynamics Conference.},
-  journalabr =   {AIAA Paper},
-  keywords =     {behavioral errors; computational fluid dynamics; fluid
-                 dynamics; mathematical techniques; symbolic
-                 manipulation; Thompson-Thames-Mastin method (TTM
-                 method); VAX 780; Vaxima},
-  meetingaddress = {Cincinnati, OH, Engl},
-  sponsor =      {AIAA, New York, NY, USA},
-}`
-	entry_strs := strings.Split(entry_str, "\n")
-	joinedlines := combinerunninglines(entry_strs)
-	if len(joinedlines) != 19 {
-		fmt.Println("wrong number of line in joined entry:", len(joinedlines))
+func TestSortEntries(t *testing.T) {
+	entries := []Entry{
+		Entry{Title: "FirstTitle", Author: "A. Hodges", Year: 1973, Journal: "Tests and Units", BibTeXkey: "@Hodges1973First"},
+		Entry{Title: "SecondTitle", Author: "Dana Sukoi", Year: 1985, Journal: "Reproducibility Mechanics", BibTeXkey: "@Sukoi1985Second"},
+		Entry{Title: "ThirdTitle", Author: "Carl McIntyre", Year: 1968, Journal: "Journal of Validation", BibTeXkey: "@McIntyre1968Third"}}
+	sort.Sort(ByYear(entries))
+	if entries[0].Title != "ThirdTitle" {
+		t.Fail()
+	}
+	if entries[1].Title != "FirstTitle" {
+		t.Fail()
+	}
+	if entries[2].Title != "SecondTitle" {
+		t.Fail()
+	}
+
+	sort.Sort(ByKey(entries))
+	if entries[0].Title != "FirstTitle" || entries[1].Title != "ThirdTitle" {
+		t.Fail()
+	}
+
+	entries = []Entry{
+		Entry{BibTeXkey: "none", Year: 1990},
+		Entry{BibTeXkey: "later", Year: 2001, Fields: Fields{{"author", "van der Berg, Anna and Zhu, Wei"}}},
+		Entry{BibTeXkey: "editor", Year: 1999, Fields: Fields{{"editor", `{\'E}mond, Luc`}}},
+		Entry{BibTeXkey: "earlier", Year: 1999, Fields: Fields{{"author", "van der Berg, A."}}},
+	}
+	sort.Stable(ByAuthor(entries))
+	keys := make([]string, len(entries))
+	for i, e := range entries {
+		keys[i] = e.BibTeXkey
+	}
+	if strings.Join(keys, " ") != "editor earlier later none" {
+		fmt.Println("unexpected order:", keys)
+		t.Fail()
+	}
+}
+
+func TestEntryFields(t *testing.T) {
+	entries := readtoarray("test.bib")
+	entry := entries[0]
+	if entry.Type != "article" {
+		fmt.Println("expected type 'article' but got", entry.Type)
+		t.Fail()
+	}
+	names := []string{"Title", "Author", "Journal", "Year", "Pages", "Volume", "Doi"}
+	if strings.Join(entry.Fields.Names(), ",") != strings.Join(names, ",") {
+		fmt.Println("fields out of order:", entry.Fields.Names())
+		t.Fail()
+	}
+	if entry.Field("doi") != "10.5194/tc-7-167-2013" {
+		fmt.Println("unexpected doi:", entry.Field("doi"))
+		t.Fail()
+	}
+	if entry.Field("pages") != "167--182" {
+		fmt.Println("unexpected pages:", entry.Field("pages"))
+		t.Fail()
+	}
+	if !entry.TestField("journal", "cryosphere") || entry.TestField("number", "") {
 		t.Fail()
 	}
 }
 
-func TestCombineRunningLinesQuotes(t *testing.T) {
-	entry_str := `@Article{Roache:1985:NAG,
-  author =       "Patrick J. Roache and Stanly Steinberg",
-  title =        "New Approach to Grid Generation Using a Variational
-                 Formulation",
-  journal =      "AIAA Paper",
-  pages =        "360--370",
-  year =         "1985",
-  CODEN =        "AAPRAQ",
-  ISSN =         "0146-3705",
-  bibdate =      "Wed Jan 15 15:35:13 MST 1997",
-  bibsource =    "Compendex database;
-                 http://www.math.utah.edu/pub/tex/bib/macsyma.bib",
-  acknowledgement = ack-nhfb,
-  affiliationaddress = "Ecodynamics Research Associates, Albuquerque,
-                 NM, USA",
-  classification = "631; 921; 931",
-  conference =   "Collection of Technical Papers --- AIAA 7th
-                 Computational Fluid Dynamics Conference.",
-  journalabr =   "AIAA Paper",
-  keywords =     "behavioral errors; computational fluid dynamics; fluid
-                 dynamics; mathematical techniques; symbolic
-                 manipulation; Thompson-Thames-Mastin method (TTM
-                 method); VAX 780; Vaxima",
-  meetingaddress = "Cincinnati, OH, Engl",
-  sponsor =      "AIAA, New York, NY, USA",
-}`
-	entry_strs := strings.Split(entry_str, "\n")
-	joinedlines := combinerunninglines(entry_strs)
-	if len(joinedlines) != 19 {
-		fmt.Println("wrong number of line in joined entry:", len(joinedlines))
+func TestFieldsSetDelete(t *testing.T) {
+	var fs Fields
+	fs.Set("title", "One")
+	fs.Set("year", "1999")
+	fs.Set("Title", "Two")
+	if v, _ := fs.Get("TITLE"); v != "Two" || len(fs) != 2 {
+		fmt.Println("unexpected fields after Set:", fs)
+		t.Fail()
+	}
+	fs.Delete("title")
+	if fs.Has("title") || len(fs) != 1 || fs[0].Name != "year" {
+		fmt.Println("unexpected fields after Delete:", fs)
 		t.Fail()
 	}
 }
 
-func TestCombineRunningLinesInFile(t *testing.T) {
+func TestStringMacros(t *testing.T) {
+	src := `@String{jgr = "J. Geophys. Res."}
+@STRING(jgro = jgr # " Oceans")
+@preamble{"\newcommand{\noop}[1]{}"}
+@comment{jgr is defined above}
+@article{a, journal = jgro, month = jan, note = undefined}
+@article{b, journal = jgr # {: Earth Surface}, month = "1~" # dec}`
+	bib, err := parseBibliography("", src)
+	if errs, _ := err.(ErrorList); len(errs) != 1 || errs.HasErrors() {
+		fmt.Println("expected a warning about the undefined macro but got", err)
+		t.FailNow()
+	}
+	if len(bib.Entries) != 2 || len(bib.Strings) != 2 || len(bib.Preambles) != 1 || len(bib.Comments) != 1 {
+		fmt.Println("unexpected bibliography:", bib)
+		t.FailNow()
+	}
+	if bib.Entries[0].Journal != "J. Geophys. Res. Oceans" {
+		fmt.Println("nested macro not expanded:", bib.Entries[0].Journal)
+		t.Fail()
+	}
+	if bib.Entries[0].Field("month") != "January" || bib.Entries[0].Field("note") != "undefined" {
+		fmt.Println("unexpected macro expansion:", bib.Entries[0].Fields)
+		t.Fail()
+	}
+	if bib.Entries[1].Journal != "J. Geophys. Res.: Earth Surface" || bib.Entries[1].Field("month") != "1~December" {
+		fmt.Println("unexpected concatenation:", bib.Entries[1].Fields)
+		t.Fail()
+	}
+	if bib.Preambles[0] != `\newcommand{\noop}[1]{}` || bib.Comments[0] != "jgr is defined above" {
+		fmt.Println("preamble or comment not kept:", bib.Preambles, bib.Comments)
+		t.Fail()
+	}
+}
 
-	data, err := ioutil.ReadFile("macsyma.bib")
+func TestReadBibliographyMacsyma(t *testing.T) {
+	bib, err := ReadBibliography("macsyma.bib")
 	if err != nil {
 		fmt.Println(err)
-		return
+		t.FailNow()
+	}
+	if len(bib.Entries) != 491 || len(bib.Strings) != 143 || len(bib.Preambles) != 1 {
+		fmt.Println(len(bib.Entries), len(bib.Strings), len(bib.Preambles),
+			"entries, strings and preambles read (should be 491, 143, 1)")
+		t.Fail()
 	}
-	lines := strings.Split(string(data), "\n")
-	joinedlines := combinerunninglines(lines)
-	if len(joinedlines) != 6384 {
-		fmt.Println(fmt.Sprintf("unexpected number of lines (%v)", len(joinedlines)))
+	for _, entry := range bib.Entries {
+		if entry.Journal == "j-SIGSAM" {
+			fmt.Println("journal macro not expanded in", entry.BibTeXkey)
+			t.Fail()
+			break
+		}
 	}
 }
 
-func TestSortEntries(t *testing.T) {
-	entries := []Entry{Entry{"FirstTitle", "A. Hodges", 1973, "Tests and Units", "@Hodges1973First"},
-		Entry{"SecondTitle", "Dana Sukoi", 1985, "Reproducibility Mechanics", "@Sukoi1985Second"},
-		Entry{"ThirdTitle", "Carl McIntyre", 1968, "Journal of Validation", "@McIntyre1968Third"}}
-	sort.Sort(ByYear(entries))
-	if entries[0].Title != "ThirdTitle" {
+func TestParseRecovery(t *testing.T) {
+	src := `@article{good1, title = {One}, year = 1999}
+@article{broken, title = {Two, year = 2000}
+@article{good2, title = {Three}, year = {unknown}}
+  @article{bad key, title = {Four}}
+@article{good3, title = "Five" # , year = 2001}
+@article{good4, title = {Six}}
+`
+	bib, err := parseBibliography("refs.bib", src)
+	errs, ok := err.(ErrorList)
+	if !ok {
+		fmt.Println("expected an ErrorList but got", err)
+		t.FailNow()
+	}
+	keys := make([]string, 0)
+	for _, entry := range bib.Entries {
+		keys = append(keys, entry.BibTeXkey)
+	}
+	if strings.Join(keys, ",") != "good1,good2,good4" {
+		fmt.Println("unexpected entries after recovery:", keys)
 		t.Fail()
 	}
-	if entries[1].Title != "FirstTitle" {
+	if len(errs) != 4 {
+		fmt.Println(len(errs), "problems found (should be 4):", errs)
+		t.FailNow()
+	}
+	expected := []string{
+		"refs.bib:3:1: expected ',' or '}' but found '@' (in entry broken)",
+		"refs.bib:3:34: warning: year \"unknown\" is not a number (in entry good2)",
+		"refs.bib:4:16: expected ',' or '}' but found 'k' (in entry bad)",
+		"refs.bib:5:34: expected a value but found ',' (in entry good3)",
+	}
+	for i, e := range errs {
+		if e.Error() != expected[i] {
+			fmt.Println("expected", expected[i], "but got", e)
+			t.Fail()
+		}
+	}
+	if errs[3].Snippet != `@article{good3, title = "Five" # , year = 2001}` {
+		fmt.Println("unexpected snippet:", errs[3].Snippet)
 		t.Fail()
 	}
-	if entries[2].Title != "SecondTitle" {
+	if !errs.HasErrors() || bib.Entries[1].Year != 0 {
+		t.Fail()
+	}
+
+	// the broken entries are kept when the file is written back out
+	var buf bytes.Buffer
+	WriteBibliography(&buf, bib, WriteOptions{Mode: PreserveMode})
+	if buf.String() != src {
+		fmt.Printf("unexpected output:\n%s\n", buf.String())
 		t.Fail()
 	}
 }
@@ -0,0 +1,235 @@
+package bibtex
+
+import (
+	"strings"
+)
+
+// Change the key of the entry keyed *old* to *new*, along with the crossref
+// and xdata fields of entries that refer to it. Keys are matched ignoring
+// case, and the result reports whether an entry had the old key.
+func RenameKey(entries []Entry, old, new string) bool {
+	found := false
+	renames := map[string]string{strings.ToLower(old): new}
+	for i := range entries {
+		if strings.EqualFold(entries[i].BibTeXkey, old) {
+			entries[i].BibTeXkey = new
+			found = true
+		}
+		renameReferences(&entries[i], renames)
+	}
+	return found
+}
+
+// Rename the keys that the crossref and xdata fields of entries refer to,
+// from the keys of *renames* to their values, ignoring case. The entries'
+// own keys are left alone, for when they have been changed already. The
+// result is the number of entries that were changed.
+func RenameReferences(entries []Entry, renames map[string]string) int {
+	lower := lowerKeys(renames)
+	n := 0
+	for i := range entries {
+		if renameReferences(&entries[i], lower) {
+			n++
+		}
+	}
+	return n
+}
+
+// Rename the keys in an entry's crossref and xdata fields, reporting whether
+// any were renamed. The keys of *renames* are in lower case.
+func renameReferences(e *Entry, renames map[string]string) bool {
+	changed := false
+	for _, field := range []string{"crossref", "xdata"} {
+		value, ok := e.Fields.Get(field)
+		if !ok {
+			continue
+		}
+		keys := splitKeys(value)
+		renamed := false
+		for k, key := range keys {
+			if new, ok := renames[strings.ToLower(key)]; ok {
+				keys[k] = new
+				renamed = true
+			}
+		}
+		if renamed {
+			e.Fields = e.Fields.Copy()
+			e.Fields.Set(field, strings.Join(keys, ", "))
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Return *src* with the keys in LaTeX citation commands renamed according to
+// *renames*, and the number of keys that were renamed. Any command with
+// "cite" in its name is understood, such as \cite, \citep, \citet*,
+// \autocite, \parencite and \nocite, along with optional arguments, lists of
+// keys and biblatex commands such as \cites that take several lists. Keys
+// are matched ignoring case.
+func RenameLaTeXCitations(src string, renames map[string]string) (string, int) {
+	renames = lowerKeys(renames)
+	var buf strings.Builder
+	count := 0
+	i := 0
+	for i < len(src) {
+		if src[i] != '\\' {
+			buf.WriteByte(src[i])
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(src) && isLetter(src[j]) {
+			j++
+		}
+		name := src[i+1 : j]
+		if !strings.Contains(strings.ToLower(name), "cite") {
+			if j == i+1 && j < len(src) {
+				j++ // control symbol, e.g. \\ or \%
+			}
+			buf.WriteString(src[i:j])
+			i = j
+			continue
+		}
+		if j < len(src) && src[j] == '*' {
+			j++
+		}
+		buf.WriteString(src[i:j])
+		i = j
+
+		multi := strings.HasSuffix(name, "cites")
+		for {
+			// optional arguments, then the braced list of keys
+			k := i
+			for k < len(src) && (src[k] == ' ' || src[k] == '\t' || src[k] == '\n') {
+				k++
+			}
+			if k < len(src) && src[k] == '[' {
+				end := matchingBracket(src, k, '[', ']')
+				if end == -1 {
+					break
+				}
+				buf.WriteString(src[i : end+1])
+				i = end + 1
+				continue
+			}
+			if k >= len(src) || src[k] != '{' {
+				break
+			}
+			end := matchingBracket(src, k, '{', '}')
+			if end == -1 {
+				break
+			}
+			buf.WriteString(src[i : k+1])
+			list, n := renameKeyList(src[k+1:end], renames)
+			buf.WriteString(list)
+			buf.WriteByte('}')
+			count += n
+			i = end + 1
+			if !multi {
+				break
+			}
+		}
+	}
+	return buf.String(), count
+}
+
+// Rename the keys in a comma separated list, keeping the spacing around them
+func renameKeyList(list string, renames map[string]string) (string, int) {
+	keys := strings.Split(list, ",")
+	count := 0
+	for i, key := range keys {
+		trimmed := strings.TrimSpace(key)
+		if new, ok := renames[strings.ToLower(trimmed)]; ok && trimmed != "" {
+			start := strings.Index(key, trimmed)
+			keys[i] = key[:start] + new + key[start+len(trimmed):]
+			count++
+		}
+	}
+	return strings.Join(keys, ","), count
+}
+
+// Return the index of the bracket closing the one at *start*, or -1
+func matchingBracket(src string, start int, open, close byte) int {
+	depth := 0
+	for i := start; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case open:
+			depth++
+		case close:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Punctuation that Pandoc allows inside a citation key but not at its end
+const pandocKeyPunctuation = ":.#$%&-+?<>~/"
+
+// Return Markdown with the keys of Pandoc citations such as [@key, p. 3],
+// [-@key] and @key renamed according to *renames*, and the number of keys
+// that were renamed. Keys are matched ignoring case. An @ after a letter or
+// digit, as in an email address, doesn't start a citation.
+func RenamePandocCitations(src string, renames map[string]string) (string, int) {
+	renames = lowerKeys(renames)
+	var buf strings.Builder
+	count := 0
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		if c != '@' || (i > 0 && isKeyChar(src[i-1])) {
+			buf.WriteByte(c)
+			i++
+			continue
+		}
+		buf.WriteByte('@')
+		i++
+		if i < len(src) && src[i] == '{' {
+			// @{key} may contain any punctuation
+			end := strings.IndexByte(src[i:], '}')
+			if end == -1 {
+				continue
+			}
+			key := src[i+1 : i+end]
+			if new, ok := renames[strings.ToLower(key)]; ok {
+				key = new
+				count++
+			}
+			buf.WriteString("{" + key + "}")
+			i += end + 1
+			continue
+		}
+		j := i
+		for j < len(src) && (isKeyChar(src[j]) || strings.IndexByte(pandocKeyPunctuation, src[j]) != -1) {
+			j++
+		}
+		for j > i && strings.IndexByte(pandocKeyPunctuation, src[j-1]) != -1 {
+			j--
+		}
+		key := src[i:j]
+		if new, ok := renames[strings.ToLower(key)]; ok && key != "" {
+			key = new
+			count++
+		}
+		buf.WriteString(key)
+		i = j
+	}
+	return buf.String(), count
+}
+
+func isKeyChar(c byte) bool {
+	return isLetter(c) || (c >= '0' && c <= '9') || c == '_' || c >= 0x80
+}
+
+func lowerKeys(renames map[string]string) map[string]string {
+	lower := make(map[string]string, len(renames))
+	for old, new := range renames {
+		lower[strings.ToLower(old)] = new
+	}
+	return lower
+}
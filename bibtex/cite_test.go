@@ -0,0 +1,77 @@
+package bibtex
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRenameLaTeXCitations(t *testing.T) {
+	renames := map[string]string{"Nye1952": "nye-flow", "old": "new"}
+	tests := map[string]string{
+		`\cite{Nye1952}`: `\cite{nye-flow}`,
+		`\citep[see][p.~3]{Wilson2013a, nye1952,old}`: `\citep[see][p.~3]{Wilson2013a, nye-flow,new}`,
+		`\citet*{Nye1952} and \autocite[12]{old}.`:    `\citet*{nye-flow} and \autocite[12]{new}.`,
+		`\parencites[a][]{Nye1952}[b]{x,old}{old}`:    `\parencites[a][]{nye-flow}[b]{x,new}{new}`,
+		`\textcite{Nye1952}{old}`:                     `\textcite{nye-flow}{old}`,
+		`\section{Nye1952} \ref{old} \\ \%{old}`:      `\section{Nye1952} \ref{old} \\ \%{old}`,
+		`\nocite{*} \cite [p.~1]{old`:                 `\nocite{*} \cite [p.~1]{old`,
+	}
+	for src, expected := range tests {
+		if out, _ := RenameLaTeXCitations(src, renames); out != expected {
+			fmt.Println(src, "became", out)
+			t.Fail()
+		}
+	}
+	if _, n := RenameLaTeXCitations(`\parencites[a][]{Nye1952}[b]{x,old}{old}`, renames); n != 3 {
+		fmt.Println(n, "keys renamed (should be 3)")
+		t.Fail()
+	}
+}
+
+func TestRenamePandocCitations(t *testing.T) {
+	renames := map[string]string{"Nye1952": "nye-flow", "a:b": "c"}
+	tests := map[string]string{
+		`[@Nye1952; @a:b, p. 4]`:                `[@nye-flow; @c, p. 4]`,
+		`As @Nye1952 says. Also [-@a:b].`:       `As @nye-flow says. Also [-@c].`,
+		`see @{Nye1952} or mail me@Nye1952.org`: `see @{nye-flow} or mail me@Nye1952.org`,
+		`@Nye1952a is another paper`:            `@Nye1952a is another paper`,
+	}
+	for src, expected := range tests {
+		if out, _ := RenamePandocCitations(src, renames); out != expected {
+			fmt.Println(src, "became", out)
+			t.Fail()
+		}
+	}
+}
+
+func TestRenameKey(t *testing.T) {
+	src := `@inproceedings{talk, crossref = {Conf}}
+@misc{other, xdata = {a, conf}}
+@proceedings{conf, title = {Conference}}`
+	bib, _ := parseBibliography("", src)
+	if !RenameKey(bib.Entries, "conf", "conf2017") || RenameKey(bib.Entries, "missing", "x") {
+		t.Fail()
+	}
+	if bib.Entries[2].BibTeXkey != "conf2017" || bib.Entries[0].Field("crossref") != "conf2017" ||
+		bib.Entries[1].Field("xdata") != "a, conf2017" {
+		fmt.Println("unexpected entries:", bib.Entries)
+		t.Fail()
+	}
+}
+
+func TestRenameReferences(t *testing.T) {
+	src := `@inproceedings{talk, author = {Smith, A.}, year = 2001, crossref = {Procs}}
+@proceedings{procs, editor = {Jones, B.}, year = 2001, title = {Proceedings}}`
+	bib, _ := parseBibliography("", src)
+	p, _ := ParseKeyPattern("[auth][year]")
+	renames := make(map[string]string)
+	for i, key := range GenerateKeys(bib.Entries, p, nil) {
+		renames[bib.Entries[i].BibTeXkey] = key
+		bib.Entries[i].BibTeXkey = key
+	}
+	RenameReferences(bib.Entries, renames)
+	if bib.Entries[1].BibTeXkey != "Jones2001" || bib.Entries[0].Field("crossref") != "Jones2001" {
+		fmt.Println("unexpected entries:", bib.Entries)
+		t.Fail()
+	}
+}
@@ -0,0 +1,191 @@
+package bibtex
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Fields that are never inherited from a parent entry
+var uninheritedFields = map[string]bool{
+	"crossref": true, "xref": true, "xdata": true, "ids": true, "entryset": true,
+	"entrysubtype": true, "execute": true, "label": true, "options": true,
+	"presort": true, "related": true, "relatedoptions": true, "relatedstring": true,
+	"relatedtype": true, "shorthand": true, "shorthandintro": true, "sortkey": true,
+}
+
+// Entry types whose title becomes the booktitle of the parts that refer to
+// them
+var containerTypes = map[string]bool{
+	"book": true, "mvbook": true, "collection": true, "mvcollection": true,
+	"proceedings": true, "mvproceedings": true, "reference": true, "mvreference": true,
+}
+
+// Return the name a parent's field takes in a child that refers to it
+// through crossref, following the default biblatex inheritance rules, or
+// false if the field isn't inherited
+func inheritedName(parentType, name string) (string, bool) {
+	lower := strings.ToLower(name)
+	if uninheritedFields[lower] {
+		return "", false
+	}
+	var prefix string
+	switch {
+	case containerTypes[parentType]:
+		prefix = "book"
+	case parentType == "periodical":
+		prefix = "journal"
+	default:
+		return name, true
+	}
+	switch lower {
+	case "title", "subtitle", "titleaddon":
+		return prefix + lower, true
+	case "shorttitle", "sorttitle", "indextitle", "indexsorttitle":
+		return "", false
+	}
+	return name, true
+}
+
+// Fills in the fields that entries inherit from each other
+type crossrefResolver struct {
+	fnm     string
+	entries []Entry
+	byKey   map[string]int // lower case key to index
+	state   []int          // 0 to begin with, 1 while resolving and 2 when done
+	stack   []string       // keys being resolved, for reporting cycles
+	errs    ErrorList
+}
+
+// Return copies of *entries* with the fields they inherit through crossref
+// and biblatex xdata filled in, together with any missing parents and cycles.
+// Fields the entry already has are kept, and the title of a book or
+// proceedings becomes the booktitle of its parts. Entries that don't refer to
+// others are returned as they are. *fnm* is used in the diagnostics.
+func ResolveCrossrefs(entries []Entry, fnm string) ([]Entry, ErrorList) {
+	r := crossrefResolver{
+		fnm:     fnm,
+		entries: make([]Entry, len(entries)),
+		byKey:   make(map[string]int, len(entries)),
+		state:   make([]int, len(entries)),
+	}
+	copy(r.entries, entries)
+	for i, e := range entries {
+		if _, dup := r.byKey[strings.ToLower(e.BibTeXkey)]; !dup {
+			r.byKey[strings.ToLower(e.BibTeXkey)] = i
+		}
+	}
+	for i := range r.entries {
+		r.resolve(i)
+	}
+	return r.entries, r.errs
+}
+
+func (r *crossrefResolver) resolve(i int) {
+	if r.state[i] != 0 {
+		return
+	}
+	r.state[i] = 1
+	r.stack = append(r.stack, r.entries[i].BibTeXkey)
+	e := r.entries[i]
+
+	var fields Fields
+	for _, key := range splitKeys(e.Field("xdata")) {
+		if parent, ok := r.parent(e, "xdata", key); ok {
+			for _, f := range parent.Fields {
+				if !uninheritedFields[strings.ToLower(f.Name)] && !e.Fields.Has(f.Name) && !fields.Has(f.Name) {
+					fields = append(fields, f)
+				}
+			}
+		}
+	}
+	if key := strings.TrimSpace(e.Field("crossref")); key != "" {
+		if parent, ok := r.parent(e, "crossref", key); ok {
+			for _, f := range parent.Fields {
+				name, inherit := inheritedName(parent.Type, f.Name)
+				if inherit && !e.Fields.Has(name) && !fields.Has(name) {
+					fields = append(fields, Field{name, f.Value})
+				}
+			}
+		}
+	}
+	if len(fields) > 0 {
+		e.Fields = append(e.Fields.Copy(), fields...)
+		summarize(&e, fields)
+		r.entries[i] = e
+	}
+
+	r.stack = r.stack[:len(r.stack)-1]
+	r.state[i] = 2
+}
+
+// Return the resolved parent *key* that *e* refers to in *field*, reporting it
+// if it doesn't exist or refers back to *e*
+func (r *crossrefResolver) parent(e Entry, field, key string) (Entry, bool) {
+	j, ok := r.byKey[strings.ToLower(key)]
+	if !ok {
+		r.report(e, field, fmt.Sprintf("%s %q not found", field, key), true)
+		return Entry{}, false
+	}
+	if r.state[j] == 1 {
+		cycle := r.stack
+		for k, stacked := range r.stack {
+			if strings.EqualFold(stacked, key) {
+				cycle = r.stack[k:]
+				break
+			}
+		}
+		r.report(e, field, fmt.Sprintf("%s cycle %s -> %s", field, strings.Join(cycle, " -> "), key), false)
+		return Entry{}, false
+	}
+	r.resolve(j)
+	return r.entries[j], true
+}
+
+// Record a problem with *field* of *e*, at its place in the source if known
+func (r *crossrefResolver) report(e Entry, field, msg string, warning bool) {
+	err := ParseError{File: r.fnm, Key: e.BibTeXkey, Message: msg, Warning: warning}
+	if e.src != nil {
+		for _, raw := range e.src.block.fields {
+			if strings.EqualFold(raw.name, field) {
+				err.Line, err.Column = raw.pos.Line, raw.pos.Column
+			}
+		}
+	}
+	r.errs = append(r.errs, err)
+}
+
+// Split a comma separated list of entry keys
+func splitKeys(value string) []string {
+	keys := make([]string, 0)
+	for _, key := range strings.Split(value, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Fill in the decoded fields of an entry that come from *inherited* fields
+func summarize(e *Entry, inherited Fields) {
+	for _, f := range inherited {
+		v := UnicodeBibValue(f.Value)
+		switch strings.ToLower(f.Name) {
+		case "author":
+			e.Author = v
+		case "title":
+			e.Title = v
+		case "journal", "journaltitle":
+			if e.Journal == "" {
+				e.Journal = v
+			}
+		case "year":
+			if year, ok := parseYear(v); ok && e.Year == 0 {
+				e.Year = year
+			}
+		case "date":
+			if date, err := ParseDate(v); err == nil && e.Year == 0 {
+				e.Year = date.Year()
+			}
+		}
+	}
+}
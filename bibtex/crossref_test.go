@@ -0,0 +1,76 @@
+package bibtex
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestResolveCrossrefs(t *testing.T) {
+	src := `@inproceedings{part, author = {Doe, J.}, title = {A talk}, crossref = {conf2017}, pages = {1--10}}
+@inproceedings{other, title = {Another talk}, crossref = {CONF2017}, xdata = {acm}}
+@proceedings{conf2017, title = {Proceedings of the Conference}, year = 2017,
+  publisher = {ACM}, shorttitle = {Conf}, crossref = {series}}
+@book{series, title = {Lecture Notes}, editor = {Roe, R.}}
+@xdata{acm, publisher = {Association for Computing Machinery}, location = {New York}}`
+	bib, err := parseBibliography("", src)
+	if err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	entries, errs := ResolveCrossrefs(bib.Entries, "refs.bib")
+	if len(errs) != 0 {
+		fmt.Println("unexpected problems:", errs)
+		t.Fail()
+	}
+	part := entries[0]
+	if part.Year != 2017 || part.Field("booktitle") != "Proceedings of the Conference" ||
+		part.Field("publisher") != "ACM" || part.Fields.Has("shorttitle") || part.Title != "A talk" {
+		fmt.Println("unexpected inheritance:", part.Year, part.Fields)
+		t.Fail()
+	}
+	// inherited through the parent's own crossref
+	if part.Field("editor") != "Roe, R." {
+		fmt.Println("nested crossref not inherited:", part.Fields)
+		t.Fail()
+	}
+	other := entries[1]
+	if other.Field("publisher") != "Association for Computing Machinery" || other.Field("location") != "New York" {
+		fmt.Println("xdata should come before crossref:", other.Fields)
+		t.Fail()
+	}
+	if bib.Entries[0].Year != 0 || bib.Entries[0].Fields.Has("booktitle") {
+		fmt.Println("raw entries were changed:", bib.Entries[0].Fields)
+		t.Fail()
+	}
+}
+
+func TestResolveCrossrefProblems(t *testing.T) {
+	src := `@inproceedings{a, crossref = {missing}}
+@inproceedings{b,
+  crossref = {c}}
+@proceedings{c, crossref = {b}, year = 2000}`
+	bib, _ := parseBibliography("", src)
+	entries, errs := ResolveCrossrefs(bib.Entries, "refs.bib")
+	expected := []string{
+		`refs.bib:1:19: warning: crossref "missing" not found (in entry a)`,
+		`refs.bib:4:17: crossref cycle b -> c -> b (in entry c)`,
+	}
+	if len(errs) != len(expected) {
+		fmt.Println("unexpected problems:", errs)
+		t.FailNow()
+	}
+	for i, err := range errs {
+		if err.Error() != expected[i] {
+			fmt.Println(err)
+			t.Fail()
+		}
+	}
+	if entries[1].Year != 2000 || !entries[2].Fields.Has("year") {
+		fmt.Println("cycle stopped inheritance:", entries[1].Fields)
+		t.Fail()
+	}
+	if strings.Join(entries[0].Fields.Names(), ",") != "crossref" {
+		t.Fail()
+	}
+}
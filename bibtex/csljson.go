@@ -0,0 +1,698 @@
+package bibtex
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// An item in CSL-JSON, the format that citeproc, Pandoc and Zotero read.
+// Variables are kept by kind: names such as author, dates such as issued,
+// and everything else as text.
+type CSLItem struct {
+	ID    string
+	Type  string
+	Names map[string][]CSLName
+	Dates map[string]CSLDate
+	Vars  map[string]string
+}
+
+// A name in CSL-JSON. Literal is used for names that aren't split into
+// parts, such as organizations.
+type CSLName struct {
+	Family              string `json:"family,omitempty"`
+	Given               string `json:"given,omitempty"`
+	NonDroppingParticle string `json:"non-dropping-particle,omitempty"`
+	DroppingParticle    string `json:"dropping-particle,omitempty"`
+	Suffix              string `json:"suffix,omitempty"`
+	Literal             string `json:"literal,omitempty"`
+}
+
+// A date in CSL-JSON, as one or two (for a range) lists of year, month and
+// day, or as text
+type CSLDate struct {
+	DateParts [][]int `json:"date-parts,omitempty"`
+	Circa     bool    `json:"circa,omitempty"`
+	Raw       string  `json:"raw,omitempty"`
+	Literal   string  `json:"literal,omitempty"`
+}
+
+// CSL variables that hold names or dates rather than text
+var (
+	cslNameVars = map[string]bool{
+		"author": true, "editor": true, "translator": true, "container-author": true,
+		"collection-editor": true, "composer": true, "director": true, "interviewer": true,
+		"illustrator": true, "original-author": true, "recipient": true, "reviewed-author": true,
+		"editorial-director": true,
+	}
+	cslDateVars = map[string]bool{
+		"issued": true, "accessed": true, "event-date": true, "original-date": true,
+		"submitted": true, "available-date": true,
+	}
+)
+
+func (item CSLItem) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{}, 2+len(item.Names)+len(item.Dates)+len(item.Vars))
+	for name, v := range item.Vars {
+		m[name] = v
+	}
+	for name, v := range item.Names {
+		m[name] = v
+	}
+	for name, v := range item.Dates {
+		m[name] = v
+	}
+	m["id"] = item.ID
+	m["type"] = item.Type
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(m); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(buf.Bytes()), nil
+}
+
+func (item *CSLItem) UnmarshalJSON(data []byte) error {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	*item = CSLItem{
+		Names: make(map[string][]CSLName),
+		Dates: make(map[string]CSLDate),
+		Vars:  make(map[string]string),
+	}
+	for name, raw := range m {
+		switch {
+		case cslNameVars[name]:
+			var names []CSLName
+			if err := json.Unmarshal(raw, &names); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			item.Names[name] = names
+		case cslDateVars[name]:
+			d, err := unmarshalCSLDate(raw)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			item.Dates[name] = d
+		default:
+			// numbers are often written without quotes, and anything
+			// other than text, such as Zotero's custom objects, is dropped
+			var v interface{}
+			dec := json.NewDecoder(bytes.NewReader(raw))
+			dec.UseNumber()
+			if err := dec.Decode(&v); err != nil {
+				return err
+			}
+			var s string
+			switch v := v.(type) {
+			case string:
+				s = v
+			case json.Number:
+				s = v.String()
+			default:
+				continue
+			}
+			switch name {
+			case "id":
+				item.ID = s
+			case "type":
+				item.Type = s
+			default:
+				item.Vars[name] = s
+			}
+		}
+	}
+	return nil
+}
+
+// Read a CSL-JSON date, which may be given as text, and whose parts may be
+// numbers or strings of digits
+func unmarshalCSLDate(raw json.RawMessage) (CSLDate, error) {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return CSLDate{Raw: s}, nil
+	}
+	var d struct {
+		DateParts [][]interface{} `json:"date-parts"`
+		Circa     interface{}     `json:"circa"`
+		Raw       string          `json:"raw"`
+		Literal   string          `json:"literal"`
+	}
+	if err := json.Unmarshal(raw, &d); err != nil {
+		return CSLDate{}, err
+	}
+	date := CSLDate{Raw: d.Raw, Literal: d.Literal}
+	switch circa := d.Circa.(type) {
+	case bool:
+		date.Circa = circa
+	case float64:
+		date.Circa = circa != 0
+	case string:
+		date.Circa = circa != "" && circa != "0" && circa != "false"
+	}
+	for _, parts := range d.DateParts {
+		ints := make([]int, 0, len(parts))
+		for _, p := range parts {
+			var n int
+			switch p := p.(type) {
+			case float64:
+				n = int(p)
+			case string:
+				var err error
+				if n, err = strconv.Atoi(strings.TrimSpace(p)); err != nil {
+					return CSLDate{}, fmt.Errorf("date part %q is not a number", p)
+				}
+			default:
+				return CSLDate{}, fmt.Errorf("date part %v is not a number", p)
+			}
+			ints = append(ints, n)
+		}
+		if len(ints) > 0 {
+			date.DateParts = append(date.DateParts, ints)
+		}
+	}
+	return date, nil
+}
+
+// Read a CSL-JSON file, which holds a list of items or a single item
+func ReadCSLJSON(rd io.Reader) ([]CSLItem, error) {
+	data, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		var item CSLItem
+		if err := json.Unmarshal(data, &item); err != nil {
+			return nil, fmt.Errorf("CSL-JSON: %v", err)
+		}
+		return []CSLItem{item}, nil
+	}
+	var items []CSLItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("CSL-JSON: %v", err)
+	}
+	return items, nil
+}
+
+// Write items as a CSL-JSON list
+func WriteCSLJSON(w io.Writer, items []CSLItem) error {
+	if items == nil {
+		items = []CSLItem{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(items)
+}
+
+// CSL types for BibTeX and biblatex entry types
+var cslTypes = map[string]string{
+	"article":       "article-journal",
+	"book":          "book",
+	"mvbook":        "book",
+	"booklet":       "pamphlet",
+	"inbook":        "chapter",
+	"incollection":  "chapter",
+	"inproceedings": "paper-conference",
+	"conference":    "paper-conference",
+	"manual":        "report",
+	"mastersthesis": "thesis",
+	"phdthesis":     "thesis",
+	"thesis":        "thesis",
+	"misc":          "document",
+	"online":        "webpage",
+	"electronic":    "webpage",
+	"www":           "webpage",
+	"periodical":    "periodical",
+	"proceedings":   "book",
+	"collection":    "book",
+	"reference":     "book",
+	"inreference":   "entry-encyclopedia",
+	"techreport":    "report",
+	"report":        "report",
+	"unpublished":   "manuscript",
+	"patent":        "patent",
+	"dataset":       "dataset",
+	"software":      "software",
+}
+
+// BibTeX entry types for CSL types. Types not listed become misc.
+var cslBibTeXTypes = map[string]string{
+	"article":            "article",
+	"article-journal":    "article",
+	"article-magazine":   "article",
+	"article-newspaper":  "article",
+	"book":               "book",
+	"chapter":            "incollection",
+	"entry":              "incollection",
+	"entry-dictionary":   "incollection",
+	"entry-encyclopedia": "incollection",
+	"paper-conference":   "inproceedings",
+	"report":             "techreport",
+	"thesis":             "phdthesis",
+	"manuscript":         "unpublished",
+	"pamphlet":           "booklet",
+}
+
+// Entry types whose container is a book, so the CSL container-title is the
+// booktitle field rather than the journal
+var bookPartTypes = map[string]bool{
+	"inbook": true, "incollection": true, "inproceedings": true, "conference": true,
+	"inreference": true, "bookinbook": true, "suppbook": true, "suppcollection": true,
+}
+
+// CSL variables for BibTeX fields that hold text. Fields that depend on the
+// entry type are handled by cslVariable.
+var cslTextVars = map[string]string{
+	"title":      "title",
+	"shorttitle": "title-short",
+	"series":     "collection-title",
+	"volume":     "volume",
+	"edition":    "edition",
+	"pages":      "page",
+	"chapter":    "chapter-number",
+	"pagetotal":  "number-of-pages",
+	"address":    "publisher-place",
+	"doi":        "DOI",
+	"url":        "URL",
+	"isbn":       "ISBN",
+	"issn":       "ISSN",
+	"abstract":   "abstract",
+	"keywords":   "keyword",
+	"language":   "language",
+	"annote":     "annote",
+	"type":       "genre",
+	"eventtitle": "event-title",
+	"venue":      "event-place",
+	"version":    "version",
+}
+
+// BibTeX fields for CSL text variables, the reverse of cslTextVars
+var bibtexTextFields = make(map[string]string)
+
+func init() {
+	for field, v := range cslTextVars {
+		bibtexTextFields[v] = field
+	}
+}
+
+// Return the CSL variable for a field of an entry of type *typ*, or "" if
+// there is none
+func cslVariable(typ, field string) string {
+	switch field {
+	case "journal", "journaltitle", "booktitle":
+		return "container-title"
+	case "number":
+		if typ == "article" {
+			return "issue"
+		}
+		return "number"
+	case "issue":
+		return "issue"
+	case "publisher", "school", "institution":
+		return "publisher"
+	}
+	return cslTextVars[field]
+}
+
+// Return the field that holds a CSL variable in an entry of type *typ*
+func bibtexField(typ, v string) string {
+	switch v {
+	case "container-title":
+		if bookPartTypes[typ] {
+			return "booktitle"
+		}
+		return "journal"
+	case "issue":
+		if typ == "article" {
+			return "number"
+		}
+		return "issue"
+	case "number":
+		return "number"
+	case "publisher":
+		switch typ {
+		case "phdthesis", "mastersthesis", "thesis":
+			return "school"
+		case "techreport", "report":
+			return "institution"
+		}
+		return "publisher"
+	}
+	if field, ok := bibtexTextFields[v]; ok {
+		return field
+	}
+	return strings.ToLower(v)
+}
+
+// Fields that ToCSL turns into CSL names
+var cslNameFields = []string{"author", "editor", "translator"}
+
+// Prefix of the lines of a note that hold fields which a format such as
+// CSL-JSON or RIS has no place for, as in "tex.coden: JACOAH"
+const noteFieldPrefix = "tex."
+
+var noteFieldLine = regexp.MustCompile(`^tex\.([a-z0-9_-]+): ?(.*)$`)
+
+// Return an entry as a CSL-JSON item. Values are decoded from LaTeX, and
+// fields that CSL has no variable for are added to the note as lines such
+// as "tex.coden: JACOAH", along with the entry type where CSL would read it
+// back as a different one, so that FromCSL can restore them. Name lists
+// ending in "and others" are listed in a "tex.others" line.
+func ToCSL(e Entry) CSLItem {
+	item := CSLItem{
+		ID:    e.BibTeXkey,
+		Type:  cslTypes[e.Type],
+		Names: make(map[string][]CSLName),
+		Dates: make(map[string]CSLDate),
+		Vars:  make(map[string]string),
+	}
+	if item.Type == "" {
+		item.Type = "document"
+	}
+	var notes []string
+	if cslBibTeXType(item.Type) != e.Type {
+		notes = append(notes, noteFieldPrefix+"entrytype: "+e.Type)
+	}
+
+	used := make(map[string]bool)
+	if r, ok := e.Date(); ok {
+		item.Dates["issued"] = cslDate(r)
+		if e.hasDateField() {
+			used["date"] = true
+		} else {
+			used["year"], used["month"] = true, r.Start.Month != 0
+		}
+	} else if year, ok := e.Fields.Get("year"); ok {
+		// e.g. "in press"
+		item.Dates["issued"] = CSLDate{Literal: UnicodeBibValue(year)}
+		used["year"] = true
+	}
+	if urldate, ok := e.Fields.Get("urldate"); ok {
+		if r, err := ParseDate(UnicodeBibValue(urldate)); err == nil {
+			item.Dates["accessed"] = cslDate(r)
+			used["urldate"] = true
+		}
+	}
+	// CSL has no "and others", so lists that end with it are named in the
+	// note
+	var truncated []string
+	for _, field := range cslNameFields {
+		if value, ok := e.Fields.Get(field); ok {
+			names, others := cslNames(ParseNames(value))
+			item.Names[field] = names
+			used[field] = true
+			if others {
+				truncated = append(truncated, field)
+			}
+		}
+	}
+	if len(truncated) > 0 {
+		notes = append(notes, noteFieldPrefix+"others: "+strings.Join(truncated, ", "))
+	}
+
+	// in the order FromCSL gives them, so that the note doesn't change when
+	// an item is imported and exported again
+	var note string
+	for _, f := range orderFields(e.Fields, DefaultFieldOrder) {
+		name := strings.ToLower(f.Name)
+		if used[name] {
+			continue
+		}
+		value := UnicodeBibValue(f.Value)
+		if verbatimFields[name] {
+			value = strings.TrimSpace(f.Value)
+		}
+		if name == "note" && note == "" {
+			note = value
+			continue
+		}
+		v := cslVariable(e.Type, name)
+		if _, taken := item.Vars[v]; v == "" || taken || bibtexField(e.Type, v) != name {
+			notes = append(notes, noteFieldPrefix+name+": "+value)
+			continue
+		}
+		item.Vars[v] = value
+	}
+	if note != "" {
+		notes = append([]string{note}, notes...)
+	}
+	if len(notes) > 0 {
+		item.Vars["note"] = strings.Join(notes, "\n")
+	}
+	return item
+}
+
+// Return the entry type that FromCSL gives a CSL type
+func cslBibTeXType(typ string) string {
+	if t, ok := cslBibTeXTypes[typ]; ok {
+		return t
+	}
+	return "misc"
+}
+
+func cslDate(r DateRange) CSLDate {
+	if r.OpenStart || r.OpenEnd {
+		return CSLDate{Raw: r.String()}
+	}
+	d := CSLDate{DateParts: [][]int{cslDateParts(r.Start)}}
+	if r.End != r.Start {
+		d.DateParts = append(d.DateParts, cslDateParts(r.End))
+	}
+	for _, date := range []Date{r.Start, r.End} {
+		d.Circa = d.Circa || date.Uncertain || date.Approximate
+	}
+	return d
+}
+
+func cslDateParts(d Date) []int {
+	parts := []int{d.Year}
+	if d.Month != 0 {
+		parts = append(parts, d.Month)
+		if d.Day != 0 {
+			parts = append(parts, d.Day)
+		}
+	}
+	return parts
+}
+
+// Return people as CSL names, and whether the list ended with "and others",
+// which is left out
+func cslNames(people []Person) ([]CSLName, bool) {
+	names := make([]CSLName, 0, len(people))
+	others := false
+	for _, p := range people {
+		if p.IsOthers() {
+			others = true
+			continue
+		}
+		last := UnicodeBibValue(p.Last)
+		// a name in braces, such as "{Barnes and Noble}", isn't split
+		if p.First == "" && p.Von == "" && p.Jr == "" &&
+			strings.HasPrefix(p.Last, "{") && matchingBracket(p.Last, 0, '{', '}') == len(p.Last)-1 {
+			names = append(names, CSLName{Literal: last})
+			continue
+		}
+		names = append(names, CSLName{
+			Family:              last,
+			Given:               UnicodeBibValue(p.First),
+			NonDroppingParticle: UnicodeBibValue(p.Von),
+			Suffix:              UnicodeBibValue(p.Jr),
+		})
+	}
+	return names, others
+}
+
+// Return a CSL-JSON item as an entry, the reverse of ToCSL. Text is escaped
+// for LaTeX, and lines of the note written by ToCSL become fields again.
+// Variables that BibTeX has no field for are kept as fields with the same
+// name in lower case.
+func FromCSL(item CSLItem) Entry {
+	e := Entry{
+		BibTeXkey: item.ID,
+		Type:      cslBibTeXType(item.Type),
+		Fields:    make(Fields, 0, len(item.Vars)+len(item.Names)+len(item.Dates)),
+	}
+
+	// fields from the note come last, and may change the entry type
+	var noteFields Fields
+	var note, truncated []string
+	for _, line := range strings.Split(item.Vars["note"], "\n") {
+		if m := noteFieldLine.FindStringSubmatch(line); m != nil {
+			if m[1] == "entrytype" {
+				e.Type = strings.ToLower(m[2])
+			} else if m[1] == "others" {
+				truncated = append(truncated, splitKeys(strings.ToLower(m[2]))...)
+			} else {
+				noteFields = append(noteFields, Field{m[1], noteValue(m[1], m[2])})
+			}
+		} else if line != "" || len(note) > 0 {
+			note = append(note, line)
+		}
+	}
+
+	names, dates, vars := item.variables()
+	for _, v := range names {
+		people := make([]Person, 0, len(item.Names[v]))
+		for _, n := range item.Names[v] {
+			people = append(people, bibtexPerson(n))
+		}
+		e.Fields.Set(strings.ToLower(v), FormatNames(people))
+	}
+	for _, field := range truncated {
+		if names := e.Field(field); names != "" {
+			e.Fields.Set(field, names+" and others")
+		} else {
+			e.Fields.Set(field, "others")
+		}
+	}
+	for _, v := range dates {
+		d := item.Dates[v]
+		r, ok := d.dateRange()
+		switch {
+		case v == "issued" && ok && r.Single() && r.Start.Day == 0 && !d.Circa:
+			e.Fields.Set("year", strconv.Itoa(r.Start.Year))
+			if r.Start.Month != 0 {
+				e.Fields.Set("month", strconv.Itoa(r.Start.Month))
+			}
+		case v == "issued" && ok:
+			e.Fields.Set("year", strconv.Itoa(r.Year()))
+			e.Fields.Set("date", r.String())
+		case v == "issued":
+			e.Fields.Set("year", EncodeLaTeX(d.text()))
+		case v == "accessed" && ok:
+			e.Fields.Set("urldate", r.String())
+		case ok:
+			e.Fields.Set(strings.ToLower(v), r.String())
+		default:
+			e.Fields.Set(strings.ToLower(v), EncodeLaTeX(d.text()))
+		}
+	}
+	for _, v := range vars {
+		if v == "note" || v == "citation-key" {
+			continue
+		}
+		field := bibtexField(e.Type, v)
+		if !e.Fields.Has(field) {
+			e.Fields.Set(field, noteValue(field, item.Vars[v]))
+		}
+	}
+	if text := strings.TrimSpace(strings.Join(note, "\n")); text != "" {
+		e.Fields.Set("note", EncodeLaTeX(text))
+	}
+	for _, f := range noteFields {
+		if !e.Fields.Has(f.Name) {
+			e.Fields.Set(f.Name, f.Value)
+		}
+	}
+
+	e.Fields = orderFields(e.Fields, DefaultFieldOrder)
+
+	e.Title = UnicodeBibValue(e.Field("title"))
+	e.Author = UnicodeBibValue(e.Field("author"))
+	e.Journal = UnicodeBibValue(e.Field("journal"))
+	e.Year, _ = parseYear(UnicodeBibValue(e.Field("year")))
+	if e.Year == 0 {
+		if r, ok := e.Date(); ok {
+			e.Year = r.Year()
+		}
+	}
+	return e
+}
+
+// Return text as the value of a field, escaped for LaTeX unless the field
+// is verbatim
+func noteValue(field, text string) string {
+	if verbatimFields[field] {
+		return text
+	}
+	return EncodeLaTeX(text)
+}
+
+func bibtexPerson(n CSLName) Person {
+	if n.Literal != "" {
+		if strings.ToLower(n.Literal) == "others" {
+			return Person{Last: "others"}
+		}
+		return Person{Last: "{" + EncodeLaTeX(n.Literal) + "}"}
+	}
+	if n.Family == "" {
+		// a single name, such as Plato
+		return Person{Last: EncodeLaTeX(n.Given)}
+	}
+	von := strings.TrimSpace(n.DroppingParticle + " " + n.NonDroppingParticle)
+	return Person{
+		First: EncodeLaTeX(n.Given),
+		Von:   EncodeLaTeX(von),
+		Last:  EncodeLaTeX(n.Family),
+		Jr:    EncodeLaTeX(n.Suffix),
+	}
+}
+
+// Return the dates as a range, reporting whether they could be read
+func (d CSLDate) dateRange() (DateRange, bool) {
+	if len(d.DateParts) == 0 || len(d.DateParts) > 2 {
+		if d.Raw == "" {
+			return DateRange{}, false
+		}
+		r, err := ParseDate(d.Raw)
+		return r, err == nil
+	}
+	var dates []Date
+	for _, parts := range d.DateParts {
+		var date Date
+		for i, p := range append(parts, 0, 0)[:3] {
+			switch i {
+			case 0:
+				date.Year = p
+			case 1:
+				date.Month = p
+			case 2:
+				date.Day = p
+			}
+		}
+		if date.Month < 0 || date.Month > 12 || date.Day < 0 || date.Day > 31 {
+			return DateRange{}, false
+		}
+		date.Approximate = d.Circa
+		dates = append(dates, date)
+	}
+	r := DateRange{Start: dates[0], End: dates[len(dates)-1]}
+	return r, true
+}
+
+// Return the text of a date that isn't given as parts
+func (d CSLDate) text() string {
+	if d.Literal != "" {
+		return d.Literal
+	}
+	return d.Raw
+}
+
+// Return the names of the item's name, date and text variables, each in
+// alphabetical order
+func (item CSLItem) variables() (names, dates, vars []string) {
+	for v := range item.Names {
+		names = append(names, v)
+	}
+	for v := range item.Dates {
+		dates = append(dates, v)
+	}
+	for v := range item.Vars {
+		vars = append(vars, v)
+	}
+	sort.Strings(names)
+	sort.Strings(dates)
+	sort.Strings(vars)
+	return names, dates, vars
+}
@@ -0,0 +1,179 @@
+package bibtex
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCSLRoundTrip(t *testing.T) {
+	entries := readtoarray("macsyma.bib")
+	if len(entries) == 0 {
+		t.FailNow()
+	}
+	items := make([]CSLItem, len(entries))
+	for i, e := range entries {
+		items[i] = ToCSL(e)
+	}
+	var buf bytes.Buffer
+	if err := WriteCSLJSON(&buf, items); err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	read, err := ReadCSLJSON(&buf)
+	if err != nil || len(read) != len(items) {
+		fmt.Println(len(read), err)
+		t.FailNow()
+	}
+
+	for i, e := range entries {
+		back := FromCSL(read[i])
+		if back.BibTeXkey != e.BibTeXkey || back.Type != e.Type || back.Year != e.Year {
+			fmt.Printf("%s @%s %d read back as %s @%s %d\n", e.BibTeXkey, e.Type, e.Year, back.BibTeXkey, back.Type, back.Year)
+			t.Fail()
+		}
+		for _, f := range e.Fields {
+			name := strings.ToLower(f.Name)
+			v, ok := back.Fields.Get(name)
+			switch {
+			case !ok:
+				fmt.Printf("%s: %s was lost\n", e.BibTeXkey, name)
+				t.Fail()
+			case name == "author" || name == "editor":
+				if a, b := personStrings(ParseNames(f.Value)), personStrings(ParseNames(v)); a != b {
+					fmt.Printf("%s: %s %q read back as %q\n", e.BibTeXkey, name, a, b)
+					t.Fail()
+				}
+			case name == "month":
+				if parseMonth(f.Value) != parseMonth(v) {
+					fmt.Printf("%s: month %q read back as %q\n", e.BibTeXkey, f.Value, v)
+					t.Fail()
+				}
+			case UnicodeBibValue(f.Value) != UnicodeBibValue(v):
+				fmt.Printf("%s: %s %q read back as %q\n", e.BibTeXkey, name, UnicodeBibValue(f.Value), UnicodeBibValue(v))
+				t.Fail()
+			}
+		}
+		if again := ToCSL(back); !reflect.DeepEqual(again, items[i]) {
+			fmt.Printf("%s: %+v exported again as %+v\n", e.BibTeXkey, items[i], again)
+			t.Fail()
+		}
+	}
+}
+
+func personStrings(people []Person) string {
+	names := make([]string, len(people))
+	for i, p := range people {
+		names[i] = p.String()
+	}
+	return strings.Join(names, "; ")
+}
+
+func TestToCSL(t *testing.T) {
+	src := `@phdthesis{vanderveen1990,
+  author = {van der Veen, C. J. and {IGS Working Group} and others},
+  title = {Ice {S}heets \& Oceans},
+  school = {Ohio State University},
+  year = 1990, month = mar,
+  doi = {10.1000/a_b},
+  note = {Unpublished},
+  coden = {XYZ}
+}`
+	bib, err := parseBibliography("", src)
+	if err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	item := ToCSL(bib.Entries[0])
+	if item.ID != "vanderveen1990" || item.Type != "thesis" || item.Vars["title"] != "Ice Sheets & Oceans" ||
+		item.Vars["publisher"] != "Ohio State University" || item.Vars["DOI"] != "10.1000/a_b" {
+		fmt.Printf("%+v\n", item)
+		t.Fail()
+	}
+	authors := item.Names["author"]
+	if len(authors) != 2 || authors[0].Family != "Veen" || authors[0].NonDroppingParticle != "van der" ||
+		authors[0].Given != "C. J." || authors[1].Literal != "IGS Working Group" {
+		fmt.Printf("%+v\n", authors)
+		t.Fail()
+	}
+	if !reflect.DeepEqual(item.Dates["issued"].DateParts, [][]int{{1990, 3}}) {
+		fmt.Println(item.Dates)
+		t.Fail()
+	}
+	if item.Vars["note"] != "Unpublished\ntex.others: author\ntex.coden: XYZ" {
+		fmt.Printf("%q\n", item.Vars["note"])
+		t.Fail()
+	}
+
+	if e := FromCSL(item); e.Field("author") != "van der Veen, C. J. and {IGS Working Group} and others" || e.Fields.Has("others") {
+		fmt.Println(e.Fields)
+		t.Fail()
+	}
+
+	var buf bytes.Buffer
+	WriteCSLJSON(&buf, []CSLItem{item})
+	if !strings.Contains(buf.String(), `"date-parts": [`) || !strings.Contains(buf.String(), `"title": "Ice Sheets & Oceans"`) {
+		fmt.Println(buf.String())
+		t.Fail()
+	}
+}
+
+func TestFromCSL(t *testing.T) {
+	src := `{"id": 42, "type": "chapter", "title": "Calving & {rifts}", "container-title": "Glaciology",
+  "author": [{"family": "Müller", "given": "Fritz"}, {"given": "Plato"}, {"literal": "NASA and NOAA"}],
+  "issued": {"date-parts": [["2001", "6", "15"]], "circa": "1"},
+  "accessed": {"raw": "2020-01-02"},
+  "page": "1-10", "volume": 3, "PMID": "123", "URL": "https://example.org/a_b%20c",
+  "custom": {"x": 1}, "note": "See also.\ntex.entrytype: inbook\ntex.coden: ABC"}`
+	items, err := ReadCSLJSON(strings.NewReader(src))
+	if err != nil || len(items) != 1 {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	e := FromCSL(items[0])
+	expected := map[string]string{
+		"author":    `Müller, Fritz and Plato and {NASA and NOAA}`,
+		"title":     `Calving \& \{rifts\}`,
+		"booktitle": "Glaciology",
+		"year":      "2001",
+		"date":      "2001-06-15~",
+		"urldate":   "2020-01-02",
+		"pages":     "1-10",
+		"volume":    "3",
+		"pmid":      "123",
+		"url":       "https://example.org/a_b%20c",
+		"note":      "See also.",
+		"coden":     "ABC",
+	}
+	if e.BibTeXkey != "42" || e.Type != "inbook" || e.Year != 2001 || e.Title != "Calving & {rifts}" {
+		fmt.Printf("%+v\n", e)
+		t.Fail()
+	}
+	if len(e.Fields) != len(expected) {
+		fmt.Println(e.Fields)
+		t.Fail()
+	}
+	for name, value := range expected {
+		if v := e.Field(name); v != value {
+			fmt.Printf("%s is %q (should be %q)\n", name, v, value)
+			t.Fail()
+		}
+	}
+	if e.Fields[0].Name != "author" || e.Fields[1].Name != "title" {
+		fmt.Println(e.Fields)
+		t.Fail()
+	}
+
+	// as earlier versions wrote it
+	items, _ = ReadCSLJSON(strings.NewReader(`{"id": "a", "editor": [{"family": "Doe"}, {"literal": "others"}]}`))
+	if e := FromCSL(items[0]); e.Field("editor") != "Doe and others" {
+		fmt.Println(e.Fields)
+		t.Fail()
+	}
+
+	if _, err := ReadCSLJSON(strings.NewReader(`[{"id": "a", "issued": {"date-parts": [["spring"]]}}]`)); err == nil {
+		t.Fail()
+	}
+}
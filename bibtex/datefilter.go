@@ -0,0 +1,139 @@
+package bibtex
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// How a date filter treats entries that have no date it can read
+type UndatedPolicy int
+
+const (
+	// Keep work that isn't published yet, such as a year of "in press", as
+	// though it were published this year, and leave out other entries
+	// without a date
+	PendingAsCurrent UndatedPolicy = iota
+	// Leave out every entry without a date
+	ExcludeUndated
+	// Keep every entry without a date
+	IncludeUndated
+)
+
+// Return the policy with the given name: pending, exclude or include
+func ParseUndatedPolicy(name string) (UndatedPolicy, error) {
+	switch strings.ToLower(name) {
+	case "pending":
+		return PendingAsCurrent, nil
+	case "exclude":
+		return ExcludeUndated, nil
+	case "include":
+		return IncludeUndated, nil
+	}
+	return 0, fmt.Errorf("unknown policy %q for entries without a date", name)
+}
+
+// A range of publication dates. Since and Until are open when they are the
+// zero Date, and a bound given only to the year or month covers all of it,
+// so Until 2005 takes in December 2005. An entry matches if any part of its
+// date falls in the range, using the biblatex date field or else the year
+// and month fields.
+type DateFilter struct {
+	Since, Until Date
+	Undated      UndatedPolicy
+	CurrentYear  int // the year pending work is taken to be from, this year if zero
+}
+
+// Two years separated by a hyphen, e.g. 1990-2000, either of which may be
+// left out
+var yearSpan = regexp.MustCompile(`^([0-9]{4})?\s*[-–]\s*([0-9]{4})?$`)
+
+// Parse a range of dates for a DateFilter: a single year or date such as
+// "1995" or "2019-03", two years separated by a hyphen such as "1990-2000"
+// or "1990-", or a biblatex date range such as "2019-03/2020" or "2001/".
+func ParseDateFilter(s string) (DateFilter, error) {
+	var f DateFilter
+	s = strings.TrimSpace(s)
+	if m := yearSpan.FindStringSubmatch(s); m != nil && (m[1] != "" || m[2] != "") {
+		if m[1] != "" {
+			f.Since, _ = parseSingleDate(m[1])
+		}
+		if m[2] != "" {
+			f.Until, _ = parseSingleDate(m[2])
+		}
+		return f, nil
+	}
+	r, err := ParseDate(s)
+	if err != nil {
+		return f, fmt.Errorf("date range %q: %v", s, err)
+	}
+	if !r.OpenStart {
+		f.Since = r.Start
+	}
+	if !r.OpenEnd {
+		f.Until = r.End
+	}
+	return f, nil
+}
+
+// Narrow the range so that it starts no earlier than *since* and ends no
+// later than *until*. A zero Date leaves that end of the range as it was.
+func (f *DateFilter) Narrow(since, until Date) {
+	if since.Year != 0 && (f.Since.Year == 0 || earliest(since) > earliest(f.Since)) {
+		f.Since = since
+	}
+	if until.Year != 0 && (f.Until.Year == 0 || latest(until) < latest(f.Until)) {
+		f.Until = until
+	}
+}
+
+// Reports whether an entry was published in the range
+func (f DateFilter) Match(e Entry) bool {
+	r, ok := e.Date()
+	if !ok {
+		switch {
+		case f.Undated == IncludeUndated:
+			return true
+		case f.Undated == PendingAsCurrent && e.Pending():
+			year := f.CurrentYear
+			if year == 0 {
+				year = time.Now().Year()
+			}
+			r = DateRange{Start: Date{Year: year}, End: Date{Year: year}}
+		default:
+			return false
+		}
+	}
+	if f.Since.Year != 0 && !r.OpenEnd && latest(r.End) < earliest(f.Since) {
+		return false
+	}
+	if f.Until.Year != 0 && !r.OpenStart && earliest(r.Start) > latest(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Return the first day a date could mean as a number that sorts by date
+func earliest(d Date) int {
+	month, day := d.Month, d.Day
+	if month == 0 {
+		month = 1
+	}
+	if day == 0 {
+		day = 1
+	}
+	return d.Year*10000 + month*100 + day
+}
+
+// Return the last day a date could mean as a number that sorts by date
+func latest(d Date) int {
+	month, day := d.Month, d.Day
+	if month == 0 {
+		month = 12
+	}
+	if day == 0 {
+		day = 31
+	}
+	return d.Year*10000 + month*100 + day
+}
@@ -0,0 +1,100 @@
+package bibtex
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDateFilter(t *testing.T) {
+	src := `@article{y1995, year = 1995}
+@article{y2000dec, year = 2000, month = dec}
+@article{y2001mar, year = 2001, month = {March}}
+@article{d2001, date = {2001-06-15}}
+@article{span, date = {1998/2003}}
+@article{open, date = {2010/}}
+@article{letter, year = {2019a}}
+@article{inpress, year = {in press}}
+@article{pubstate, pubstate = {forthcoming}}
+@article{none, title = {Undated}}
+@article{nd, date = {n.d.}, year = 2019}`
+	bib, _ := parseBibliography("", src)
+
+	tests := []struct {
+		filter  string
+		undated UndatedPolicy
+		keys    string
+	}{
+		{"1995", PendingAsCurrent, "y1995"},
+		{"1999-2000", PendingAsCurrent, "y2000dec span"},
+		{"2001", PendingAsCurrent, "y2001mar d2001 span"},
+		{"2001-04/2001-12", PendingAsCurrent, "d2001 span"},
+		{"2001-03", PendingAsCurrent, "y2001mar span"},
+		{"2005-", PendingAsCurrent, "open letter inpress pubstate nd"},
+		{"-1996", PendingAsCurrent, "y1995"},
+		{"2019/", ExcludeUndated, "open letter nd"},
+		{"2019/", IncludeUndated, "open letter inpress pubstate none nd"},
+		{"2000/2010", PendingAsCurrent, "y2000dec y2001mar d2001 span open"},
+	}
+	for _, test := range tests {
+		f, err := ParseDateFilter(test.filter)
+		if err != nil {
+			fmt.Println(test.filter, err)
+			t.Fail()
+			continue
+		}
+		f.Undated = test.undated
+		f.CurrentYear = 2024
+		keys := make([]string, 0)
+		for _, e := range bib.Entries {
+			if f.Match(e) {
+				keys = append(keys, e.BibTeXkey)
+			}
+		}
+		if strings.Join(keys, " ") != test.keys {
+			fmt.Printf("%s (policy %d) matched %v rather than %s\n", test.filter, test.undated, keys, test.keys)
+			t.Fail()
+		}
+	}
+
+	for _, s := range []string{"", "soon", "2001/2002/2003", "/"} {
+		if _, err := ParseDateFilter(s); err == nil {
+			fmt.Println("expected an error for", s)
+			t.Fail()
+		}
+	}
+	if _, err := ParseUndatedPolicy("sometimes"); err == nil {
+		t.Fail()
+	}
+}
+
+func TestDateFilterNarrow(t *testing.T) {
+	f, _ := ParseDateFilter("2000-2005")
+	f.Narrow(Date{Year: 1990}, Date{Year: 2003, Month: 6})
+	if f.Since != (Date{Year: 2000}) || f.Until != (Date{Year: 2003, Month: 6}) {
+		fmt.Printf("%+v\n", f)
+		t.Fail()
+	}
+	f = DateFilter{}
+	f.Narrow(Date{Year: 1990}, Date{})
+	if f.Since != (Date{Year: 1990}) || f.Until.Year != 0 {
+		fmt.Printf("%+v\n", f)
+		t.Fail()
+	}
+}
+
+func TestParseYear(t *testing.T) {
+	tests := map[string]int{"1995": 1995, "2019a": 2019, "1998/99": 1998, "[1850]": 1850, "2001–2002": 2001}
+	for s, expected := range tests {
+		if year, ok := parseYear(s); !ok || year != expected {
+			fmt.Println(s, "gave", year, ok)
+			t.Fail()
+		}
+	}
+	for _, s := range []string{"in press", "19th century", "2019ab", ""} {
+		if _, ok := parseYear(s); ok {
+			fmt.Println("expected no year in", s)
+			t.Fail()
+		}
+	}
+}
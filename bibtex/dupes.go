@@ -0,0 +1,281 @@
+package bibtex
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/njwilson23/peer2/stopwords"
+)
+
+// An entry together with the file it was read from
+type FileEntry struct {
+	File  string
+	Entry Entry
+}
+
+func (fe FileEntry) String() string {
+	if pos := fe.Entry.Pos(); pos.Line != 0 {
+		return fmt.Sprintf("%s:%v", fe.File, pos)
+	}
+	return fe.File
+}
+
+// Two entries that look like the same work. A and B index the entries given
+// to FindDuplicates, and Score runs from 0 to 1.
+type DuplicatePair struct {
+	A, B    int
+	Score   float64
+	Reasons []string
+}
+
+// Entries linked to each other by likely duplicate pairs. Score is that of
+// the most likely pair.
+type DuplicateGroup struct {
+	Entries []int
+	Pairs   []DuplicatePair
+	Score   float64
+}
+
+// What duplicate detection compares for each entry
+type dupeKey struct {
+	doi     string
+	title   string
+	bigrams map[string]int
+	year    int
+	surname string
+}
+
+func newDupeKey(e Entry) dupeKey {
+	k := dupeKey{
+		doi:   NormalizeDOI(e.Field("doi")),
+		title: matchText(e.Field("title")),
+		year:  e.Year,
+	}
+	k.bigrams = bigrams(k.title)
+	for _, person := range e.Authors() {
+		if !person.IsOthers() {
+			k.surname = matchText(person.Surname())
+			break
+		}
+	}
+	return k
+}
+
+// Return a DOI in a form that can be compared: lower case, without a
+// resolver URL or "doi:" in front
+func NormalizeDOI(doi string) string {
+	doi = strings.ToLower(strings.TrimSpace(UnicodeBibValue(doi)))
+	for _, prefix := range []string{"https://", "http://", "dx.doi.org/", "doi.org/", "doi:"} {
+		doi = strings.TrimPrefix(doi, prefix)
+	}
+	return strings.TrimSpace(doi)
+}
+
+// Count the pairs of adjacent characters in each word of *s*
+func bigrams(s string) map[string]int {
+	counts := make(map[string]int)
+	for _, word := range strings.Fields(s) {
+		runes := []rune(word)
+		if len(runes) == 1 {
+			counts[word]++
+		}
+		for i := 0; i+1 < len(runes); i++ {
+			counts[string(runes[i:i+2])]++
+		}
+	}
+	return counts
+}
+
+// Return the Dice coefficient of two sets of bigrams: 1 for the same text,
+// falling towards 0 as the texts differ
+func diceSimilarity(a, b map[string]int) float64 {
+	total, common := 0, 0
+	for bigram, n := range a {
+		total += n
+		if m := b[bigram]; m < n {
+			common += m
+		} else {
+			common += n
+		}
+	}
+	for _, n := range b {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	return 2 * float64(common) / float64(total)
+}
+
+// Titles at least this similar are treated as the same title
+const similarTitle = 0.85
+
+// Score how likely two entries are to be the same work, and say why
+func scoreDuplicate(a, b dupeKey) (float64, []string) {
+	var score float64
+	reasons := make([]string, 0)
+	if a.doi != "" && a.doi == b.doi {
+		score = 1
+		reasons = append(reasons, "same DOI")
+	}
+	sim := 0.0
+	if a.title != "" && b.title != "" {
+		sim = diceSimilarity(a.bigrams, b.bigrams)
+	}
+	yearAuthor := a.year != 0 && a.year == b.year && a.surname != "" && a.surname == b.surname
+	switch {
+	case sim >= similarTitle:
+		s := 0.9 * sim
+		if yearAuthor {
+			s += 0.1
+		}
+		if s > score {
+			score = s
+		}
+		reasons = append(reasons, fmt.Sprintf("similar title (%.2f)", sim))
+	case yearAuthor && sim >= 0.5:
+		if s := 0.4 + 0.3*sim; s > score {
+			score = s
+		}
+	case yearAuthor:
+		if score < 0.3 {
+			score = 0.3
+		}
+	}
+	if yearAuthor {
+		reasons = append(reasons, "same year and first author")
+	}
+	if gap := a.year - b.year; a.year != 0 && b.year != 0 && (gap > 1 || gap < -1) {
+		// more likely a new edition than a preprint and its publication
+		score *= 0.7
+		reasons = append(reasons, "different years")
+	}
+	if a.doi != "" && b.doi != "" && a.doi != b.doi {
+		score /= 2
+		reasons = append(reasons, "different DOIs")
+	}
+	return score, reasons
+}
+
+// Return the pairs of entries that may need comparing: those sharing a DOI,
+// a year and first author, or an uncommon title word
+func candidatePairs(keys []dupeKey) [][2]int {
+	buckets := make(map[string][]int)
+	stop := stopwords.English()
+	for i, k := range keys {
+		if k.doi != "" {
+			buckets["doi "+k.doi] = append(buckets["doi "+k.doi], i)
+		}
+		if k.year != 0 && k.surname != "" {
+			id := fmt.Sprintf("year %d %s", k.year, k.surname)
+			buckets[id] = append(buckets[id], i)
+		}
+		seen := make(map[string]bool)
+		for _, word := range strings.Fields(k.title) {
+			if len(word) > 3 && !stop[word] && !seen[word] {
+				seen[word] = true
+				buckets["word "+word] = append(buckets["word "+word], i)
+			}
+		}
+	}
+	seen := make(map[[2]int]bool)
+	pairs := make([][2]int, 0)
+	for _, bucket := range buckets {
+		for x, i := range bucket {
+			for _, j := range bucket[x+1:] {
+				if pair := [2]int{i, j}; !seen[pair] {
+					seen[pair] = true
+					pairs = append(pairs, pair)
+				}
+			}
+		}
+	}
+	sort.Slice(pairs, func(x, y int) bool {
+		if pairs[x][0] != pairs[y][0] {
+			return pairs[x][0] < pairs[y][0]
+		}
+		return pairs[x][1] < pairs[y][1]
+	})
+	return pairs
+}
+
+// Find entries that look like the same work, matching on DOI, on title
+// similarity after decoding accents, and on year and first author. Pairs
+// scoring at least *threshold* are grouped, most likely groups first.
+func FindDuplicates(entries []FileEntry, threshold float64) []DuplicateGroup {
+	keys := make([]dupeKey, len(entries))
+	for i, fe := range entries {
+		keys[i] = newDupeKey(fe.Entry)
+	}
+
+	// union-find over the pairs that are likely enough
+	parent := make([]int, len(entries))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	var pairs []DuplicatePair
+	for _, pair := range candidatePairs(keys) {
+		score, reasons := scoreDuplicate(keys[pair[0]], keys[pair[1]])
+		if score >= threshold && score > 0 {
+			pairs = append(pairs, DuplicatePair{pair[0], pair[1], score, reasons})
+			parent[find(pair[0])] = find(pair[1])
+		}
+	}
+
+	byRoot := make(map[int]*DuplicateGroup)
+	groups := make([]*DuplicateGroup, 0)
+	for _, pair := range pairs {
+		root := find(pair.A)
+		g, ok := byRoot[root]
+		if !ok {
+			g = &DuplicateGroup{}
+			byRoot[root] = g
+			groups = append(groups, g)
+		}
+		g.Pairs = append(g.Pairs, pair)
+		if pair.Score > g.Score {
+			g.Score = pair.Score
+		}
+	}
+	for i := range entries {
+		if g, ok := byRoot[find(i)]; ok {
+			g.Entries = append(g.Entries, i)
+		}
+	}
+
+	result := make([]DuplicateGroup, len(groups))
+	for i, g := range groups {
+		result[i] = *g
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Score > result[j].Score })
+	return result
+}
+
+// Return the groups of entries that share a citation key, ignoring case as
+// BibTeX does
+func DuplicateKeys(entries []FileEntry) [][]int {
+	byKey := make(map[string][]int)
+	order := make([]string, 0)
+	for i, fe := range entries {
+		key := strings.ToLower(fe.Entry.BibTeXkey)
+		if _, ok := byKey[key]; !ok {
+			order = append(order, key)
+		}
+		byKey[key] = append(byKey[key], i)
+	}
+	groups := make([][]int, 0)
+	for _, key := range order {
+		if len(byKey[key]) > 1 {
+			groups = append(groups, byKey[key])
+		}
+	}
+	return groups
+}
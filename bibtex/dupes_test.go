@@ -0,0 +1,86 @@
+package bibtex
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNormalizeDOI(t *testing.T) {
+	for _, doi := range []string{"10.1029/2009JF001405", "https://doi.org/10.1029/2009jf001405",
+		"http://dx.doi.org/10.1029/2009JF001405", " doi:10.1029/2009JF001405"} {
+		if NormalizeDOI(doi) != "10.1029/2009jf001405" {
+			fmt.Println("unexpected normalization of", doi, NormalizeDOI(doi))
+			t.Fail()
+		}
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	src := `@article{Amundson2010, author = {Amundson, J. M.}, year = 2010, doi = {10.1029/2009JF001405},
+  title = {Ice m\'elange dynamics and implications for terminus stability}}
+@article{amundson10, author = {J. Amundson}, year = 2010,
+  title = {Ice melange dynamics and implications for terminus stability.}}
+@article{amundson-jgr, doi = {https://doi.org/10.1029/2009jf001405}, title = {Something else}}
+@article{Nye1952, author = {Nye, J. F.}, year = 1952, title = {The mechanics of glacier flow}}
+@article{Nye1952b, author = {Nye, J. F.}, year = 1952, title = {A method of calculating the thicknesses of the ice-sheets}}
+@article{nye1952, author = {Nye, John}, year = 1951, title = {The flow of glaciers and ice-sheets}}`
+	bib, err := parseBibliography("", src)
+	if err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	entries := make([]FileEntry, len(bib.Entries))
+	for i, e := range bib.Entries {
+		entries[i] = FileEntry{"refs.bib", e}
+	}
+
+	groups := FindDuplicates(entries, 0.5)
+	if len(groups) != 1 || len(groups[0].Entries) != 3 || groups[0].Score != 1 {
+		fmt.Println("unexpected groups:", groups)
+		t.FailNow()
+	}
+	for _, pair := range groups[0].Pairs {
+		if pair.A == 0 && pair.B == 1 && (pair.Score < 0.9 || len(pair.Reasons) != 2) {
+			fmt.Println("unexpected title match:", pair)
+			t.Fail()
+		}
+	}
+	if groups := FindDuplicates(entries, 0.3); len(groups) != 2 {
+		fmt.Println("expected the same year and author to match at a low threshold:", groups)
+		t.Fail()
+	}
+
+	keys := DuplicateKeys(entries)
+	if len(keys) != 1 || len(keys[0]) != 2 || keys[0][0] != 3 {
+		fmt.Println("unexpected duplicate keys:", keys)
+		t.Fail()
+	}
+	if entries[5].String() != "refs.bib:8:1" {
+		fmt.Println("unexpected location:", entries[5])
+		t.Fail()
+	}
+}
+
+func TestFindDuplicatesMacsyma(t *testing.T) {
+	entries := make([]FileEntry, 0)
+	for _, e := range readtoarray("macsyma.bib") {
+		entries = append(entries, FileEntry{"macsyma.bib", e})
+	}
+	found := false
+	for _, g := range FindDuplicates(entries, 0.95) {
+		for _, pair := range g.Pairs {
+			a, b := entries[pair.A].Entry.BibTeXkey, entries[pair.B].Entry.BibTeXkey
+			if a == "Fateman:1989:RM" && b == "Guizani:1989:RM" && pair.Score == 1 {
+				found = true
+			}
+		}
+	}
+	if !found {
+		fmt.Println("entries with the same DOI not found")
+		t.Fail()
+	}
+	if keys := DuplicateKeys(entries); len(keys) != 0 {
+		fmt.Println("unexpected duplicate keys:", keys)
+		t.Fail()
+	}
+}
@@ -0,0 +1,83 @@
+package bibtex
+
+import "strings"
+
+// A single `name = value` pair from a BibTeX entry. Name is kept as written
+// in the source, and Value holds the LaTeX text of the value without its
+// enclosing delimiters.
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Fields of an entry in the order they were written. Lookups ignore the case
+// of field names, as BibTeX does.
+type Fields []Field
+
+func (fs Fields) index(name string) int {
+	for i, f := range fs {
+		if strings.EqualFold(f.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Return the value of a field and whether it is present
+func (fs Fields) Get(name string) (string, bool) {
+	if i := fs.index(name); i != -1 {
+		return fs[i].Value, true
+	}
+	return "", false
+}
+
+// Reports whether a field is present
+func (fs Fields) Has(name string) bool {
+	return fs.index(name) != -1
+}
+
+// Set the value of a field, replacing it in place if it is already present
+// and appending it otherwise
+func (fs *Fields) Set(name, value string) {
+	if i := fs.index(name); i != -1 {
+		(*fs)[i].Value = value
+		return
+	}
+	*fs = append(*fs, Field{name, value})
+}
+
+// Remove a field if it is present
+func (fs *Fields) Delete(name string) {
+	if i := fs.index(name); i != -1 {
+		*fs = append((*fs)[:i:i], (*fs)[i+1:]...)
+	}
+}
+
+// Rename a field in place, keeping its value and position. Nothing changes
+// unless *old* is present and *new* isn't, and the result reports whether
+// the field was renamed.
+func (fs Fields) Rename(old, new string) bool {
+	i := fs.index(old)
+	if i == -1 || (fs.index(new) != -1 && !strings.EqualFold(old, new)) {
+		return false
+	}
+	fs[i].Name = new
+	return true
+}
+
+// Return the field names in order
+func (fs Fields) Names() []string {
+	names := make([]string, len(fs))
+	for i, f := range fs {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// Return a copy that can be modified without affecting the original
+func (fs Fields) Copy() Fields {
+	if fs == nil {
+		return nil
+	}
+	return append(Fields{}, fs...)
+}
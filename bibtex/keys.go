@@ -0,0 +1,322 @@
+package bibtex
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/njwilson23/peer2/stopwords"
+)
+
+// Pattern for citation keys used when none is configured
+const DefaultKeyPattern = "[auth][year]"
+
+// A citation key pattern in the style of JabRef, such as
+// "[auth][year][shorttitle]". Text in square brackets is a marker that is
+// replaced by part of the entry, and anything else is copied. Markers are:
+//
+//	[auth]            last name of the first author (or editor)
+//	[authN]           the first N letters of it
+//	[authors]         last names of all the authors
+//	[authorsN]        last names of the first N authors, then "EtAl"
+//	[auth.etal]       "Smith", "Smith.Jones" or "Smith.etal"
+//	[authEtAl]        "Smith", "SmithAndJones" or "SmithEtAl"
+//	[authorLast]      last name of the last author
+//	[year]            four digit year
+//	[shortyear]       last two digits of the year
+//	[title]           the words of the title, capitalized
+//	[shorttitle]      the first three words of the title
+//	[veryshorttitle]  the first word of the title
+//	[firstpage]       first page number
+//	[lastpage]        last page number
+//	[FIELD]           the value of any other field
+//
+// Stopwords are left out of titles. A marker may be followed by modifiers,
+// as in [title:abbr:upper]: lower, upper, and abbr, which keeps the first
+// letter of each word.
+type KeyPattern struct {
+	parts []keyPart
+}
+
+type keyPart struct {
+	literal   string
+	marker    string
+	modifiers []string
+}
+
+// Parse a citation key pattern
+func ParseKeyPattern(pattern string) (KeyPattern, error) {
+	var p KeyPattern
+	for pattern != "" {
+		open := strings.IndexByte(pattern, '[')
+		if open == -1 {
+			p.parts = append(p.parts, keyPart{literal: pattern})
+			break
+		}
+		if open > 0 {
+			p.parts = append(p.parts, keyPart{literal: pattern[:open]})
+		}
+		end := strings.IndexByte(pattern[open:], ']')
+		if end == -1 {
+			return p, fmt.Errorf("unterminated marker %q in key pattern", pattern[open:])
+		}
+		pieces := strings.Split(pattern[open+1:open+end], ":")
+		if pieces[0] == "" {
+			return p, fmt.Errorf("empty marker in key pattern")
+		}
+		for _, modifier := range pieces[1:] {
+			if modifier != "lower" && modifier != "upper" && modifier != "abbr" {
+				return p, fmt.Errorf("unknown modifier %q in key pattern", modifier)
+			}
+		}
+		p.parts = append(p.parts, keyPart{marker: pieces[0], modifiers: pieces[1:]})
+		pattern = pattern[open+end+1:]
+	}
+	return p, nil
+}
+
+// Return the key the pattern gives for an entry, in ASCII and without
+// characters that BibTeX doesn't allow in keys. The key is empty if none of
+// the markers could be filled in.
+func (p KeyPattern) Key(e Entry) string {
+	var buf strings.Builder
+	filled := false
+	for _, part := range p.parts {
+		if part.marker == "" {
+			buf.WriteString(cleanKey(part.literal))
+			continue
+		}
+		words := keyMarker(e, part.marker)
+		for _, modifier := range part.modifiers {
+			for i, w := range words {
+				switch modifier {
+				case "lower":
+					words[i] = strings.ToLower(w)
+				case "upper":
+					words[i] = strings.ToUpper(w)
+				case "abbr":
+					words[i] = w[:1]
+				}
+			}
+		}
+		text := cleanKey(strings.Join(words, ""))
+		filled = filled || text != ""
+		buf.WriteString(text)
+	}
+	if !filled {
+		return ""
+	}
+	return buf.String()
+}
+
+// Return the pieces of an entry that a marker stands for, as non-empty words
+func keyMarker(e Entry, marker string) []string {
+	people := e.Authors()
+	if len(people) == 0 {
+		people = e.Editors()
+	}
+	names := make([]string, 0, len(people))
+	etal := false
+	for _, person := range people {
+		if person.IsOthers() {
+			etal = true
+		} else if last := keyWord(person.Last); last != "" {
+			names = append(names, last)
+		}
+	}
+
+	switch {
+	case marker == "auth":
+		return firstN(names, 1)
+	case marker == "authors":
+		return names
+	case marker == "authorLast":
+		if len(names) == 0 {
+			return nil
+		}
+		return names[len(names)-1:]
+	case marker == "auth.etal", marker == "authEtAl":
+		sep, more := ".", ".etal"
+		if marker == "authEtAl" {
+			sep, more = "And", "EtAl"
+		}
+		switch {
+		case len(names) == 0:
+			return nil
+		case len(names) > 2 || (etal && len(names) == 2):
+			return []string{names[0], more}
+		case len(names) == 2:
+			return []string{names[0], sep, names[1]}
+		case etal:
+			return []string{names[0], more}
+		}
+		return names[:1]
+	case strings.HasPrefix(marker, "authors") && isDigits(marker[len("authors"):]):
+		n, _ := strconv.Atoi(marker[len("authors"):])
+		words := firstN(names, n)
+		if len(names) > n || etal {
+			words = append(words, "EtAl")
+		}
+		return words
+	case strings.HasPrefix(marker, "auth") && isDigits(marker[len("auth"):]):
+		n, _ := strconv.Atoi(marker[len("auth"):])
+		if len(names) == 0 {
+			return nil
+		}
+		runes := []rune(names[0])
+		if len(runes) > n {
+			runes = runes[:n]
+		}
+		return []string{string(runes)}
+	case marker == "year" || marker == "shortyear":
+		if e.Year == 0 {
+			return nil
+		}
+		year := strconv.Itoa(e.Year)
+		if marker == "shortyear" && len(year) > 2 {
+			year = year[len(year)-2:]
+		}
+		return []string{year}
+	case marker == "title":
+		return titleWords(e.Field("title"))
+	case marker == "shorttitle":
+		return firstN(titleWords(e.Field("title")), 3)
+	case marker == "veryshorttitle":
+		return firstN(titleWords(e.Field("title")), 1)
+	case marker == "firstpage" || marker == "lastpage":
+		pages := strings.FieldsFunc(UnicodeBibValue(e.Field("pages")), func(r rune) bool {
+			return r == '-' || r == '–' || r == '—' || r == ',' || unicode.IsSpace(r)
+		})
+		if len(pages) == 0 {
+			return nil
+		}
+		if marker == "firstpage" {
+			return pages[:1]
+		}
+		return pages[len(pages)-1:]
+	}
+	return nonEmpty(strings.Fields(transliterate(UnicodeBibValue(e.Field(marker)))))
+}
+
+// Return the significant words of a title, capitalized and in ASCII
+func titleWords(title string) []string {
+	stop := stopwords.English()
+	words := make([]string, 0)
+	for _, word := range strings.Fields(transliterate(UnicodeBibValue(title))) {
+		word = cleanKey(word)
+		if word == "" || stop[strings.ToLower(word)] {
+			continue
+		}
+		words = append(words, strings.ToUpper(word[:1])+word[1:])
+	}
+	return words
+}
+
+// Return a name part as a single ASCII word, e.g. "OBrien" for "O'Brien"
+func keyWord(s string) string {
+	return cleanKey(strings.Join(strings.Fields(transliterate(UnicodeBibValue(s))), ""))
+}
+
+// Remove the characters that shouldn't be used in a citation key
+func cleanKey(s string) string {
+	var buf strings.Builder
+	for _, r := range transliterate(s) {
+		if isLetter(byte(r)) || (r >= '0' && r <= '9') || strings.ContainsRune("-_:.+", r) {
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
+
+func firstN(words []string, n int) []string {
+	if len(words) > n {
+		return words[:n]
+	}
+	return words
+}
+
+func nonEmpty(words []string) []string {
+	kept := words[:0]
+	for _, w := range words {
+		if w != "" {
+			kept = append(kept, w)
+		}
+	}
+	return kept
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// Return new keys for entries from a pattern. Keys that come out the same,
+// ignoring case, get the suffixes a, b, c and so on in the order the entries
+// are given, and an entry keeps its key if the pattern gives it none. Keys in
+// *taken*, such as those of other entries in the same file, are avoided in
+// the same way; it may be nil.
+func GenerateKeys(entries []Entry, p KeyPattern, taken map[string]bool) []string {
+	keys := make([]string, len(entries))
+	used := make(map[string]bool, len(taken))
+	for key := range taken {
+		used[strings.ToLower(key)] = true
+	}
+	taken = used
+	counts := make(map[string]int)
+	for i, e := range entries {
+		keys[i] = p.Key(e)
+		if keys[i] == "" {
+			keys[i] = e.BibTeXkey
+			taken[strings.ToLower(keys[i])] = true
+		} else {
+			counts[strings.ToLower(keys[i])]++
+		}
+	}
+	next := make(map[string]int)
+	for i, e := range entries {
+		base := strings.ToLower(keys[i])
+		if keys[i] == e.BibTeXkey && counts[base] == 0 {
+			continue // kept
+		}
+		if counts[base] == 1 && !taken[base] {
+			taken[base] = true
+			continue
+		}
+		for {
+			suffixed := keys[i] + keySuffix(next[base])
+			next[base]++
+			if !taken[strings.ToLower(suffixed)] && counts[strings.ToLower(suffixed)] == 0 {
+				keys[i] = suffixed
+				taken[strings.ToLower(suffixed)] = true
+				break
+			}
+		}
+	}
+	return keys
+}
+
+// Return *key*, or if it is in *taken* the key with the first suffix that
+// isn't, as GenerateKeys would give it. The keys of *taken* are in lower
+// case, and the result is added to them.
+func UniqueKey(key string, taken map[string]bool) string {
+	unique := key
+	for n := 0; taken[strings.ToLower(unique)]; n++ {
+		unique = key + keySuffix(n)
+	}
+	taken[strings.ToLower(unique)] = true
+	return unique
+}
+
+// Return the suffix for the nth entry sharing a key: a to z, then aa, ab...
+func keySuffix(n int) string {
+	suffix := ""
+	for n++; n > 0; n = (n - 1) / 26 {
+		suffix = string(rune('a'+(n-1)%26)) + suffix
+	}
+	return suffix
+}
@@ -0,0 +1,81 @@
+package bibtex
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestKeyPattern(t *testing.T) {
+	entries := readtoarray("test.bib")
+	amundson := entries[1]
+	tests := map[string]string{
+		"[auth][year]":                  "Amundson2010",
+		"[auth][year][shorttitle]":      "Amundson2010IceMelangeDynamics",
+		"[auth4]:[shortyear]":           "Amun:10",
+		"[authors2]":                    "AmundsonFahnestockEtAl",
+		"[auth.etal][veryshorttitle]":   "Amundson.etalIce",
+		"[authEtAl]_[title:abbr:lower]": "AmundsonEtAl_imditsjig",
+		"[authorLast]-[firstpage]":      "Motyka-F01005",
+		"[journal:upper]":               "JOURNALOFGEOPHYSICALRESEARCH",
+		"[auth][missingfield]":          "Amundson",
+		"[missingfield]":                "",
+	}
+	for pattern, expected := range tests {
+		p, err := ParseKeyPattern(pattern)
+		if err != nil {
+			fmt.Println(err)
+			t.Fail()
+			continue
+		}
+		if key := p.Key(amundson); key != expected {
+			fmt.Println(pattern, "gave", key, "rather than", expected)
+			t.Fail()
+		}
+	}
+
+	e := Entry{Fields: Fields{{"author", `Erd{\H o}s, Paul and O'Brien, J. and Gau{\ss}, C. F.`}, {"pages", "82--93"}}}
+	p, _ := ParseKeyPattern("[auth.etal]/[authors][lastpage]")
+	if key := p.Key(e); key != "Erdos.etalErdosOBrienGauss93" {
+		fmt.Println("unexpected transliteration:", key)
+		t.Fail()
+	}
+
+	for _, pattern := range []string{"[auth", "[]", "[title:short]"} {
+		if _, err := ParseKeyPattern(pattern); err == nil {
+			fmt.Println("expected an error for", pattern)
+			t.Fail()
+		}
+	}
+}
+
+func TestGenerateKeys(t *testing.T) {
+	src := `@article{x1, author = {Nye, J. F.}, year = 1952}
+@article{x2, author = {Nye, J. F.}, year = 1952}
+@article{x3, author = {Nye, J.}, year = 1951}
+@article{Nye1951a, title = {No author or year}}
+@article{x5, author = {Nye, J. F.}, year = 1951}`
+	bib, _ := parseBibliography("", src)
+	p, _ := ParseKeyPattern("[auth][year]")
+	keys := GenerateKeys(bib.Entries, p, nil)
+	expected := "Nye1952a,Nye1952b,Nye1951b,Nye1951a,Nye1951c"
+	if strings.Join(keys, ",") != expected {
+		fmt.Println("unexpected keys:", keys)
+		t.Fail()
+	}
+
+	// keys already in use get suffixes too
+	keys = GenerateKeys(bib.Entries[2:3], p, map[string]bool{"nye1951": true})
+	if keys[0] != "Nye1951a" {
+		fmt.Println("unexpected keys:", keys)
+		t.Fail()
+	}
+	taken := map[string]bool{"nye1952": true, "nye1952a": true}
+	if UniqueKey("Nye1952", taken) != "Nye1952b" || !taken["nye1952b"] || UniqueKey("Glen1955", taken) != "Glen1955" {
+		fmt.Println("unexpected unique keys:", taken)
+		t.Fail()
+	}
+	if keySuffix(0) != "a" || keySuffix(25) != "z" || keySuffix(26) != "aa" || keySuffix(27) != "ab" {
+		t.Fail()
+	}
+}
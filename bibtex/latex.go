@@ -0,0 +1,231 @@
+package bibtex
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Combining marks for the LaTeX accent commands
+var accentMarks = map[string]rune{
+	"`":  '̀', // grave
+	"'":  '́', // acute
+	"^":  '̂', // circumflex
+	"~":  '̃', // tilde
+	"=":  '̄', // macron
+	"u":  '̆', // breve
+	".":  '̇', // dot above
+	"\"": '̈', // umlaut
+	"r":  '̊', // ring
+	"H":  '̋', // long umlaut
+	"v":  '̌', // caron
+	"d":  '̣', // dot below
+	"c":  '̧', // cedilla
+	"k":  '̨', // ogonek
+	"b":  '̱', // bar below
+	"t":  '͡', // tie
+}
+
+// Commands that stand for a character on their own
+var latexSymbols = map[string]string{
+	// letters and ligatures
+	"aa": "å", "AA": "Å", "ae": "æ", "AE": "Æ", "oe": "œ", "OE": "Œ",
+	"o": "ø", "O": "Ø", "l": "ł", "L": "Ł", "ss": "ß", "SS": "SS",
+	"i": "ı", "j": "ȷ", "dh": "ð", "DH": "Ð", "th": "þ", "TH": "Þ",
+	"ng": "ŋ", "NG": "Ŋ", "dj": "đ", "DJ": "Đ",
+	// escaped characters
+	"&": "&", "%": "%", "$": "$", "#": "#", "_": "_", "{": "{", "}": "}",
+	" ": " ", ",": " ", ";": " ", ":": " ", "!": "", "/": "", "-": "", "\\": " ",
+	// text symbols
+	"textendash": "–", "textemdash": "—", "ldots": "…", "dots": "…", "textellipsis": "…",
+	"textquoteleft": "‘", "textquoteright": "’", "textquotedblleft": "“", "textquotedblright": "”",
+	"guillemotleft": "«", "guillemotright": "»", "textbackslash": "\\", "textasciitilde": "~",
+	"textasciicircum": "^", "textunderscore": "_", "textbar": "|", "textless": "<", "textgreater": ">",
+	"S": "§", "P": "¶", "copyright": "©", "textcopyright": "©", "textregistered": "®",
+	"texttrademark": "™", "pounds": "£", "textsterling": "£", "euro": "€", "texteuro": "€",
+	"textdegree": "°", "degree": "°", "dag": "†", "ddag": "‡", "textbullet": "•",
+	"textperthousand": "‰", "textmu": "µ", "LaTeX": "LaTeX", "TeX": "TeX", "BibTeX": "BibTeX",
+	// math
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ε", "varepsilon": "ε",
+	"zeta": "ζ", "eta": "η", "theta": "θ", "iota": "ι", "kappa": "κ", "lambda": "λ",
+	"mu": "μ", "nu": "ν", "xi": "ξ", "pi": "π", "rho": "ρ", "sigma": "σ", "tau": "τ",
+	"upsilon": "υ", "phi": "φ", "varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π",
+	"Sigma": "Σ", "Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+	"times": "×", "pm": "±", "cdot": "·", "infty": "∞", "partial": "∂", "nabla": "∇",
+	"leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠", "approx": "≈", "sim": "∼",
+	"to": "→", "rightarrow": "→", "leftarrow": "←", "circ": "∘", "prime": "′",
+}
+
+// Character pairs that TeX fonts turn into a single glyph
+var quoteLigatures = map[string]string{
+	"``": "“", "''": "”", "!`": "¡", "?`": "¿",
+}
+
+// Commands whose argument is dropped along with the command
+var latexDropArgument = map[string]bool{
+	"noopsort": true, "SortNoop": true, "nocite": true, "label": true,
+}
+
+// Characters that LaTeX treats specially, and how to write them as text
+var latexEscapes = strings.NewReplacer(
+	`\`, `\textbackslash{}`, "{", `\{`, "}", `\}`, "&", `\&`, "%", `\%`, "$", `\$`,
+	"#", `\#`, "_", `\_`, "~", `\textasciitilde{}`, "^", `\textasciicircum{}`,
+)
+
+// Return plain text as LaTeX by escaping the characters LaTeX treats
+// specially, the reverse of DecodeLaTeX. Other characters are left as they
+// are, since BibTeX files can be written in UTF-8.
+func EncodeLaTeX(s string) string {
+	return latexEscapes.Replace(s)
+}
+
+// Return the text of a LaTeX string as Unicode, decoding accents, special
+// letters, dashes, quote ligatures and common text commands. Braces and math
+// shifts are removed, formatting commands such as \emph are dropped in
+// favour of their argument, and whitespace runs become single spaces.
+func DecodeLaTeX(s string) string {
+	d := latexDecoder{src: s}
+	return collapseSpace(norm.NFC.String(d.decode(-1)))
+}
+
+type latexDecoder struct {
+	src string
+	off int
+}
+
+func (d *latexDecoder) eof() bool {
+	return d.off >= len(d.src)
+}
+
+// Decode until the end of input, or until the closing brace when *depth* is
+// not negative
+func (d *latexDecoder) decode(depth int) string {
+	var buf strings.Builder
+	for !d.eof() {
+		c := d.src[d.off]
+		switch c {
+		case '{':
+			d.off++
+			buf.WriteString(d.decode(0))
+		case '}':
+			d.off++
+			if depth >= 0 {
+				return buf.String()
+			}
+		case '$':
+			d.off++
+		case '~':
+			d.off++
+			buf.WriteByte(' ')
+		case '-':
+			switch {
+			case strings.HasPrefix(d.src[d.off:], "---"):
+				buf.WriteString("—")
+				d.off += 3
+			case strings.HasPrefix(d.src[d.off:], "--"):
+				buf.WriteString("–")
+				d.off += 2
+			default:
+				buf.WriteByte(c)
+				d.off++
+			}
+		case '`', '\'', '!', '?':
+			d.off++
+			pair := string(c)
+			if !d.eof() {
+				pair += string(d.src[d.off])
+			}
+			if lig, ok := quoteLigatures[pair]; ok {
+				d.off++
+				buf.WriteString(lig)
+			} else if c == '`' {
+				buf.WriteString("‘")
+			} else {
+				buf.WriteByte(c)
+			}
+		case '\\':
+			buf.WriteString(d.command())
+		default:
+			buf.WriteByte(c)
+			d.off++
+		}
+	}
+	return buf.String()
+}
+
+// Read a control sequence name after a backslash
+func (d *latexDecoder) commandName() string {
+	d.off++ // backslash
+	if d.eof() {
+		return ""
+	}
+	start := d.off
+	for !d.eof() && isLetter(d.src[d.off]) {
+		d.off++
+	}
+	if d.off == start {
+		// control symbol, e.g. \' or \&
+		_, size := utf8.DecodeRuneInString(d.src[d.off:])
+		d.off += size
+		return d.src[start:d.off]
+	}
+	name := d.src[start:d.off]
+	// TeX ignores spaces after a control word
+	for !d.eof() && (d.src[d.off] == ' ' || d.src[d.off] == '\t' || d.src[d.off] == '\n') {
+		d.off++
+	}
+	return name
+}
+
+// Decode the command at the current backslash
+func (d *latexDecoder) command() string {
+	name := d.commandName()
+	if mark, ok := accentMarks[name]; ok {
+		return d.accent(mark)
+	}
+	if sym, ok := latexSymbols[name]; ok {
+		return sym
+	}
+	if latexDropArgument[name] {
+		d.argument()
+		return ""
+	}
+	// formatting commands and anything unknown leave their arguments behind
+	return ""
+}
+
+// Apply an accent to the argument that follows it
+func (d *latexDecoder) accent(mark rune) string {
+	for !d.eof() && d.src[d.off] == ' ' {
+		d.off++
+	}
+	arg := d.argument()
+	// accents go on an undotted i or j as a plain i or j
+	arg = strings.NewReplacer("ı", "i", "ȷ", "j").Replace(arg)
+	if arg == "" {
+		return string(mark)
+	}
+	_, size := utf8.DecodeRuneInString(arg)
+	return arg[:size] + string(mark) + arg[size:]
+}
+
+// Decode a single argument: a braced group, a command or one character
+func (d *latexDecoder) argument() string {
+	if d.eof() {
+		return ""
+	}
+	switch d.src[d.off] {
+	case '{':
+		d.off++
+		return d.decode(0)
+	case '\\':
+		return d.command()
+	case '}':
+		return ""
+	}
+	_, size := utf8.DecodeRuneInString(d.src[d.off:])
+	d.off += size
+	return d.src[d.off-size : d.off]
+}
@@ -0,0 +1,47 @@
+package bibtex
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDecodeLaTeX(t *testing.T) {
+	cases := []struct{ in, out string }{
+		{`\"o \"u \"{a}`, "ö ü ä"},
+		{`\'e {\'e} \'{e} {\' e}`, "é é é é"},
+		{`\v{c} \v c \c{c} \c c \k{a} \H{o} \r{u} \u{a} \={o} \.{z}`, "č č ç ç ą ő ů ă ō ż"},
+		{`\l\o\ss\i \L{} {\O}`, "łøßıŁ Ø"},
+		{`\'{\i} \"\i{} \^{\j}`, "í ï ĵ"},
+		{`M{\aa}rtensson`, "Mårtensson"},
+		{`Nioghalvfjerdsbr{\ae}`, "Nioghalvfjerdsbræ"},
+		{`{\AE}sir {\oe}uvre`, "Æsir œuvre"},
+		{`Erd\H{o}s, Ko\v{c}\'{\i}, {\L}ukasiewicz`, "Erdős, Kočí, Łukasiewicz"},
+		{`pages 12--34 --- a dash`, "pages 12–34 — a dash"},
+		{"``quoted'' and `single'", "“quoted” and ‘single'"},
+		{`Greenland's {J}akobshavn`, "Greenland's Jakobshavn"},
+		{`\emph{Ice} and \textbf{{snow}}`, "Ice and snow"},
+		{`Fish \& Chips 50\% \$5`, "Fish & Chips 50% $5"},
+		{`$\alpha$-decay at $10^{-3}$`, "α-decay at 10^-3"},
+		{`{\noopsort{a}}Zed`, "Zed"},
+		{`Stra\ss e~1 \ldots`, "Straße 1 …"},
+	}
+	for _, c := range cases {
+		if out := DecodeLaTeX(c.in); out != c.out {
+			fmt.Printf("%q decoded as %q (should be %q)\n", c.in, out, c.out)
+			t.Fail()
+		}
+	}
+}
+
+func TestDecodedMatching(t *testing.T) {
+	entries := readtoarray("test.bib")
+	results := SearchTitle(entries, "mélange")
+	if len(results) != 1 {
+		fmt.Println(len(results), "entries found matching 'mélange' (should be 1)")
+		t.Fail()
+	}
+	if !entries[1].TestAuthor("Lüthi") {
+		fmt.Println("expected decoded author to match 'Lüthi':", entries[1].Author)
+		t.Fail()
+	}
+}
@@ -0,0 +1,403 @@
+package bibtex
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Required and optional fields of each entry type, following the standard
+// BibTeX styles and, for the types only biblatex has, the biblatex manual.
+// Alternatives are separated by a slash.
+var entryTypeFields = map[string][2]string{
+	"article":       {"author title journal year", "volume number pages month note"},
+	"book":          {"author/editor title publisher year", "volume number series address edition month note"},
+	"booklet":       {"title", "author howpublished address month year note"},
+	"conference":    {"author title booktitle year", "editor volume number series pages address month organization publisher note"},
+	"inbook":        {"author/editor title chapter/pages publisher year", "volume number series type address edition month note"},
+	"incollection":  {"author title booktitle publisher year", "editor volume number series type chapter pages address edition month note"},
+	"inproceedings": {"author title booktitle year", "editor volume number series pages address month organization publisher note"},
+	"manual":        {"title", "author organization address edition month year note"},
+	"mastersthesis": {"author title school year", "type address month note"},
+	"misc":          {"", "author title howpublished month year note"},
+	"phdthesis":     {"author title school year", "type address month note"},
+	"proceedings":   {"title year", "editor volume number series address month organization publisher note"},
+	"techreport":    {"author title institution year", "type number address month note"},
+	"unpublished":   {"author title note", "month year"},
+
+	"collection":   {"editor title year", "subtitle volume volumes series number edition publisher location pages pagetotal note"},
+	"dataset":      {"author/editor title year", "subtitle edition type series number version publisher location organization note"},
+	"electronic":   {"author/editor title year url", "subtitle organization note"},
+	"inreference":  {"author title booktitle year", "editor volume series number edition publisher location pages note"},
+	"mvbook":       {"author title year", "editor subtitle volumes series edition publisher location pagetotal note"},
+	"mvcollection": {"editor title year", "subtitle volumes series edition publisher location pagetotal note"},
+	"online":       {"author/editor title year url/doi/eprint", "subtitle version organization note"},
+	"patent":       {"author title number year", "holder type version location note"},
+	"periodical":   {"editor title year", "subtitle issuetitle series volume number issue note"},
+	"reference":    {"editor title year", "subtitle volume volumes series edition publisher location pagetotal note"},
+	"report":       {"author title type institution year", "subtitle number version location pages pagetotal note"},
+	"software":     {"author/editor title year", "subtitle version publisher organization url note"},
+	"thesis":       {"author title type institution year", "subtitle location pages pagetotal note"},
+	"www":          {"author/editor title year url", "subtitle organization note"},
+}
+
+// Other biblatex types, whose fields aren't checked
+var otherEntryTypes = strings.Fields(`artwork audio bibnote bookinbook commentary
+	image jurisdiction legal legislation letter movie music mvproceedings
+	mvreference performance review standard suppbook suppcollection
+	suppperiodical video`)
+
+// Entry types that hold data for other entries rather than being cited
+var dataTypes = map[string]bool{"xdata": true, "set": true}
+
+// Fields that any entry may have without being unusual for its type
+var commonFields = strings.Fields(`abstract annote annotation crossref date doi
+	eprint eprintclass eprinttype archiveprefix primaryclass file ids isbn issn
+	key keywords language langid pdf shorttitle sortkey url urldate xdata`)
+
+// Fields that can stand in for a required field: the biblatex names for
+// BibTeX fields, and date for year
+var equivalentFields = map[string][]string{
+	"year":        {"date"},
+	"school":      {"institution"},
+	"institution": {"school"},
+}
+
+func init() {
+	for _, alias := range biblatexFieldAliases {
+		equivalentFields[alias[0]] = append(equivalentFields[alias[0]], alias[1])
+		equivalentFields[alias[1]] = append(equivalentFields[alias[1]], alias[0])
+	}
+}
+
+// Fields whose values are copied verbatim rather than typeset, so & and %
+// don't need escaping
+var verbatimFields = map[string]bool{
+	"doi": true, "eprint": true, "file": true, "pdf": true, "url": true, "urldate": true,
+}
+
+// A check that lint makes on each entry. Rules that aren't on by default are
+// only run when they are enabled.
+type LintRule struct {
+	Name        string
+	Description string
+	Default     bool // run unless disabled
+	Warning     bool // what it finds is a warning rather than an error
+	check       func(l *linter, e, resolved Entry)
+}
+
+// Every lint rule, in the order they are applied
+var LintRules = []LintRule{
+	{"required-fields", "fields the entry type requires are missing", true, false, lintRequired},
+	{"entry-type", "the entry type is not a standard BibTeX or biblatex type", true, true, lintEntryType},
+	{"unknown-fields", "fields that are neither required nor optional for the entry type", false, true, lintUnknownFields},
+	{"year", "the year is not a four digit number", true, true, lintYear},
+	{"pages", "page ranges use a single hyphen or a Unicode dash instead of --", true, true, lintPages},
+	{"doi", "the DOI has a URL or doi: prefix, or doesn't start with 10.", true, true, lintDOI},
+	{"braces", "braces are unbalanced once \\{ and \\} are taken as escaped", true, false, lintBraces},
+	{"all-caps-title", "the title is written in capitals", true, true, lintAllCaps},
+	{"special-chars", "& or % is not escaped outside URL-like fields", true, true, lintSpecialChars},
+}
+
+// Return the rules to run: the default ones, with those named in *enable*
+// added and those named in *disable* taken away. "all" stands for every
+// rule, and an unknown name is an error.
+func SelectLintRules(enable, disable []string) ([]LintRule, error) {
+	return SelectLintRulesLayered([][2][]string{{enable, disable}})
+}
+
+// Return the rules to run when the rules to enable and disable come in
+// layers, such as the configuration and then the command line. Each layer
+// is applied in turn as in SelectLintRules, so a later one can turn back on
+// a rule that an earlier one turned off.
+func SelectLintRulesLayered(layers [][2][]string) ([]LintRule, error) {
+	on := make(map[string]bool)
+	for _, rule := range LintRules {
+		on[rule.Name] = rule.Default
+	}
+	for _, layer := range layers {
+		for i, names := range layer {
+			for _, name := range names {
+				if name == "all" {
+					for rule := range on {
+						on[rule] = i == 0
+					}
+					continue
+				}
+				if _, ok := on[name]; !ok {
+					return nil, fmt.Errorf("unknown lint rule %q", name)
+				}
+				on[name] = i == 0
+			}
+		}
+	}
+	rules := make([]LintRule, 0, len(LintRules))
+	for _, rule := range LintRules {
+		if on[rule.Name] {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+// A problem that lint found in an entry
+type LintProblem struct {
+	File    string
+	Line    int
+	Column  int
+	Key     string
+	Field   string // the field the problem is in, if any
+	Rule    string
+	Snippet string // the line of source the problem is on
+	Message string
+	Warning bool
+}
+
+func (p LintProblem) String() string {
+	err := ParseError{File: p.File, Line: p.Line, Column: p.Column, Key: p.Key, Message: p.Message, Warning: p.Warning}
+	return fmt.Sprintf("%v [%s]", err, p.Rule)
+}
+
+// Check entries read from file *fnm* against *rules*. Required fields may be
+// inherited through crossref or xdata, but other rules only look at the
+// fields each entry has itself, so a problem in a parent is reported once.
+func Lint(entries []Entry, fnm string, rules []LintRule) []LintProblem {
+	resolved, _ := ResolveCrossrefs(entries, fnm)
+	l := linter{file: fnm, problems: make([]LintProblem, 0)}
+	for i, e := range entries {
+		if dataTypes[e.Type] {
+			continue
+		}
+		l.entry = e
+		for _, rule := range rules {
+			l.rule = rule
+			rule.check(&l, e, resolved[i])
+		}
+	}
+	return l.problems
+}
+
+type linter struct {
+	file     string
+	entry    Entry
+	rule     LintRule
+	problems []LintProblem
+}
+
+// Record a problem with a field of the current entry, or with the entry as a
+// whole when *field* is empty
+func (l *linter) report(field, msg string) {
+	pos := l.entry.Pos()
+	if field != "" {
+		pos = l.entry.FieldPos(field)
+	}
+	p := LintProblem{
+		File:    l.file,
+		Line:    pos.Line,
+		Column:  pos.Column,
+		Key:     l.entry.BibTeXkey,
+		Field:   field,
+		Rule:    l.rule.Name,
+		Message: msg,
+		Warning: l.rule.Warning,
+	}
+	if l.entry.src != nil {
+		p.Snippet = l.entry.src.lineAt(pos)
+	}
+	l.problems = append(l.problems, p)
+}
+
+// Reports whether an entry has a non-empty field or one that can stand in
+// for it
+func hasField(e Entry, name string) bool {
+	for _, n := range append([]string{name}, equivalentFields[name]...) {
+		if strings.TrimSpace(e.Field(n)) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func lintRequired(l *linter, e, resolved Entry) {
+	fields, ok := entryTypeFields[e.Type]
+	if !ok {
+		return
+	}
+	missing := make([]string, 0)
+	for _, required := range strings.Fields(fields[0]) {
+		found := false
+		for _, name := range strings.Split(required, "/") {
+			found = found || hasField(resolved, name)
+		}
+		if !found {
+			missing = append(missing, strings.Replace(required, "/", " or ", -1))
+		}
+	}
+	if len(missing) == 1 {
+		l.report("", fmt.Sprintf("%s is missing %s", e.Type, missing[0]))
+	} else if len(missing) > 1 {
+		l.report("", fmt.Sprintf("%s is missing %s and %s", e.Type,
+			strings.Join(missing[:len(missing)-1], ", "), missing[len(missing)-1]))
+	}
+}
+
+func lintEntryType(l *linter, e, resolved Entry) {
+	if _, ok := entryTypeFields[e.Type]; ok {
+		return
+	}
+	for _, typ := range otherEntryTypes {
+		if typ == e.Type {
+			return
+		}
+	}
+	l.report("", fmt.Sprintf("unknown entry type %q", e.Type))
+}
+
+func lintUnknownFields(l *linter, e, resolved Entry) {
+	fields, ok := entryTypeFields[e.Type]
+	if !ok {
+		return
+	}
+	known := make(map[string]bool)
+	for _, name := range append(strings.Fields(strings.Replace(fields[0]+" "+fields[1], "/", " ", -1)), commonFields...) {
+		known[name] = true
+		for _, alias := range equivalentFields[name] {
+			known[alias] = true
+		}
+	}
+	for _, f := range e.Fields {
+		if !known[strings.ToLower(f.Name)] {
+			l.report(f.Name, fmt.Sprintf("field %s is not used by %s entries", f.Name, e.Type))
+		}
+	}
+}
+
+var fourDigits = regexp.MustCompile(`^[0-9]{4}$`)
+
+func lintYear(l *linter, e, resolved Entry) {
+	if year, ok := e.Fields.Get("year"); ok && !fourDigits.MatchString(UnicodeBibValue(year)) {
+		l.report("year", fmt.Sprintf("year %q is not a four digit year", UnicodeBibValue(year)))
+	}
+}
+
+// A number, or a number with letters in front such as A12, followed by a
+// single hyphen or a dash and another
+var badPageRange = regexp.MustCompile(`[0-9]\s*(-|–|—|‐|‑|−)\s*[A-Za-z]*[0-9]`)
+
+func lintPages(l *linter, e, resolved Entry) {
+	pages, ok := e.Fields.Get("pages")
+	if !ok {
+		return
+	}
+	if badPageRange.MatchString(pages) {
+		l.report("pages", fmt.Sprintf("page range %q should use -- between the pages", strings.TrimSpace(pages)))
+	}
+}
+
+func lintDOI(l *linter, e, resolved Entry) {
+	value, ok := e.Fields.Get("doi")
+	if !ok {
+		return
+	}
+	doi := strings.TrimSpace(value)
+	lower := strings.ToLower(doi)
+	switch {
+	case strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") ||
+		strings.HasPrefix(lower, "doi.org/") || strings.HasPrefix(lower, "dx.doi.org/"):
+		l.report("doi", fmt.Sprintf("DOI %q should not include the resolver URL", doi))
+	case strings.HasPrefix(lower, "doi:"):
+		l.report("doi", fmt.Sprintf("DOI %q should not start with doi:", doi))
+	case !strings.HasPrefix(doi, "10."):
+		l.report("doi", fmt.Sprintf("DOI %q doesn't start with 10.", doi))
+	}
+}
+
+func lintBraces(l *linter, e, resolved Entry) {
+	for _, f := range e.Fields {
+		depth := 0
+		for i := 0; i < len(f.Value) && depth >= 0; i++ {
+			switch f.Value[i] {
+			case '\\':
+				i++
+			case '{':
+				depth++
+			case '}':
+				depth--
+			}
+		}
+		if depth != 0 {
+			l.report(f.Name, fmt.Sprintf("unbalanced braces in field %s", f.Name))
+		}
+	}
+}
+
+func lintAllCaps(l *linter, e, resolved Entry) {
+	for _, name := range []string{"title", "booktitle"} {
+		value, ok := e.Fields.Get(name)
+		if !ok {
+			continue
+		}
+		words, lower := 0, false
+		for _, word := range strings.Fields(UnicodeBibValue(value)) {
+			letters := 0
+			for _, r := range word {
+				if unicode.IsLetter(r) {
+					letters++
+					lower = lower || unicode.IsLower(r)
+				}
+			}
+			if letters > 1 {
+				words++
+			}
+		}
+		if words >= 3 && !lower {
+			l.report(name, fmt.Sprintf("%s is in capitals; use title case and brace the acronyms", name))
+		}
+	}
+}
+
+func lintSpecialChars(l *linter, e, resolved Entry) {
+	for _, f := range e.Fields {
+		if verbatimFields[strings.ToLower(f.Name)] {
+			continue
+		}
+		found := make([]string, 0)
+		for _, c := range unescapedSpecials(f.Value) {
+			found = append(found, string(c))
+		}
+		if len(found) > 0 {
+			sort.Strings(found)
+			l.report(f.Name, fmt.Sprintf("unescaped %s in field %s", strings.Join(found, " and "), f.Name))
+		}
+	}
+}
+
+// Return the characters among & and % that appear without a backslash in a
+// value, other than in the arguments of \url and \href
+func unescapedSpecials(value string) []byte {
+	var found []byte
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '\\':
+			j := i + 1
+			for j < len(value) && isLetter(value[j]) {
+				j++
+			}
+			if name := value[i+1 : j]; (name == "url" || name == "href") && j < len(value) && value[j] == '{' {
+				if end := matchingBracket(value, j, '{', '}'); end != -1 {
+					j = end
+				}
+			} else if j == i+1 {
+				j++ // control symbol such as \&
+			}
+			i = j - 1
+		case '&', '%':
+			if strings.IndexByte(string(found), c) == -1 {
+				found = append(found, c)
+			}
+		}
+	}
+	return found
+}
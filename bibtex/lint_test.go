@@ -0,0 +1,123 @@
+package bibtex
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	src := `@article{noJournal, author = {Doe, J.}, title = {A study}, year = 2001, pages = {12-34}}
+@book{noPublisher, editor = {Roe, R.}, title = {Things}, year = {2001a}}
+@inproceedings{part, author = {Doe, J.}, title = {A talk}, crossref = {conf}, pages = {1--10}}
+@proceedings{conf, title = {Proceedings of the Conference}, year = 2017}
+@article{shouting,
+  author = {Doe, J.},
+  title = {GLACIER MELT IN THE ARCTIC},
+  journaltitle = {Journal of Ice \& Snow},
+  date = {2003-04},
+  doi = {https://doi.org/10.1000/xyz},
+  url = {http://example.com/a%20b&c},
+  note = {Tom & Jerry, 100% \url{http://x.org/%20&}}
+}
+@thing{odd, title = {Odd \{ braces}}}`
+	bib, err := parseBibliography("refs.bib", src)
+	if errs, _ := err.(ErrorList); errs.HasErrors() {
+		for _, e := range errs {
+			fmt.Println(e)
+		}
+		t.FailNow()
+	}
+	rules, _ := SelectLintRules(nil, nil)
+	problems := Lint(bib.Entries, "refs.bib", rules)
+
+	found := make([]string, 0)
+	for _, p := range problems {
+		found = append(found, fmt.Sprintf("%s %s %s", p.Key, p.Rule, p.Field))
+	}
+	expected := []string{
+		"noJournal required-fields ",
+		"noJournal pages pages",
+		"noPublisher required-fields ",
+		"noPublisher year year",
+		"shouting doi doi",
+		"shouting all-caps-title title",
+		"shouting special-chars note",
+		"odd entry-type ",
+		"odd braces title",
+	}
+	if strings.Join(found, "\n") != strings.Join(expected, "\n") {
+		fmt.Println("unexpected problems:")
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		t.Fail()
+	}
+
+	if len(problems) > 0 {
+		p := problems[0]
+		if p.Message != "article is missing journal" || p.Warning || p.Line != 1 || p.Column != 1 {
+			fmt.Println("unexpected problem:", p)
+			t.Fail()
+		}
+		if s := p.String(); s != "refs.bib:1:1: article is missing journal (in entry noJournal) [required-fields]" {
+			fmt.Println("unexpected formatting:", s)
+			t.Fail()
+		}
+	}
+	for _, p := range problems {
+		if p.Key == "shouting" && p.Rule == "doi" && (p.Line != 10 || p.Snippet != "  doi = {https://doi.org/10.1000/xyz},") {
+			fmt.Println("unexpected position:", p.Line, p.Column, p.Snippet)
+			t.Fail()
+		}
+		if p.Key == "shouting" && p.Rule == "special-chars" && p.Message != "unescaped % and & in field note" {
+			fmt.Println("unexpected message:", p.Message)
+			t.Fail()
+		}
+	}
+}
+
+func TestSelectLintRules(t *testing.T) {
+	rules, err := SelectLintRules([]string{"unknown-fields"}, []string{"pages", "doi"})
+	if err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	names := make([]string, 0)
+	for _, rule := range rules {
+		names = append(names, rule.Name)
+	}
+	if strings.Join(names, " ") != "required-fields entry-type unknown-fields year braces all-caps-title special-chars" {
+		fmt.Println("unexpected rules:", names)
+		t.Fail()
+	}
+
+	rules, _ = SelectLintRules([]string{"pages"}, []string{"all"})
+	if len(rules) != 0 {
+		fmt.Println("all rules should be disabled:", rules)
+		t.Fail()
+	}
+	// the command line turns back on a rule the configuration turned off
+	rules, _ = SelectLintRulesLayered([][2][]string{
+		{nil, []string{"all", "doi"}},
+		{[]string{"doi"}, nil},
+	})
+	if len(rules) != 1 || rules[0].Name != "doi" {
+		fmt.Println("unexpected rules:", rules)
+		t.Fail()
+	}
+	if _, err := SelectLintRules([]string{"spelling"}, nil); err == nil {
+		fmt.Println("expected an error for an unknown rule")
+		t.Fail()
+	}
+
+	// known fields under their biblatex names aren't unknown
+	src := `@article{a, author = {Doe, J.}, title = {T}, journaltitle = {J}, date = 2001, colour = {red}}`
+	bib, _ := parseBibliography("", src)
+	rules, _ = SelectLintRules([]string{"unknown-fields"}, nil)
+	problems := Lint(bib.Entries, "", rules)
+	if len(problems) != 1 || problems[0].Field != "colour" {
+		fmt.Println("unexpected problems:", problems)
+		t.Fail()
+	}
+}
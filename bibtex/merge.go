@@ -0,0 +1,145 @@
+package bibtex
+
+import (
+	"strings"
+)
+
+// Chooses between the values that merged entries have for a field. *values*
+// holds one value per entry, empty where the entry doesn't have the field,
+// and the result is the index of the value to keep.
+type MergePolicy func(field string, values []string) int
+
+// Keep the longest value, or the first of the longest
+func LongestValue(field string, values []string) int {
+	best := 0
+	for i, v := range values {
+		if len(v) > len(values[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// Keep the value from the first entry that has the field
+func FirstValue(field string, values []string) int {
+	for i, v := range values {
+		if v != "" {
+			return i
+		}
+	}
+	return 0
+}
+
+// Return a policy that keeps the value from the first entry in *order* that
+// has the field, falling back to the longest value
+func PreferEntries(order ...int) MergePolicy {
+	return func(field string, values []string) int {
+		for _, i := range order {
+			if i >= 0 && i < len(values) && values[i] != "" {
+				return i
+			}
+		}
+		return LongestValue(field, values)
+	}
+}
+
+// A field on which merged entries disagree, and the value that was kept
+type MergeConflict struct {
+	Field  string
+	Values []string // one per entry, empty where the entry doesn't have the field
+	Chosen int
+}
+
+// Merge entries for the same work into one, which keeps the type and key of
+// the first entry. Fields appear in the order they are first found, a value
+// that only one entry has is kept as it is, and *policy* chooses between
+// values that differ. The keys of the other entries are added to the ids
+// field, which biblatex uses to resolve citations under old keys.
+func MergeEntries(entries []Entry, policy MergePolicy) (Entry, []MergeConflict) {
+	if len(entries) == 0 {
+		return Entry{}, nil
+	}
+	merged := entries[0]
+	merged.Fields = make(Fields, 0, len(entries[0].Fields))
+	conflicts := make([]MergeConflict, 0)
+
+	var names []string
+	for _, e := range entries {
+		for _, f := range e.Fields {
+			if !merged.Fields.Has(f.Name) {
+				merged.Fields = append(merged.Fields, Field{f.Name, ""})
+				names = append(names, f.Name)
+			}
+		}
+	}
+	for _, name := range names {
+		values := make([]string, len(entries))
+		distinct := make([]int, 0)
+		for i, e := range entries {
+			values[i] = e.Field(name)
+			if values[i] == "" {
+				continue
+			}
+			same := false
+			for _, j := range distinct {
+				same = same || collapseSpace(values[j]) == collapseSpace(values[i])
+			}
+			if !same {
+				distinct = append(distinct, i)
+			}
+		}
+		switch {
+		case strings.EqualFold(name, "ids"):
+			// aliases from every entry are kept
+			merged.Fields.Set(name, strings.Join(mergeKeys(values), ", "))
+		case len(distinct) == 1:
+			merged.Fields.Set(name, values[distinct[0]])
+		case len(distinct) > 1:
+			chosen := policy(name, values)
+			merged.Fields.Set(name, values[chosen])
+			conflicts = append(conflicts, MergeConflict{name, values, chosen})
+		}
+	}
+
+	var retired []string
+	for _, e := range entries[1:] {
+		if !strings.EqualFold(e.BibTeXkey, merged.BibTeXkey) {
+			retired = append(retired, e.BibTeXkey)
+		}
+	}
+	if len(retired) > 0 {
+		ids := mergeKeys(append([]string{merged.Field("ids")}, retired...))
+		merged.Fields.Set("ids", strings.Join(ids, ", "))
+	}
+
+	merged.Title, merged.Author, merged.Journal, merged.Year = "", "", "", 0
+	summarize(&merged, merged.Fields)
+	return merged, conflicts
+}
+
+// Return the keys in comma separated lists, without repeats
+func mergeKeys(lists []string) []string {
+	keys := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, list := range lists {
+		for _, key := range splitKeys(list) {
+			if !seen[strings.ToLower(key)] {
+				seen[strings.ToLower(key)] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// Return a map from the retired keys listed in ids fields to the keys of the
+// entries that replaced them. Keys in the map are in lower case.
+func KeyAliases(entries []Entry) map[string]string {
+	aliases := make(map[string]string)
+	for _, e := range entries {
+		for _, key := range splitKeys(e.Field("ids")) {
+			aliases[strings.ToLower(key)] = e.BibTeXkey
+		}
+	}
+	return aliases
+}
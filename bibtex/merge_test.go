@@ -0,0 +1,45 @@
+package bibtex
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMergeEntries(t *testing.T) {
+	src := `@article{Amundson2010, author = {Amundson, J. M. and Fahnestock, M.}, year = 2010,
+  title = {Ice m\'elange dynamics}, pages = {F01005}}
+@article{amundson10, title = {Ice m\'elange   dynamics}, year = 2010, doi = {10.1029/2009JF001405},
+  author = {Amundson, Jason M. and Fahnestock, Mark}, ids = {amundson-jgr}}`
+	bib, err := parseBibliography("", src)
+	if err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+
+	merged, conflicts := MergeEntries(bib.Entries, LongestValue)
+	names := []string{"author", "year", "title", "pages", "doi", "ids"}
+	if merged.BibTeXkey != "Amundson2010" || strings.Join(merged.Fields.Names(), ",") != strings.Join(names, ",") {
+		fmt.Println("unexpected merged entry:", merged.BibTeXkey, merged.Fields)
+		t.Fail()
+	}
+	if len(conflicts) != 1 || conflicts[0].Field != "author" || conflicts[0].Chosen != 1 {
+		fmt.Println("unexpected conflicts:", conflicts)
+		t.FailNow()
+	}
+	if merged.Author != "Amundson, Jason M. and Fahnestock, Mark" || merged.Field("ids") != "amundson-jgr, amundson10" {
+		fmt.Println("unexpected merged fields:", merged.Fields)
+		t.Fail()
+	}
+
+	merged, _ = MergeEntries(bib.Entries, PreferEntries(0))
+	if merged.Author != "Amundson, J. M. and Fahnestock, M." {
+		fmt.Println("policy not followed:", merged.Author)
+		t.Fail()
+	}
+	aliases := KeyAliases([]Entry{merged})
+	if aliases["amundson10"] != "Amundson2010" || aliases["amundson-jgr"] != "Amundson2010" || len(aliases) != 2 {
+		fmt.Println("unexpected aliases:", aliases)
+		t.Fail()
+	}
+}
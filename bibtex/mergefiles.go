@@ -0,0 +1,270 @@
+package bibtex
+
+import (
+	"strings"
+)
+
+// How MergeBibliographies combines files
+type MergeFilesOptions struct {
+	// Merge entries that look like the same work even when their keys
+	// differ. Entries that share a key are always compared.
+	Dedupe bool
+	// Lowest duplicate score, from 0 to 1, at which entries are merged
+	// rather than renamed
+	Threshold float64
+	// Chooses between values when merging, LongestValue if nil
+	Policy MergePolicy
+	// Field to record the files each entry came from in, if not empty
+	SourceField string
+}
+
+// Options that merge only near-certain duplicates and record the source
+// files in the bibsource field, as DBLP does
+func DefaultMergeFilesOptions() MergeFilesOptions {
+	return MergeFilesOptions{Threshold: 0.9, SourceField: "bibsource"}
+}
+
+// An entry that was given a new key because another entry had its key
+type KeyRename struct {
+	File     string
+	Old, New string
+}
+
+// An @string macro defined differently in two files. Entries from File
+// that use it are written with its value in full.
+type MacroClash struct {
+	Name  string
+	File  string
+	Value string
+	Kept  string // the definition from an earlier file
+}
+
+// What MergeBibliographies did
+type MergeFilesReport struct {
+	Sources   [][]FileEntry     // for each entry of the result, the entries it was made from
+	Conflicts [][]MergeConflict // for each entry of the result, the fields its sources disagreed on
+	Renamed   []KeyRename
+	Macros    []MacroClash
+}
+
+// Read BibTeX files and merge them with MergeBibliographies. Any problems
+// reading the files are returned as well, as an ErrorList.
+func MergeFiles(fnms []string, opts MergeFilesOptions) (Bibliography, MergeFilesReport, error) {
+	bibs := make([]Bibliography, len(fnms))
+	var errs ErrorList
+	for i, fnm := range fnms {
+		bib, err := ReadBibliography(fnm)
+		if list, ok := err.(ErrorList); ok {
+			errs = append(errs, list...)
+		} else if err != nil {
+			return Bibliography{}, MergeFilesReport{}, err
+		}
+		bibs[i] = bib
+	}
+	bib, report := MergeBibliographies(bibs, fnms, opts)
+	return bib, report, errs.Err()
+}
+
+// Combine bibliographies read from the files *fnms* into one. Macros,
+// preambles and comments are carried across, keeping the first definition
+// of a macro. Entries sharing a key are merged with MergeEntries if they
+// look like the same work and otherwise the later ones are renamed with a
+// suffix, as are crossref and xdata fields in the same file that refer to
+// them. With opts.Dedupe set, other likely duplicates are merged too.
+// Entries keep the order they are first found in.
+func MergeBibliographies(bibs []Bibliography, fnms []string, opts MergeFilesOptions) (Bibliography, MergeFilesReport) {
+	policy := opts.Policy
+	if policy == nil {
+		policy = LongestValue
+	}
+	var result Bibliography
+	report := MergeFilesReport{}
+
+	// macros, keeping the first definition
+	defined := make(map[string]string)
+	clashing := make([]map[string]bool, len(bibs))
+	seenText := make(map[string]bool)
+	for i, bib := range bibs {
+		clashing[i] = make(map[string]bool)
+		for _, s := range bib.Strings {
+			name := strings.ToLower(s.Name)
+			kept, ok := defined[name]
+			switch {
+			case !ok:
+				defined[name] = s.Value
+				result.Strings = append(result.Strings, s)
+			case kept != s.Value:
+				clashing[i][name] = true
+				report.Macros = append(report.Macros, MacroClash{s.Name, fnms[i], s.Value, kept})
+			}
+		}
+		for _, p := range bib.Preambles {
+			if !seenText["@preamble "+p] {
+				seenText["@preamble "+p] = true
+				result.Preambles = append(result.Preambles, p)
+			}
+		}
+		for _, c := range bib.Comments {
+			if !seenText["@comment "+c] {
+				seenText["@comment "+c] = true
+				result.Comments = append(result.Comments, c)
+			}
+		}
+	}
+
+	var all []FileEntry
+	var fileOf []int
+	for i, bib := range bibs {
+		for _, e := range bib.Entries {
+			all = append(all, FileEntry{fnms[i], e})
+			fileOf = append(fileOf, i)
+		}
+	}
+
+	// group the entries to merge
+	parent := make([]int, len(all))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	union := func(i, j int) {
+		if a, b := find(i), find(j); a < b {
+			parent[b] = a
+		} else {
+			parent[a] = b
+		}
+	}
+	if opts.Dedupe {
+		for _, g := range FindDuplicates(all, opts.Threshold) {
+			for _, i := range g.Entries[1:] {
+				union(g.Entries[0], i)
+			}
+		}
+	}
+	for _, group := range DuplicateKeys(all) {
+		// entries with the same key join the first that they match
+		var firsts []int
+		for _, i := range group {
+			ki := newDupeKey(all[i].Entry)
+			for _, j := range firsts {
+				if score, _ := scoreDuplicate(newDupeKey(all[j].Entry), ki); score >= opts.Threshold && score > 0 {
+					union(j, i)
+					break
+				}
+			}
+			if find(i) == i {
+				firsts = append(firsts, i)
+			}
+		}
+	}
+
+	// renames[f] maps lower case keys of file f to their new keys, and
+	// retired[f] maps the keys of merged entries to the entry they went into
+	renames := make([]map[string]string, len(bibs))
+	retired := make([]map[string]int, len(bibs))
+	for i := range renames {
+		renames[i] = make(map[string]string)
+		retired[i] = make(map[string]int)
+	}
+	members := make(map[int][]int)
+	var roots []int
+	for i := range all {
+		root := find(i)
+		if _, ok := members[root]; !ok {
+			roots = append(roots, root)
+		}
+		members[root] = append(members[root], i)
+	}
+	origin := make([]int, 0, len(roots))
+	for _, root := range roots {
+		group := members[root]
+		entries := make([]Entry, len(group))
+		sources := make([]FileEntry, len(group))
+		for k, i := range group {
+			sources[k] = all[i]
+			entries[k] = all[i].Entry
+			if usesMacros(entries[k], clashing[fileOf[i]]) {
+				entries[k].src = nil // so the values are written in full
+			}
+		}
+		merged, conflicts := entries[0], []MergeConflict(nil)
+		if len(entries) > 1 {
+			merged, conflicts = MergeEntries(entries, policy)
+			for k, i := range group[1:] {
+				if !strings.EqualFold(entries[k+1].BibTeXkey, merged.BibTeXkey) {
+					retired[fileOf[i]][strings.ToLower(entries[k+1].BibTeXkey)] = len(result.Entries)
+				}
+			}
+		}
+		result.Entries = append(result.Entries, merged)
+		report.Sources = append(report.Sources, sources)
+		report.Conflicts = append(report.Conflicts, conflicts)
+		origin = append(origin, fileOf[group[0]])
+	}
+
+	// keys still shared by entries that aren't the same work
+	used := make(map[string]bool)
+	for _, e := range result.Entries {
+		used[strings.ToLower(e.BibTeXkey)] = true
+	}
+	taken := make(map[string]bool)
+	for i := range result.Entries {
+		e := &result.Entries[i]
+		if !taken[strings.ToLower(e.BibTeXkey)] {
+			taken[strings.ToLower(e.BibTeXkey)] = true
+			continue
+		}
+		for n := 0; ; n++ {
+			key := e.BibTeXkey + keySuffix(n)
+			if !used[strings.ToLower(key)] {
+				used[strings.ToLower(key)] = true
+				taken[strings.ToLower(key)] = true
+				report.Renamed = append(report.Renamed, KeyRename{fnms[origin[i]], e.BibTeXkey, key})
+				renames[origin[i]][strings.ToLower(e.BibTeXkey)] = key
+				e.BibTeXkey = key
+				break
+			}
+		}
+	}
+
+	for f := range retired {
+		for key, i := range retired[f] {
+			renames[f][key] = result.Entries[i].BibTeXkey
+		}
+	}
+	for i := range result.Entries {
+		renameReferences(&result.Entries[i], renames[origin[i]])
+		if opts.SourceField != "" {
+			files := make([]string, len(report.Sources[i]))
+			for k, src := range report.Sources[i] {
+				files[k] = src.File
+			}
+			e := &result.Entries[i]
+			e.Fields = e.Fields.Copy()
+			e.Fields.Set(opts.SourceField, strings.Join(mergeKeys(files), ", "))
+		}
+	}
+	return result, report
+}
+
+// Reports whether any field of an entry, as it was read, uses one of
+// *macros*, whose names are in lower case
+func usesMacros(e Entry, macros map[string]bool) bool {
+	if e.src == nil || len(macros) == 0 {
+		return false
+	}
+	for _, f := range e.src.block.fields {
+		for _, part := range f.parts {
+			if part.kind == macroPart && macros[strings.ToLower(part.text)] {
+				return true
+			}
+		}
+	}
+	return false
+}
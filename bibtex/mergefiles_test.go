@@ -0,0 +1,94 @@
+package bibtex
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMergeBibliographies(t *testing.T) {
+	srcs := []string{`@string{jgr = "J. Geophys. Res."}
+@article{Nye1952, author = {Nye, J. F.}, year = 1952, journal = jgr,
+  title = {The flow of glaciers and ice-sheets as a problem in plasticity}}
+@inproceedings{Smith2001, author = {Smith, A.}, title = {A talk}, crossref = {conf}}
+@proceedings{conf, title = {Conference}, year = 2001}`,
+		`@string{jgr = "Journal of Geophysical Research"}
+@article{nye1952, author = {Nye, J.}, year = 1952, pages = {1--2},
+  title = {The Flow of Glaciers and Ice-Sheets as a Problem in Plasticity}}
+@article{Smith2001, author = {Smith, B.}, title = {Unrelated rocks}, year = 2001, journal = jgr}
+@inbook{part, title = {A chapter}, crossref = {Smith2001}}
+@article{Glen1955, author = {Glen, J. W.}, title = {The creep of polycrystalline ice}, year = 1955, doi = {10.1098/rspa.1955.0066}}`,
+		`@article{glen55, author = {Glen, J. W.}, title = {Creep of polycrystalline ice}, year = 1955,
+  doi = {https://doi.org/10.1098/rspa.1955.0066}}
+@misc{ref, crossref = {glen55}}`,
+	}
+	fnms := []string{"a.bib", "b.bib", "c.bib"}
+	bibs := make([]Bibliography, len(srcs))
+	for i, src := range srcs {
+		bib, err := parseBibliography(fnms[i], src)
+		if err != nil {
+			fmt.Println(err)
+			t.FailNow()
+		}
+		bibs[i] = bib
+	}
+
+	opts := DefaultMergeFilesOptions()
+	bib, report := MergeBibliographies(bibs, fnms, opts)
+	keys := make([]string, len(bib.Entries))
+	for i, e := range bib.Entries {
+		keys[i] = e.BibTeXkey
+	}
+	if strings.Join(keys, " ") != "Nye1952 Smith2001 conf Smith2001a part Glen1955 glen55 ref" {
+		fmt.Println("unexpected keys:", keys)
+		t.FailNow()
+	}
+	nye := bib.Entries[0]
+	if nye.Field("pages") != "1--2" || nye.Field("bibsource") != "a.bib, b.bib" || len(report.Sources[0]) != 2 {
+		fmt.Println("duplicates with the same key not merged:", nye.Fields)
+		t.Fail()
+	}
+	if len(report.Renamed) != 1 || report.Renamed[0] != (KeyRename{"b.bib", "Smith2001", "Smith2001a"}) {
+		fmt.Println("unexpected renames:", report.Renamed)
+		t.Fail()
+	}
+	if part := bib.Entries[4]; part.Field("crossref") != "Smith2001a" {
+		fmt.Println("crossref not renamed:", part.Fields)
+		t.Fail()
+	}
+	if len(report.Macros) != 1 || report.Macros[0].Name != "jgr" || report.Macros[0].File != "b.bib" {
+		fmt.Println("unexpected macro clashes:", report.Macros)
+		t.Fail()
+	}
+
+	var buf bytes.Buffer
+	WriteBibliography(&buf, bib, DefaultWriteOptions())
+	out := buf.String()
+	if strings.Count(out, "@string") != 1 || !strings.Contains(out, "journal   = jgr,") ||
+		!strings.Contains(out, "journal   = {Journal of Geophysical Research},") {
+		fmt.Println("unexpected macros in output:\n" + out)
+		t.Fail()
+	}
+
+	// the same DOI under another key is only merged when deduplicating
+	opts.Dedupe = true
+	bib, report = MergeBibliographies(bibs, fnms, opts)
+	if len(bib.Entries) != 7 {
+		fmt.Println("expected the Glen entries to be merged:", len(bib.Entries))
+		t.FailNow()
+	}
+	glen := bib.Entries[5]
+	if glen.BibTeXkey != "Glen1955" || glen.Field("ids") != "glen55" || glen.Field("bibsource") != "b.bib, c.bib" {
+		fmt.Println("unexpected merged entry:", glen.BibTeXkey, glen.Fields)
+		t.Fail()
+	}
+	if ref := bib.Entries[6]; ref.Field("crossref") != "Glen1955" {
+		fmt.Println("crossref to a merged entry not renamed:", ref.Fields)
+		t.Fail()
+	}
+	if len(report.Conflicts[5]) != 2 {
+		fmt.Println("expected title and doi conflicts:", report.Conflicts[5])
+		t.Fail()
+	}
+}
@@ -0,0 +1,269 @@
+package bibtex
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A name from an author or editor list, split into the four parts BibTeX
+// recognizes. Parts keep their LaTeX markup.
+type Person struct {
+	First string
+	Von   string
+	Last  string
+	Jr    string
+}
+
+// Reports whether this is the "others" placeholder from "A and others",
+// which styles print as "et al."
+func (p Person) IsOthers() bool {
+	return p.First == "" && p.Von == "" && p.Jr == "" && strings.ToLower(p.Last) == "others"
+}
+
+// Return the family name including any von part, e.g. "van der Waals"
+func (p Person) Surname() string {
+	return UnicodeBibValue(strings.TrimSpace(p.Von + " " + p.Last))
+}
+
+// Return the name in "First von Last, Jr" order
+func (p Person) String() string {
+	name := strings.TrimSpace(strings.Join([]string{p.First, p.Von, p.Last}, " "))
+	name = strings.Join(strings.Fields(name), " ")
+	if p.Jr != "" {
+		name += ", " + p.Jr
+	}
+	return UnicodeBibValue(name)
+}
+
+// Reports whether *surname* is this person's last name, with or without the
+// von part, ignoring case, accents and LaTeX markup
+func (p Person) HasSurname(surname string) bool {
+	surname = matchText(surname)
+	return matchText(p.Last) == surname || matchText(p.Surname()) == surname
+}
+
+// Split a BibTeX name list such as an author field into people. Names are
+// separated by "and" outside of braces, so "{Barnes and Noble}" is a single
+// corporate name.
+func ParseNames(value string) []Person {
+	people := make([]Person, 0)
+	for _, name := range splitNames(value) {
+		people = append(people, ParseName(name))
+	}
+	return people
+}
+
+// Return people as a BibTeX name list, the reverse of ParseNames. Names are
+// written as "von Last, Jr, First", with braces around any part that would
+// otherwise be split differently when it is read back.
+func FormatNames(people []Person) string {
+	names := make([]string, len(people))
+	for i, p := range people {
+		names[i] = p.bibtexName()
+	}
+	return strings.Join(names, " and ")
+}
+
+func (p Person) bibtexName() string {
+	if p.IsOthers() {
+		return "others"
+	}
+	protect := func(part string) string {
+		words := nameWords(part)
+		for _, w := range words {
+			if strings.ToLower(w) == "and" {
+				return "{" + part + "}"
+			}
+		}
+		if len(splitTopLevel(part, func(r rune) bool { return r == ',' }, false)) > 1 {
+			return "{" + part + "}"
+		}
+		return part
+	}
+	last := protect(p.Last)
+	// lower case words at the start of the last name would be taken for the
+	// von part
+	if von, _ := splitVonLast(nameWords(last)); von != "" {
+		last = "{" + last + "}"
+	}
+	name := strings.TrimSpace(p.Von + " " + last)
+	switch {
+	case p.Jr != "":
+		name += ", " + protect(p.Jr) + ", " + protect(p.First)
+	case p.First != "":
+		name += ", " + protect(p.First)
+	}
+	return name
+}
+
+// Parse a single name written as "First von Last", "von Last, First" or
+// "von Last, Jr, First"
+func ParseName(name string) Person {
+	var person Person
+	var parts [][]string
+	for _, part := range splitTopLevel(name, func(r rune) bool { return r == ',' }, false) {
+		parts = append(parts, nameWords(part))
+	}
+
+	switch len(parts) {
+	case 0:
+		return person
+	case 1:
+		words := parts[0]
+		if len(words) == 0 {
+			return person
+		}
+		// the von part runs from the first to the last lower case word,
+		// but the last word always belongs to the last name
+		vonStart, vonEnd := -1, -1
+		for i, w := range words[:len(words)-1] {
+			if isLowerWord(w) {
+				if vonStart == -1 {
+					vonStart = i
+				}
+				vonEnd = i + 1
+			}
+		}
+		if vonStart == -1 {
+			person.First = strings.Join(words[:len(words)-1], " ")
+			person.Last = words[len(words)-1]
+		} else {
+			person.First = strings.Join(words[:vonStart], " ")
+			person.Von = strings.Join(words[vonStart:vonEnd], " ")
+			person.Last = strings.Join(words[vonEnd:], " ")
+		}
+	default:
+		person.Von, person.Last = splitVonLast(parts[0])
+		if len(parts) == 2 {
+			person.First = strings.Join(parts[1], " ")
+		} else {
+			person.Jr = strings.Join(parts[1], " ")
+			person.First = strings.Join(parts[2], " ")
+		}
+	}
+	return person
+}
+
+// Split the words before the first comma into the von and Last parts
+func splitVonLast(words []string) (string, string) {
+	if len(words) == 0 {
+		return "", ""
+	}
+	vonEnd := 0
+	for i, w := range words[:len(words)-1] {
+		if isLowerWord(w) {
+			vonEnd = i + 1
+		}
+	}
+	return strings.Join(words[:vonEnd], " "), strings.Join(words[vonEnd:], " ")
+}
+
+// Split a name list on the word "and" at brace depth zero
+func splitNames(value string) []string {
+	names := make([]string, 0)
+	var current []string
+	for _, word := range nameWords(value) {
+		if strings.ToLower(word) == "and" {
+			if len(current) > 0 {
+				names = append(names, strings.Join(current, " "))
+			}
+			current = nil
+			continue
+		}
+		current = append(current, word)
+	}
+	if len(current) > 0 {
+		names = append(names, strings.Join(current, " "))
+	}
+	return names
+}
+
+// Split a name into words at whitespace and ties outside of braces
+func nameWords(s string) []string {
+	return splitTopLevel(s, func(r rune) bool { return unicode.IsSpace(r) || r == '~' }, true)
+}
+
+// Split *s* wherever *sep* matches a character at brace depth zero. Empty
+// pieces are dropped when *dropEmpty* is set, and pieces are trimmed.
+func splitTopLevel(s string, sep func(rune) bool, dropEmpty bool) []string {
+	pieces := make([]string, 0)
+	depth, start := 0, 0
+	add := func(piece string) {
+		piece = strings.TrimSpace(piece)
+		if piece != "" || !dropEmpty {
+			pieces = append(pieces, piece)
+		}
+	}
+	for i, r := range s {
+		switch {
+		case r == '{':
+			depth++
+		case r == '}':
+			if depth > 0 {
+				depth--
+			}
+		case depth == 0 && sep(r):
+			add(s[start:i])
+			start = i + utf8.RuneLen(r)
+		}
+	}
+	add(s[start:])
+	if len(pieces) == 1 && pieces[0] == "" {
+		return pieces[:0]
+	}
+	return pieces
+}
+
+// Control words that stand for a letter on their own, e.g. {\ae}
+var foreignLetters = map[string]bool{
+	"ae": true, "AE": true, "oe": true, "OE": true, "aa": true, "AA": true,
+	"o": true, "O": true, "l": true, "L": true, "ss": true, "i": true, "j": true,
+}
+
+// Reports whether a name word starts with a lower case letter, following the
+// BibTeX rules: the first letter at brace depth zero decides, a special
+// character such as {\'e} counts by the letter it produces, and any other
+// braced group counts as upper case.
+func isLowerWord(w string) bool {
+	for i := 0; i < len(w); i++ {
+		c := w[i]
+		switch {
+		case c == '{' && i+1 < len(w) && w[i+1] == '\\':
+			return isLowerSpecial(w[i+2:])
+		case c == '{':
+			return false
+		case isLetter(c):
+			return c >= 'a' && c <= 'z'
+		case c >= utf8.RuneSelf:
+			r, _ := utf8.DecodeRuneInString(w[i:])
+			if unicode.IsLetter(r) {
+				return unicode.IsLower(r)
+			}
+		}
+	}
+	return false
+}
+
+// Case of a special character given the text after its opening "{\"
+func isLowerSpecial(s string) bool {
+	j := 0
+	for j < len(s) && isLetter(s[j]) {
+		j++
+	}
+	if j == 0 {
+		j = 1 // control symbol such as \'
+	} else if foreignLetters[s[:j]] {
+		return s[0] >= 'a' && s[0] <= 'z'
+	}
+	for ; j < len(s); j++ {
+		if isLetter(s[j]) {
+			return s[j] >= 'a' && s[j] <= 'z'
+		}
+	}
+	return false
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,91 @@
+package bibtex
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseName(t *testing.T) {
+	cases := []struct {
+		name   string
+		person Person
+	}{
+		{"Donald E. Knuth", Person{First: "Donald E.", Last: "Knuth"}},
+		{"Knuth, Donald E.", Person{First: "Donald E.", Last: "Knuth"}},
+		{"Ludwig van Beethoven", Person{First: "Ludwig", Von: "van", Last: "Beethoven"}},
+		{"van Beethoven, Ludwig", Person{First: "Ludwig", Von: "van", Last: "Beethoven"}},
+		{"Charles Louis Xavier Joseph de la Vall{\\'e}e Poussin",
+			Person{First: "Charles Louis Xavier Joseph", Von: "de la", Last: "Vall{\\'e}e Poussin"}},
+		{"Ford, Jr., Henry", Person{First: "Henry", Last: "Ford", Jr: "Jr."}},
+		{"Jean-Pierre Dupont", Person{First: "Jean-Pierre", Last: "Dupont"}},
+		{"Dupont, Jean-Pierre", Person{First: "Jean-Pierre", Last: "Dupont"}},
+		{"{Barnes and Noble, Inc.}", Person{Last: "{Barnes and Noble, Inc.}"}},
+		{"{\\'E}douard Lucas", Person{First: "{\\'E}douard", Last: "Lucas"}},
+		{"Maria {\\'e}tienne Curie", Person{First: "Maria", Von: "{\\'e}tienne", Last: "Curie"}},
+		{"{von Neumann}, John", Person{First: "John", Last: "{von Neumann}"}},
+		{"Nye", Person{Last: "Nye"}},
+	}
+	for _, c := range cases {
+		if p := ParseName(c.name); p != c.person {
+			fmt.Printf("%q parsed as %#v (should be %#v)\n", c.name, p, c.person)
+			t.Fail()
+		}
+	}
+}
+
+func TestParseNames(t *testing.T) {
+	people := ParseNames("Wilson, N. J. and Flowers, G. E. AND {Barnes and Noble} and others")
+	if len(people) != 4 {
+		fmt.Println(len(people), "names parsed (should be 4)")
+		t.FailNow()
+	}
+	if people[1].Last != "Flowers" || people[2].Last != "{Barnes and Noble}" {
+		fmt.Println("unexpected names:", people)
+		t.Fail()
+	}
+	if !people[3].IsOthers() || people[0].IsOthers() {
+		fmt.Println("'others' not recognized")
+		t.Fail()
+	}
+	if len(ParseNames("")) != 0 {
+		t.Fail()
+	}
+}
+
+func TestFormatNames(t *testing.T) {
+	people := []Person{
+		{First: "Ludwig", Von: "van", Last: "Beethoven"},
+		{First: "Henry", Last: "Ford", Jr: "Jr."},
+		{Last: "Barnes and Noble, Inc."},
+		{First: "Maria", Last: "de Souza"},
+		{First: "John, Jr", Last: "Smith"},
+		{Last: "others"},
+	}
+	written := FormatNames(people)
+	expected := "van Beethoven, Ludwig and Ford, Jr., Henry and {Barnes and Noble, Inc.} and " +
+		"{de Souza}, Maria and Smith, {John, Jr} and others"
+	if written != expected {
+		fmt.Println(written)
+		t.Fail()
+	}
+	parsed := ParseNames(written)
+	for i, p := range parsed {
+		if UnicodeBibValue(p.Last) != people[i].Last || p.First != people[i].First && UnicodeBibValue(p.First) != people[i].First {
+			fmt.Printf("%#v read back as %#v\n", people[i], p)
+			t.Fail()
+		}
+	}
+}
+
+func TestAuthorSurname(t *testing.T) {
+	entry := Entry{Fields: Fields{{"author", "Jenkinson, A. and van der Veen, C. J. and Jenkins, B."}}}
+	if !entry.TestAuthorSurname("jenkins") || !entry.TestAuthorSurname("Veen") ||
+		!entry.TestAuthorSurname("van der Veen") || entry.TestAuthorSurname("Jenk") {
+		fmt.Println("unexpected surname matches")
+		t.Fail()
+	}
+	if !entry.TestFirstAuthor("Jenkinson") || entry.TestFirstAuthor("Jenkins") {
+		fmt.Println("unexpected first author matches")
+		t.Fail()
+	}
+}
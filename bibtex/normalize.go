@@ -0,0 +1,74 @@
+package bibtex
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Letters that don't decompose into an ASCII letter and a combining mark
+var asciiLetters = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'Æ': "AE", 'œ': "oe", 'Œ': "OE", 'ø': "o", 'Ø': "O",
+	'ł': "l", 'Ł': "L", 'đ': "d", 'Đ': "D", 'ð': "d", 'Ð': "D", 'þ': "th",
+	'Þ': "TH", 'ı': "i", 'ȷ': "j", 'ŋ': "ng", 'Ŋ': "NG",
+}
+
+// Return text in ASCII, removing accents and spelling out special letters.
+// Other characters outside ASCII are dropped.
+func transliterate(s string) string {
+	var buf strings.Builder
+	for _, r := range norm.NFKD.String(s) {
+		switch {
+		case r < unicode.MaxASCII:
+			buf.WriteRune(r)
+		case asciiLetters[r] != "":
+			buf.WriteString(asciiLetters[r])
+		case unicode.IsSpace(r) || unicode.IsPunct(r):
+			buf.WriteByte(' ')
+		}
+	}
+	return buf.String()
+}
+
+// Return text as searches compare it: LaTeX is decoded, the text is
+// decomposed (Unicode NFKD) so accents can be removed, case is folded, and
+// anything other than letters and digits becomes a single space. With
+// transliterate, letters that have no accent to remove are spelled in ASCII
+// as well, so ø becomes o and ß becomes ss. "M{\aa}rtensson", "Mårtensson"
+// and "MARTENSSON" all give "martensson".
+func FoldText(s string, transliterate bool) string {
+	var buf strings.Builder
+	space := false
+	for _, r := range norm.NFKD.String(DecodeLaTeX(s)) {
+		letters := string(r)
+		if transliterate && asciiLetters[r] != "" {
+			letters = asciiLetters[r]
+		}
+		for _, r := range letters {
+			switch {
+			case unicode.Is(unicode.Mn, r):
+			case unicode.IsLetter(r) || unicode.IsDigit(r):
+				if space && buf.Len() > 0 {
+					buf.WriteByte(' ')
+				}
+				buf.WriteRune(unicode.ToLower(r))
+				space = false
+			default:
+				space = true
+			}
+		}
+	}
+	return buf.String()
+}
+
+// Return text folded for matching, with transliteration
+func matchText(s string) string {
+	return FoldText(s, true)
+}
+
+// Reports whether some text contains a substring once both are folded with
+// matchText. An empty substring is found in anything.
+func includesText(s, sub string) bool {
+	return strings.Contains(matchText(s), matchText(sub))
+}
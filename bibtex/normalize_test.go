@@ -0,0 +1,48 @@
+package bibtex
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFoldText(t *testing.T) {
+	tests := []struct {
+		in, out, transliterated string
+	}{
+		{`M{\aa}rtensson`, "martensson", "martensson"},
+		{"Mårtensson", "martensson", "martensson"},
+		{"MÅRTENSSON, B.", "martensson b", "martensson b"},
+		{`{\O}rsted`, "ørsted", "orsted"},
+		{"Straße", "straße", "strasse"},
+		{`Ice--shelf {M}elting: the {\"U}ber-view`, "ice shelf melting the uber view", "ice shelf melting the uber view"},
+		{"ﬁrn ²", "firn 2", "firn 2"},
+	}
+	for _, test := range tests {
+		if out := FoldText(test.in, false); out != test.out {
+			fmt.Printf("%q folded to %q (should be %q)\n", test.in, out, test.out)
+			t.Fail()
+		}
+		if out := FoldText(test.in, true); out != test.transliterated {
+			fmt.Printf("%q folded to %q with transliteration (should be %q)\n", test.in, out, test.transliterated)
+			t.Fail()
+		}
+	}
+}
+
+func TestFoldedMatching(t *testing.T) {
+	e := Entry{
+		Author: `{\O}rsted, H. C. and M{\aa}rtensson, B.`,
+		Title:  "Über die Straße",
+		Fields: Fields{{Name: "journal", Value: `Ann. Phys. {\"U}`}},
+	}
+	e.Fields.Set("author", e.Author)
+	if !e.TestAuthor("orsted") || !e.TestAuthor("MÅRTENSSON") || e.TestAuthor("martenson") {
+		t.Fail()
+	}
+	if !e.TestTitle("uber die strasse") || !e.TestField("journal", "phys u") {
+		t.Fail()
+	}
+	if !e.TestAuthorSurname("Orsted") || !e.TestFirstAuthor("ørsted") || e.TestFirstAuthor("Martensson") {
+		t.Fail()
+	}
+}
@@ -0,0 +1,547 @@
+package bibtex
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Position of a byte in BibTeX source text. Line and Column are 1-based, and
+// Column counts characters rather than bytes.
+type Pos struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+type partKind int
+
+const (
+	bracedPart partKind = iota // {...}
+	quotedPart                 // "..."
+	numberPart                 // 1999
+	macroPart                  // jgr
+)
+
+// One piece of a field value. Pieces are joined with '#' in the source.
+type valuePart struct {
+	kind partKind
+	text string // without the enclosing delimiters
+}
+
+// A field as it appears in the source, before any interpretation
+type rawField struct {
+	name  string
+	parts []valuePart
+	pos   Pos
+	raw   string // source text from the name to the end of the value
+}
+
+// Return the field value with the pieces concatenated and whitespace runs
+// collapsed, the way BibTeX sees it. Macro names are left as they are.
+func (f rawField) text() string {
+	return f.expand(nil)
+}
+
+// Like text, but replace the macros defined in *macros* by their values.
+// Macro names are looked up in lower case.
+func (f rawField) expand(macros map[string]string) string {
+	var buf strings.Builder
+	for _, p := range f.parts {
+		if p.kind == macroPart {
+			if v, ok := macros[strings.ToLower(p.text)]; ok {
+				buf.WriteString(v)
+				continue
+			}
+		}
+		buf.WriteString(p.text)
+	}
+	return collapseSpace(buf.String())
+}
+
+type blockKind int
+
+const (
+	entryBlock blockKind = iota
+	stringBlock
+	preambleBlock
+	commentBlock
+)
+
+// A top-level @-block. For @string blocks the macro definition is stored as
+// the single field, for @preamble the value is the single field with an empty
+// name, and for @comment the body is kept in key.
+type block struct {
+	kind    blockKind
+	typ     string // block type as written, e.g. "Article"
+	key     string
+	fields  []rawField
+	open    byte // '{' or '('
+	start   Pos  // position of the '@'
+	end     Pos  // position just past the closing delimiter
+	raw     string
+	leading string // text between the previous block and this one
+	comma   bool   // whether the last field is followed by a comma
+}
+
+// Lexer over BibTeX source that keeps track of line and column. Input is
+// read from an io.Reader as it is needed, and text before the current block
+// can be dropped so that large files are not held in memory.
+type lexer struct {
+	r    io.Reader // nil once the input is exhausted
+	buf  []byte    // input from offset base onwards
+	base int
+	off  int
+	line int
+	col  int
+	err  error // error from the reader, other than io.EOF
+}
+
+func newLexer(r io.Reader) *lexer {
+	return &lexer{r: r, line: 1, col: 1}
+}
+
+func (s *lexer) pos() Pos {
+	return Pos{s.off, s.line, s.col}
+}
+
+// Read more input into the buffer, returning false if there is none
+func (s *lexer) fill() bool {
+	for s.r != nil {
+		if len(s.buf) == cap(s.buf) {
+			grown := make([]byte, len(s.buf), 2*cap(s.buf)+4096)
+			copy(grown, s.buf)
+			s.buf = grown
+		}
+		n, err := s.r.Read(s.buf[len(s.buf):cap(s.buf)])
+		s.buf = s.buf[:len(s.buf)+n]
+		if err != nil {
+			if err != io.EOF {
+				s.err = err
+			}
+			s.r = nil
+		}
+		if n > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether there is input at the absolute offset *off*
+func (s *lexer) have(off int) bool {
+	for off-s.base >= len(s.buf) {
+		if !s.fill() {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *lexer) eof() bool {
+	return !s.have(s.off)
+}
+
+// Return the current byte without consuming it, or 0 at the end of input
+func (s *lexer) peek() byte {
+	if s.eof() {
+		return 0
+	}
+	return s.buf[s.off-s.base]
+}
+
+// Consume and return the current byte
+func (s *lexer) next() byte {
+	c := s.peek()
+	s.off++
+	if c == '\n' {
+		s.line++
+		s.col = 1
+	} else if c&0xC0 != 0x80 {
+		// only count the first byte of a UTF-8 sequence
+		s.col++
+	}
+	return c
+}
+
+// Return the input between two absolute offsets
+func (s *lexer) text(from, to int) string {
+	return string(s.buf[from-s.base : to-s.base])
+}
+
+// Drop buffered input before the line containing the absolute offset *off*
+func (s *lexer) discard(off int) {
+	i := bytes.LastIndexByte(s.buf[:off-s.base], '\n') + 1
+	if i < len(s.buf)/2 {
+		// not worth moving yet
+		return
+	}
+	n := copy(s.buf, s.buf[i:])
+	s.buf = s.buf[:n]
+	s.base += i
+}
+
+func (s *lexer) skipSpace() {
+	for !s.eof() && isSpace(s.peek()) {
+		s.next()
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+// Reports whether c may appear in an entry type, field name or macro name
+func isNameChar(c byte) bool {
+	if isSpace(c) {
+		return false
+	}
+	switch c {
+	case '"', '#', '%', '\'', '(', ')', ',', '=', '{', '}', '@':
+		return false
+	}
+	return true
+}
+
+func (s *lexer) scanName() string {
+	start := s.off
+	for !s.eof() && isNameChar(s.peek()) {
+		s.next()
+	}
+	return s.text(start, s.off)
+}
+
+// Scan a citation key, which runs up to a comma, whitespace or the closing
+// delimiter of the entry
+func (s *lexer) scanKey(close byte) string {
+	start := s.off
+	for !s.eof() {
+		c := s.peek()
+		if c == ',' || c == close || isSpace(c) {
+			break
+		}
+		s.next()
+	}
+	return s.text(start, s.off)
+}
+
+// Scan a group opened by the current byte and closed by the matching close
+// byte, honouring nested braces. Returns the text between the delimiters.
+func (s *lexer) scanDelimited(open, close byte) (string, error) {
+	start := s.pos()
+	s.next()
+	depth, braces := 0, 0
+	for !s.eof() {
+		c := s.next()
+		if open != '{' {
+			// delimiters inside braces don't count
+			if c == '{' {
+				braces++
+				continue
+			} else if c == '}' {
+				braces--
+				continue
+			} else if braces > 0 {
+				continue
+			}
+		}
+		switch c {
+		case open:
+			depth++
+		case close:
+			if depth == 0 {
+				return s.text(start.Offset+1, s.off-1), nil
+			}
+			depth--
+		}
+	}
+	return "", s.errorAt(start, fmt.Sprintf("unterminated %c", open))
+}
+
+// Scan a double-quoted string. Quotes inside braces, or escaped as \", do not
+// end the string.
+func (s *lexer) scanQuoted() (string, error) {
+	start := s.pos()
+	s.next()
+	depth := 0
+	var prev byte
+	for !s.eof() {
+		c := s.next()
+		switch {
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth < 0 {
+				return "", s.errorAt(start, "unbalanced braces in quoted string")
+			}
+		case c == '"' && depth == 0 && prev != '\\':
+			return s.text(start.Offset+1, s.off-1), nil
+		}
+		prev = c
+	}
+	return "", s.errorAt(start, "unterminated quoted string")
+}
+
+func (s *lexer) errorAt(p Pos, msg string) error {
+	return ParseError{Line: p.Line, Column: p.Column, Snippet: s.lineAt(p.Offset), Message: msg}
+}
+
+// Return the line of source containing an offset, shortened if it is long
+func (s *lexer) lineAt(offset int) string {
+	if offset < s.base {
+		return ""
+	}
+	start := bytes.LastIndexByte(s.buf[:offset-s.base], '\n') + 1 + s.base
+	end := offset
+	for s.have(end) && s.buf[end-s.base] != '\n' {
+		end++
+	}
+	line := strings.TrimRight(s.text(start, end), "\r")
+	if len(line) > 120 {
+		line = line[:120] + "..."
+	}
+	return line
+}
+
+// Move back to a saved position
+func (s *lexer) reset(p Pos) {
+	s.off, s.line, s.col = p.Offset, p.Line, p.Column
+}
+
+func (s *lexer) expect(c byte) error {
+	s.skipSpace()
+	if s.eof() {
+		return s.errorAt(s.pos(), fmt.Sprintf("expected '%c' but reached end of input", c))
+	}
+	if s.peek() != c {
+		return s.errorAt(s.pos(), fmt.Sprintf("expected '%c' but found '%c'", c, s.peek()))
+	}
+	s.next()
+	return nil
+}
+
+// Parser that returns the @-blocks of a BibTeX file one at a time
+type parser struct {
+	s        *lexer
+	last     int // offset just past the previous block
+	valueEnd int // offset just past the last value parsed
+	trailer  string
+}
+
+func newParser(r io.Reader) *parser {
+	return &parser{s: newLexer(r)}
+}
+
+// Return the next block in the input, or io.EOF when there are none left.
+// After a syntax error the parser skips ahead to the next '@' that starts a
+// line, so that calling next again carries on with the following block.
+func (p *parser) next() (block, error) {
+	s := p.s
+	s.discard(p.last)
+	for {
+		// anything outside of an @-block is ignored, as BibTeX does
+		for !s.eof() && s.peek() != '@' {
+			s.next()
+		}
+		if s.eof() {
+			if s.err != nil {
+				return block{}, s.err
+			}
+			p.trailer = s.text(p.last, s.off)
+			p.last = s.off
+			return block{}, io.EOF
+		}
+		start := s.pos()
+		s.next()
+		s.skipSpace()
+		typ := s.scanName()
+		s.skipSpace()
+		if typ == "" || (s.peek() != '{' && s.peek() != '(') {
+			// a stray '@', e.g. in an email address in a comment
+			continue
+		}
+		b, err := p.parseBlock(typ)
+		b.start = start
+		if err != nil {
+			if perr, ok := err.(ParseError); ok && b.kind == entryBlock {
+				perr.Key = b.key
+				err = perr
+			}
+			// the broken block stays part of the text before the next one
+			p.recover(start)
+			return b, err
+		}
+		b.end = s.pos()
+		b.raw = s.text(start.Offset, s.off)
+		b.leading = s.text(p.last, start.Offset)
+		p.last = s.off
+		return b, nil
+	}
+}
+
+// Skip from the start of a broken block to the next '@' at the beginning of
+// a line, ignoring indentation
+func (p *parser) recover(start Pos) {
+	s := p.s
+	s.reset(start)
+	s.next()
+	lineStart := false
+	for !s.eof() {
+		c := s.peek()
+		if c == '@' && lineStart {
+			return
+		}
+		if c == '\n' {
+			lineStart = true
+		} else if c != ' ' && c != '\t' {
+			lineStart = false
+		}
+		s.next()
+	}
+}
+
+func (p *parser) parseBlock(typ string) (block, error) {
+	s := p.s
+	b := block{typ: typ, open: s.peek()}
+	close := byte('}')
+	if b.open == '(' {
+		close = ')'
+	}
+
+	switch strings.ToLower(typ) {
+	case "comment":
+		b.kind = commentBlock
+		body, err := s.scanDelimited(b.open, close)
+		b.key = body
+		return b, err
+	case "preamble":
+		b.kind = preambleBlock
+		s.next()
+		s.skipSpace()
+		field := rawField{pos: s.pos()}
+		parts, err := p.parseValue()
+		if err != nil {
+			return b, err
+		}
+		field.parts = parts
+		field.raw = s.text(field.pos.Offset, p.valueEnd)
+		b.fields = []rawField{field}
+		return b, s.expect(close)
+	case "string":
+		b.kind = stringBlock
+		s.next()
+		field, err := p.parseField()
+		if err != nil {
+			return b, err
+		}
+		b.fields = []rawField{field}
+		return b, s.expect(close)
+	}
+
+	b.kind = entryBlock
+	s.next()
+	s.skipSpace()
+	b.key = s.scanKey(close)
+	for {
+		s.skipSpace()
+		if s.eof() {
+			return b, s.errorAt(s.pos(), fmt.Sprintf("entry %q is not closed", b.key))
+		}
+		switch s.peek() {
+		case close:
+			s.next()
+			return b, nil
+		case ',':
+			s.next()
+			b.comma = true
+		default:
+			return b, s.errorAt(s.pos(), fmt.Sprintf("expected ',' or '%c' but found '%c'", close, s.peek()))
+		}
+		s.skipSpace()
+		if s.peek() == close {
+			// trailing comma after the last field
+			continue
+		}
+		field, err := p.parseField()
+		if err != nil {
+			return b, err
+		}
+		b.fields = append(b.fields, field)
+		b.comma = false
+	}
+}
+
+// Parse a `name = value` pair
+func (p *parser) parseField() (rawField, error) {
+	s := p.s
+	s.skipSpace()
+	field := rawField{pos: s.pos()}
+	field.name = s.scanName()
+	if field.name == "" {
+		if s.eof() {
+			return field, s.errorAt(s.pos(), "expected a field name but reached end of input")
+		}
+		return field, s.errorAt(s.pos(), fmt.Sprintf("expected a field name but found '%c'", s.peek()))
+	}
+	if err := s.expect('='); err != nil {
+		return field, err
+	}
+	s.skipSpace()
+	parts, err := p.parseValue()
+	field.parts = parts
+	if err == nil {
+		field.raw = s.text(field.pos.Offset, p.valueEnd)
+	}
+	return field, err
+}
+
+// Parse a value made of one or more pieces joined with '#'
+func (p *parser) parseValue() ([]valuePart, error) {
+	s := p.s
+	var parts []valuePart
+	for {
+		s.skipSpace()
+		var part valuePart
+		var err error
+		switch c := s.peek(); {
+		case s.eof():
+			err = s.errorAt(s.pos(), "expected a value but reached end of input")
+		case c == '{':
+			part.kind = bracedPart
+			part.text, err = s.scanDelimited('{', '}')
+		case c == '"':
+			part.kind = quotedPart
+			part.text, err = s.scanQuoted()
+		case c >= '0' && c <= '9':
+			part.kind = numberPart
+			part.text = s.scanName()
+		case isNameChar(c):
+			part.kind = macroPart
+			part.text = s.scanName()
+		default:
+			err = s.errorAt(s.pos(), fmt.Sprintf("expected a value but found '%c'", c))
+		}
+		if err != nil {
+			return parts, err
+		}
+		parts = append(parts, part)
+		p.valueEnd = s.off
+
+		s.skipSpace()
+		if s.peek() != '#' {
+			return parts, nil
+		}
+		s.next()
+	}
+}
+
+// Replace runs of whitespace with a single space
+func collapseSpace(str string) string {
+	return strings.Join(strings.Fields(str), " ")
+}
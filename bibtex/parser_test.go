@@ -0,0 +1,210 @@
+package bibtex
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func parsetoarray(src string) ([]block, error) {
+	p := newParser(strings.NewReader(src))
+	blocks := make([]block, 0)
+	for {
+		b, err := p.next()
+		if err == io.EOF {
+			return blocks, nil
+		} else if err != nil {
+			return blocks, err
+		}
+		blocks = append(blocks, b)
+	}
+}
+
+func fieldValue(b block, name string) string {
+	for _, f := range b.fields {
+		if f.name == name {
+			return f.text()
+		}
+	}
+	return ""
+}
+
+func TestParseBraces(t *testing.T) {
+	src := `@Article{Roache:1985:NAG,
+  author =       {Patrick J. Roache and Stanly Steinberg},
+  title =        {New Approach to Grid Generation Using a Variational
+                 Formulation},
+  journal =      {AIAA Paper},
+  pages =        {360--370},
+  year =         {1985},
+  CODEN =        {AAPRAQ},
+  ISSN =         {0146-3705},
+  bibdate =      {Wed Jan 15 15:35:13 MST 1997},
+  bibsource =    {Compendex database;
+                 http://www.math.utah.edu/pub/tex/bib/macsyma.bib},
+  acknowledgement = ack-nhfb,
+  affiliationaddress = {Ecodynamics Research Associates, Albuquerque,
+                 NM, USA},
+  classification = {631; 921; 931},
+  conference =   {Collection of Technical Papers --- AIAA 7th
+                 Computational Fluid Dynamics Conference.},
+  journalabr =   {AIAA Paper},
+  keywords =     {behavioral errors; computational fluid dynamics; fluid
+                 dynamics; mathematical techniques; symbolic
+                 manipulation; Thompson-Thames-Mastin method (TTM
+                 method); VAX 780; Vaxima},
+  meetingaddress = {Cincinnati, OH, Engl},
+  sponsor =      {AIAA, New York, NY, USA},
+}`
+	blocks, err := parsetoarray(src)
+	if err != nil || len(blocks) != 1 {
+		fmt.Println("expected one entry, got", len(blocks), err)
+		t.FailNow()
+	}
+	b := blocks[0]
+	if b.typ != "Article" || b.key != "Roache:1985:NAG" {
+		fmt.Println("unexpected type or key:", b.typ, b.key)
+		t.Fail()
+	}
+	if len(b.fields) != 17 {
+		fmt.Println("wrong number of fields in entry:", len(b.fields))
+		t.Fail()
+	}
+	if v := fieldValue(b, "title"); v != "New Approach to Grid Generation Using a Variational Formulation" {
+		fmt.Println("running title not joined:", v)
+		t.Fail()
+	}
+	if b.fields[9].parts[0].kind != macroPart {
+		fmt.Println("expected acknowledgement to be a macro")
+		t.Fail()
+	}
+}
+
+func TestParseQuotes(t *testing.T) {
+	src := `@Article{Roache:1985:NAG,
+  author =       "Patrick J. Roache and Stanly Steinberg",
+  title =        "New Approach to Grid Generation Using a Variational
+                 Formulation",
+  journal =      "AIAA Paper",
+  year =         "1985",
+  note =         "The {"}quoted{"} word and an escaped \" quote",
+  acknowledgement = ack-nhfb,
+}`
+	blocks, err := parsetoarray(src)
+	if err != nil || len(blocks) != 1 {
+		fmt.Println("expected one entry, got", len(blocks), err)
+		t.FailNow()
+	}
+	if len(blocks[0].fields) != 6 {
+		fmt.Println("wrong number of fields in entry:", len(blocks[0].fields))
+		t.Fail()
+	}
+	if v := fieldValue(blocks[0], "note"); v != `The {"}quoted{"} word and an escaped \" quote` {
+		fmt.Println("unexpected quoted value:", v)
+		t.Fail()
+	}
+}
+
+func TestParseCompactLayouts(t *testing.T) {
+	src := `@article{a, title={One}, year=1999}
+@book{b,title="Two",
+  url = {http://example.com/?a=1&b=2}, year = {2001}}
+@misc ( c , title = {Three (and a paren)} , )
+@inproceedings{d,
+  title = {Four {\em nested {deeply}}}}
+@misc{e}`
+	blocks, err := parsetoarray(src)
+	if err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	if len(blocks) != 5 {
+		fmt.Println(len(blocks), "entries parsed (should be 5)")
+		t.FailNow()
+	}
+	keys := []string{"a", "b", "c", "d", "e"}
+	for i, key := range keys {
+		if blocks[i].key != key {
+			fmt.Println("expected key", key, "but got", blocks[i].key)
+			t.Fail()
+		}
+	}
+	if v := fieldValue(blocks[0], "year"); v != "1999" {
+		fmt.Println("bare number not parsed:", v)
+		t.Fail()
+	}
+	if v := fieldValue(blocks[1], "url"); v != "http://example.com/?a=1&b=2" {
+		fmt.Println("value with '=' was cut:", v)
+		t.Fail()
+	}
+	if v := fieldValue(blocks[2], "title"); v != "Three (and a paren)" {
+		fmt.Println("parenthesis-delimited entry not parsed:", v)
+		t.Fail()
+	}
+	if v := fieldValue(blocks[3], "title"); v != `Four {\em nested {deeply}}` {
+		fmt.Println("nested braces not kept:", v)
+		t.Fail()
+	}
+	if len(blocks[4].fields) != 0 {
+		fmt.Println("expected an entry with no fields")
+		t.Fail()
+	}
+}
+
+func TestParseConcatenation(t *testing.T) {
+	src := `@String{jgr = "J. Geophys. Res."}
+@article{x, journal = jgr # " Oceans" # {!}, month = jan}`
+	blocks, err := parsetoarray(src)
+	if err != nil || len(blocks) != 2 {
+		fmt.Println("expected two blocks, got", len(blocks), err)
+		t.FailNow()
+	}
+	if blocks[0].kind != stringBlock || blocks[0].fields[0].name != "jgr" {
+		fmt.Println("@String block not recognized")
+		t.Fail()
+	}
+	parts := blocks[1].fields[0].parts
+	if len(parts) != 3 || parts[0].kind != macroPart || parts[1].kind != quotedPart || parts[2].kind != bracedPart {
+		fmt.Println("unexpected concatenation:", parts)
+		t.Fail()
+	}
+}
+
+func TestParseIgnoresJunk(t *testing.T) {
+	src := `%% written by someone@example.com
+Some text that BibTeX ignores.
+@Comment{This is {ignored} too}
+@preamble{ "\newcommand{\noop}[1]{}" }
+@article{ok, title = {Fine}}`
+	blocks, err := parsetoarray(src)
+	if err != nil || len(blocks) != 3 {
+		fmt.Println("expected three blocks, got", len(blocks), err)
+		t.FailNow()
+	}
+	if blocks[0].kind != commentBlock || blocks[0].key != "This is {ignored} too" {
+		fmt.Println("@Comment not parsed:", blocks[0].key)
+		t.Fail()
+	}
+	if blocks[1].kind != preambleBlock {
+		fmt.Println("@preamble not parsed")
+		t.Fail()
+	}
+	if blocks[2].key != "ok" || blocks[2].start.Line != 5 {
+		fmt.Println("unexpected final entry", blocks[2].key, blocks[2].start)
+		t.Fail()
+	}
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	src := "@article{a, title = {One}}\n@article{b,\n  title = {Two\n"
+	_, err := parsetoarray(src)
+	if err == nil {
+		fmt.Println("expected an error for unterminated value")
+		t.FailNow()
+	}
+	if err.Error() != "3:11: unterminated { (in entry b)" {
+		fmt.Println("unexpected error:", err)
+		t.Fail()
+	}
+}
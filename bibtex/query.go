@@ -0,0 +1,385 @@
+package bibtex
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A test that an entry either passes or fails
+type Predicate func(e Entry) bool
+
+// Compile a search query into a Predicate. A query is made of terms such as
+//
+//	jenkins                  any field contains the word
+//	"ice shelf"              any field contains the phrase
+//	author:jenkins           the field contains the word
+//	title:"ice shelf"        the field contains the phrase
+//	year:1995..2005          the field is in a range, which may be open
+//	                         at either end, as in 1995.. or ..2005
+//	date:2019-03..2020       the date, from the date field or else the year
+//	                         and month, falls at least partly in a range
+//	doi:*                    the entry has the field
+//
+// combined with AND, OR and NOT (or -), and grouped with parentheses. Terms
+// next to each other must all match, and AND binds more tightly than OR.
+// Any field can be named, along with key for the citation key, or a key
+// retired by a merge and kept in the ids field, and type for the entry
+// type. Text matches the start of words ignoring case, accents and
+// punctuation, and ranges compare numbers where both ends are numbers. The
+// year is taken from the date field when there is no year.
+func CompileQuery(query string) (Predicate, error) {
+	pred, _, err := compileQuery(query)
+	return pred, err
+}
+
+// Return the words and phrases in a query that search every field, leaving
+// out those under NOT, to rank the entries that match it
+func QueryTerms(query string) ([]string, error) {
+	_, terms, err := compileQuery(query)
+	return terms, err
+}
+
+func compileQuery(query string) (Predicate, []string, error) {
+	p := queryParser{query: query}
+	p.next()
+	if p.tok.kind == queryEnd {
+		return nil, nil, fmt.Errorf("empty query")
+	}
+	pred, err := p.parseOr()
+	if err == nil && p.err != nil {
+		err = p.err
+	} else if err == nil && p.tok.kind != queryEnd {
+		err = p.errorf("unexpected %s", p.tok)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return pred, p.terms, nil
+}
+
+type queryTokenKind int
+
+const (
+	queryEnd queryTokenKind = iota
+	queryTerm
+	queryAnd
+	queryOr
+	queryNot
+	queryOpen
+	queryClose
+)
+
+type queryToken struct {
+	kind   queryTokenKind
+	offset int
+	field  string // for terms, empty to search every field
+	value  string
+	phrase bool
+}
+
+func (t queryToken) String() string {
+	switch t.kind {
+	case queryEnd:
+		return "end of query"
+	case queryOpen:
+		return "'('"
+	case queryClose:
+		return "')'"
+	}
+	return fmt.Sprintf("%q", t.value)
+}
+
+type queryParser struct {
+	query string
+	off   int
+	tok   queryToken
+	err   error
+	terms []string // free text that isn't negated
+	not   int      // how many NOTs the parser is inside
+}
+
+func (p *queryParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("query column %d: %s", p.tok.offset+1, fmt.Sprintf(format, args...))
+}
+
+// Read the next token into p.tok
+func (p *queryParser) next() {
+	q := p.query
+	for p.off < len(q) && isSpace(q[p.off]) {
+		p.off++
+	}
+	p.tok = queryToken{offset: p.off}
+	if p.off == len(q) {
+		p.tok.kind = queryEnd
+		return
+	}
+	switch q[p.off] {
+	case '(':
+		p.tok.kind = queryOpen
+		p.off++
+		return
+	case ')':
+		p.tok.kind = queryClose
+		p.off++
+		return
+	case '-':
+		p.tok.kind = queryNot
+		p.off++
+		return
+	}
+
+	p.tok.kind = queryTerm
+	start := p.off
+	for p.off < len(q) && !isSpace(q[p.off]) && !strings.ContainsRune(`():"`, rune(q[p.off])) {
+		p.off++
+	}
+	word := q[start:p.off]
+	if p.off < len(q) && q[p.off] == ':' && word != "" {
+		p.tok.field = strings.ToLower(word)
+		p.off++
+		start = p.off
+		for p.off < len(q) && !isSpace(q[p.off]) && !strings.ContainsRune(`()"`, rune(q[p.off])) {
+			p.off++
+		}
+		word = q[start:p.off]
+	}
+	if word == "" && p.off < len(q) && q[p.off] == '"' {
+		end := strings.IndexByte(q[p.off+1:], '"')
+		if end == -1 {
+			p.err = fmt.Errorf("query column %d: unterminated phrase", p.off+1)
+			word = q[p.off+1:]
+			p.off = len(q)
+		} else {
+			word = q[p.off+1 : p.off+1+end]
+			p.off += end + 2
+		}
+		p.tok.phrase = true
+	}
+	p.tok.value = word
+	if p.tok.field == "" && !p.tok.phrase {
+		switch word {
+		case "AND":
+			p.tok.kind = queryAnd
+		case "OR":
+			p.tok.kind = queryOr
+		case "NOT":
+			p.tok.kind = queryNot
+		}
+	}
+}
+
+// or = and { "OR" and }
+func (p *queryParser) parseOr() (Predicate, error) {
+	preds := make([]Predicate, 0, 1)
+	for {
+		pred, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		preds = append(preds, pred)
+		if p.tok.kind != queryOr {
+			break
+		}
+		p.next()
+	}
+	if len(preds) == 1 {
+		return preds[0], nil
+	}
+	return func(e Entry) bool {
+		for _, pred := range preds {
+			if pred(e) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// and = not { ["AND"] not }
+func (p *queryParser) parseAnd() (Predicate, error) {
+	preds := make([]Predicate, 0, 1)
+	for {
+		pred, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		preds = append(preds, pred)
+		if p.tok.kind == queryAnd {
+			p.next()
+		} else if p.tok.kind != queryTerm && p.tok.kind != queryNot && p.tok.kind != queryOpen {
+			break
+		}
+	}
+	if len(preds) == 1 {
+		return preds[0], nil
+	}
+	return func(e Entry) bool {
+		for _, pred := range preds {
+			if !pred(e) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// not = ("NOT" | "-") not | "(" or ")" | term
+func (p *queryParser) parseNot() (Predicate, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	switch p.tok.kind {
+	case queryNot:
+		p.next()
+		p.not++
+		pred, err := p.parseNot()
+		p.not--
+		if err != nil {
+			return nil, err
+		}
+		return func(e Entry) bool { return !pred(e) }, nil
+	case queryOpen:
+		p.next()
+		pred, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != queryClose {
+			return nil, p.errorf("expected ')' but found %s", p.tok)
+		}
+		p.next()
+		return pred, nil
+	case queryTerm:
+		tok := p.tok
+		p.next()
+		if tok.field == "" && p.not == 0 {
+			p.terms = append(p.terms, tok.value)
+		}
+		return compileTerm(tok)
+	}
+	return nil, p.errorf("expected a search term but found %s", p.tok)
+}
+
+// Return the predicate for a single term
+func compileTerm(tok queryToken) (Predicate, error) {
+	field, value := tok.field, tok.value
+	if value == "" && !tok.phrase {
+		return nil, fmt.Errorf("query column %d: no value for %s", tok.offset+1, field)
+	}
+	if field != "" && value == "*" && !tok.phrase {
+		return func(e Entry) bool { return queryValue(e, field) != "" }, nil
+	}
+	if field == "date" && !tok.phrase {
+		return compileDate(value)
+	}
+	if i := strings.Index(value, ".."); i != -1 && !tok.phrase {
+		return compileRange(field, value[:i], value[i+2:])
+	}
+
+	text := matchText(value)
+	if field == "year" {
+		if year, err := strconv.Atoi(value); err == nil {
+			return func(e Entry) bool { return e.Year == year }, nil
+		}
+	}
+	if field == "key" {
+		return func(e Entry) bool {
+			return strings.EqualFold(e.BibTeXkey, value) || KeyAliases([]Entry{e})[strings.ToLower(value)] != ""
+		}, nil
+	}
+	if field == "type" {
+		return func(e Entry) bool { return strings.EqualFold(queryValue(e, field), value) }, nil
+	}
+	if field != "" {
+		return func(e Entry) bool { return containsText(queryValue(e, field), text) }, nil
+	}
+	return func(e Entry) bool {
+		if containsText(e.BibTeXkey, text) {
+			return true
+		}
+		for _, f := range e.Fields {
+			if containsText(f.Value, text) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// Return the predicate for a range of values of a field, either end of
+// which may be left open
+func compileRange(field, low, high string) (Predicate, error) {
+	if field == "" {
+		return nil, fmt.Errorf("query range %s..%s needs a field", low, high)
+	}
+	lowNum, lowErr := strconv.ParseFloat(low, 64)
+	highNum, highErr := strconv.ParseFloat(high, 64)
+	numeric := (low == "" || lowErr == nil) && (high == "" || highErr == nil)
+	if numeric {
+		return func(e Entry) bool {
+			var v float64
+			if field == "year" {
+				if e.Year == 0 {
+					return false
+				}
+				v = float64(e.Year)
+			} else {
+				var err error
+				if v, err = strconv.ParseFloat(UnicodeBibValue(queryValue(e, field)), 64); err != nil {
+					return false
+				}
+			}
+			return (low == "" || v >= lowNum) && (high == "" || v <= highNum)
+		}, nil
+	}
+	low, high = matchText(low), matchText(high)
+	return func(e Entry) bool {
+		v, ok := e.Fields.Get(field)
+		if !ok {
+			return false
+		}
+		v = matchText(v)
+		return (low == "" || v >= low) && (high == "" || v <= high)
+	}, nil
+}
+
+// Return the predicate for a date or range of dates, as a DateFilter that
+// keeps work that is in press
+func compileDate(value string) (Predicate, error) {
+	if i := strings.Index(value, ".."); i != -1 {
+		value = value[:i] + "/" + value[i+2:]
+	}
+	f, err := ParseDateFilter(value)
+	if err != nil {
+		return nil, fmt.Errorf("query %v", err)
+	}
+	return f.Match, nil
+}
+
+// Return the value a query compares for a field of an entry
+func queryValue(e Entry, field string) string {
+	switch field {
+	case "key":
+		return e.BibTeXkey
+	case "type":
+		return e.Type
+	case "year":
+		if e.Year != 0 {
+			return strconv.Itoa(e.Year)
+		}
+	case "journal":
+		return e.Journal
+	}
+	return e.Field(field)
+}
+
+// Reports whether a value contains text that has been through matchText,
+// starting at the start of a word, so "glacier" matches "glaciers" but "ice"
+// doesn't match "police"
+func containsText(value, text string) bool {
+	if text == "" {
+		return false
+	}
+	return strings.Contains(" "+matchText(value), " "+text)
+}
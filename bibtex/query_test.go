@@ -0,0 +1,70 @@
+package bibtex
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCompileQuery(t *testing.T) {
+	src := `@article{jenkins1997, author = {Jenkins, A. and Doake, C. S. M.}, year = 1997,
+  title = {Ice shelf basal melting}, journal = {Nature}, keywords = {ocean}}
+@article{jenkins2003, author = {Jenkins, Adrian}, year = 2003, volume = 12,
+  title = {Calving of glaciers}, journal = {J. Glaciol.}, keywords = {calving, icebergs}}
+@book{paterson, author = {Paterson, W. S. B.}, date = {1994-06}, title = {The Physics of Glaciers},
+  doi = {10.1016/C2009-0-14802-X}}
+@inproceedings{muller, author = {M{\"u}ller, F.}, year = 2010, title = {Ice-shelf flow},
+  ids = {mueller2010, Mul10}}`
+	bib, err := parseBibliography("", src)
+	if err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+
+	tests := map[string]string{
+		`author:jenkins AND (title:"ice shelf" OR keywords:calving) AND year:1995..2005 -journal:nature`: "jenkins2003",
+		`author:jenkins title:"ice shelf"`: "jenkins1997",
+		`glaciers`:                         "jenkins2003 paterson",
+		`glacier OR muller`:                "jenkins2003 paterson muller",
+		`"ice shelf"`:                      "jenkins1997 muller",
+		`year:..1995`:                      "paterson",
+		`year:2003.. OR year:1994`:         "jenkins2003 paterson muller",
+		`volume:10..20`:                    "jenkins2003",
+		`doi:* OR type:inproceedings`:      "paterson muller",
+		`NOT author:jenkins`:               "paterson muller",
+		`-(ice OR calving)`:                "paterson",
+		`key:JENKINS1997`:                  "jenkins1997",
+		`key:mul10`:                        "muller", // retired by a merge
+		`author:müller`:                    "muller",
+		`author:doake AND NOT (year:1997 OR year:2003)`: "",
+		`ice`:                   "jenkins1997 jenkins2003 muller", // icebergs
+		`author:a..k`:           "jenkins1997 jenkins2003",
+		`date:1994-01..1994-05`: "",
+		`date:1994-06..1997`:    "jenkins1997 paterson",
+	}
+	for query, expected := range tests {
+		pred, err := CompileQuery(query)
+		if err != nil {
+			fmt.Println(query, err)
+			t.Fail()
+			continue
+		}
+		keys := make([]string, 0)
+		for _, e := range bib.Entries {
+			if pred(e) {
+				keys = append(keys, e.BibTeXkey)
+			}
+		}
+		if strings.Join(keys, " ") != expected {
+			fmt.Printf("%s matched %v rather than %s\n", query, keys, expected)
+			t.Fail()
+		}
+	}
+
+	for _, query := range []string{"", "(ice", "ice)", "title:", `title:"ice`, "OR ice", "1990..2000", "ice AND"} {
+		if _, err := CompileQuery(query); err == nil {
+			fmt.Println("expected an error for", query)
+			t.Fail()
+		}
+	}
+}
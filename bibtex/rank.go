@@ -0,0 +1,184 @@
+package bibtex
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// Weights of the fields that search terms are scored against, so a word in
+// the title counts for three times as much as one in the abstract
+var DefaultSearchBoosts = map[string]float64{
+	"title":    3,
+	"keywords": 2,
+	"author":   2,
+	"abstract": 1,
+	"journal":  1,
+}
+
+// Scores entries against search terms with BM25F, which is BM25 with the
+// counts from each field weighted by the field's boost before they are
+// combined. Term frequencies and lengths are in words, and a term occurs
+// wherever a word starts with it, as in a query.
+type Ranker struct {
+	K1     float64 // how quickly repeats of a term stop adding to the score
+	B      float64 // how much long fields are penalized, from 0 to 1
+	Boosts map[string]float64
+
+	n      int
+	avgLen map[string]float64
+	df     map[string]int
+	corpus []Entry
+	docs   []rankDoc      // the boosted fields of corpus, normalized
+	index  map[string]int // from keys in lower case to entries in corpus
+}
+
+// The boosted fields of an entry, through matchText, and their lengths in
+// words
+type rankDoc struct {
+	values  map[string]string
+	lengths map[string]int
+}
+
+// Return a Ranker that takes how common terms are and how long fields are
+// from *entries*, normalizing their fields once. Fields without a positive
+// boost aren't searched, and fields added to Boosts afterwards aren't
+// either.
+func NewRanker(entries []Entry, boosts map[string]float64) *Ranker {
+	r := &Ranker{
+		K1:     1.2,
+		B:      0.75,
+		Boosts: make(map[string]float64),
+		n:      len(entries),
+		avgLen: make(map[string]float64),
+		df:     make(map[string]int),
+		corpus: entries,
+		docs:   make([]rankDoc, len(entries)),
+		index:  make(map[string]int, len(entries)),
+	}
+	for field, boost := range boosts {
+		if boost > 0 {
+			r.Boosts[strings.ToLower(field)] = boost
+		}
+	}
+	total := make(map[string]int)
+	for i, e := range entries {
+		r.docs[i] = r.document(e)
+		r.index[strings.ToLower(e.BibTeXkey)] = i
+		for field, n := range r.docs[i].lengths {
+			total[field] += n
+		}
+	}
+	if len(entries) > 0 {
+		for field := range r.Boosts {
+			r.avgLen[field] = float64(total[field]) / float64(len(entries))
+		}
+	}
+	return r
+}
+
+// Return the boosted fields of an entry, normalized
+func (r *Ranker) document(e Entry) rankDoc {
+	doc := rankDoc{make(map[string]string, len(r.Boosts)), make(map[string]int, len(r.Boosts))}
+	for field := range r.Boosts {
+		value := matchText(queryValue(e, field))
+		doc.values[field] = value
+		doc.lengths[field] = len(strings.Fields(value))
+	}
+	return doc
+}
+
+// Return the normalized fields of an entry, from the corpus if it is there
+// unchanged
+func (r *Ranker) lookup(e Entry) rankDoc {
+	if i, ok := r.index[strings.ToLower(e.BibTeXkey)]; ok && sameFields(r.corpus[i], e) {
+		return r.docs[i]
+	}
+	return r.document(e)
+}
+
+// Reports whether two entries have the same key, type and fields
+func sameFields(a, b Entry) bool {
+	if a.BibTeXkey != b.BibTeXkey || a.Type != b.Type || len(a.Fields) != len(b.Fields) {
+		return false
+	}
+	for i, f := range a.Fields {
+		if f != b.Fields[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Return the number of entries in the corpus that contain a term, which has
+// been through matchText
+func (r *Ranker) docFreq(term string) int {
+	if n, ok := r.df[term]; ok {
+		return n
+	}
+	n := 0
+	for _, doc := range r.docs {
+		if r.weight(doc, term) > 0 {
+			n++
+		}
+	}
+	r.df[term] = n
+	return n
+}
+
+// Return the boosted count of a term in an entry, with each field's count
+// scaled by how long the field is compared to the average
+func (r *Ranker) weight(doc rankDoc, term string) float64 {
+	w := 0.0
+	for field, boost := range r.Boosts {
+		tf := strings.Count(" "+doc.values[field], " "+term)
+		if tf == 0 {
+			continue
+		}
+		norm := 1.0
+		if r.avgLen[field] > 0 {
+			norm = 1 - r.B + r.B*float64(doc.lengths[field])/r.avgLen[field]
+		}
+		w += boost * float64(tf) / norm
+	}
+	return w
+}
+
+// Return the score of an entry for search terms, which is zero if it
+// contains none of them
+func (r *Ranker) Score(e Entry, terms []string) float64 {
+	score := 0.0
+	doc := r.lookup(e)
+	for _, term := range terms {
+		term = matchText(term)
+		if term == "" {
+			continue
+		}
+		w := r.weight(doc, term)
+		if w == 0 {
+			continue
+		}
+		df := float64(r.docFreq(term))
+		idf := math.Log(1 + (float64(r.n)-df+0.5)/(df+0.5))
+		score += idf * w * (r.K1 + 1) / (w + r.K1)
+	}
+	return score
+}
+
+// Sort entries from the highest score for search terms to the lowest, and
+// by year where scores are equal
+func (r *Ranker) Sort(entries []Entry, terms []string) {
+	sort.Sort(ByYear(entries))
+	type scored struct {
+		entry Entry
+		score float64
+	}
+	ranked := make([]scored, len(entries))
+	for i, e := range entries {
+		ranked[i] = scored{e, r.Score(e, terms)}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].score > ranked[j].score })
+	for i := range ranked {
+		entries[i] = ranked[i].entry
+	}
+}
@@ -0,0 +1,79 @@
+package bibtex
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRanker(t *testing.T) {
+	src := `@article{abstract2001, author = {Smith, J.}, year = 2001, title = {Ocean heat transport},
+  abstract = {Warm water melts the ice shelf from below.}}
+@article{title1990, author = {Jenkins, A.}, year = 1990, title = {Ice shelf basal melting}}
+@article{title2005, author = {Holland, D.}, year = 2005, title = {Ice shelf basal melting}}
+@article{long, author = {Doe, J.}, year = 2010,
+  title = {A very long title about many things including one ice shelf among glaciers, fjords, sea ice and more}}
+@article{none, author = {Roe, R.}, year = 1980, title = {Permafrost}}`
+	bib, err := parseBibliography("", src)
+	if err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+
+	r := NewRanker(bib.Entries, DefaultSearchBoosts)
+	if r.Score(bib.Entries[4], []string{"ice"}) != 0 {
+		t.Fail()
+	}
+	entries := append([]Entry(nil), bib.Entries...)
+	r.Sort(entries, []string{"ice shelf"})
+	keys := make([]string, len(entries))
+	for i, e := range entries {
+		keys[i] = e.BibTeXkey
+	}
+	// equal titles fall back to year, and the long title and the abstract
+	// count for less
+	expected := "title1990 title2005 long abstract2001 none"
+	if strings.Join(keys, " ") != expected {
+		fmt.Println(keys)
+		t.Fail()
+	}
+
+	// a heavy enough abstract outranks the titles
+	r = NewRanker(bib.Entries, map[string]float64{"title": 1, "abstract": 10, "journal": 0})
+	if _, ok := r.Boosts["journal"]; ok {
+		t.Fail()
+	}
+	r.Sort(entries, []string{"melts"})
+	if entries[0].BibTeXkey != "abstract2001" {
+		fmt.Println(entries[0].BibTeXkey)
+		t.Fail()
+	}
+
+	// entries outside the corpus, or changed since, are scored as they are
+	changed := bib.Entries[4]
+	changed.Fields = Fields{{"title", "Ice"}}
+	if r.Score(changed, []string{"ice"}) == 0 || r.Score(Entry{Fields: Fields{{"title", "Ice"}}}, []string{"ice"}) == 0 {
+		t.Fail()
+	}
+
+	// rarer terms count for more
+	r = NewRanker(bib.Entries, DefaultSearchBoosts)
+	if r.Score(bib.Entries[1], []string{"jenkins"}) <= r.Score(bib.Entries[1], []string{"shelf"}) {
+		t.Fail()
+	}
+}
+
+func TestQueryTerms(t *testing.T) {
+	terms, err := QueryTerms(`ice "sea level" author:jenkins -calving NOT (glacier OR fjord) year:1990..2000`)
+	if err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	if strings.Join(terms, "|") != "ice|sea level" {
+		fmt.Println(terms)
+		t.Fail()
+	}
+	if _, err := QueryTerms("(ice"); err == nil {
+		t.Fail()
+	}
+}
@@ -0,0 +1,154 @@
+package bibtex
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version of the Record layout. It goes up whenever a name is changed or
+// removed or a value changes meaning, but not when a name is added, so tools
+// reading records should ignore names they don't know.
+const RecordSchema = 1
+
+// An entry laid out for other programs to read, as peerbib writes it in JSON
+// and YAML. Values are decoded from LaTeX into Unicode, and field names are
+// in lower case.
+type Record struct {
+	Schema  int               `json:"schema" yaml:"schema"`
+	Key     string            `json:"key" yaml:"key"`
+	Type    string            `json:"type" yaml:"type"`
+	File    string            `json:"file,omitempty" yaml:"file,omitempty"`
+	Line    int               `json:"line,omitempty" yaml:"line,omitempty"`
+	Year    int               `json:"year,omitempty" yaml:"year,omitempty"`
+	Authors []RecordName      `json:"authors" yaml:"authors"`
+	Editors []RecordName      `json:"editors" yaml:"editors"`
+	Fields  map[string]string `json:"fields" yaml:"fields"`
+}
+
+// A person in a Record, in the parts BibTeX splits names into. Others is
+// set for the "and others" at the end of a list.
+type RecordName struct {
+	Given  string `json:"given,omitempty" yaml:"given,omitempty"`
+	Von    string `json:"von,omitempty" yaml:"von,omitempty"`
+	Family string `json:"family,omitempty" yaml:"family,omitempty"`
+	Suffix string `json:"suffix,omitempty" yaml:"suffix,omitempty"`
+	Others bool   `json:"others,omitempty" yaml:"others,omitempty"`
+}
+
+// Return the record for an entry read from the file *fnm*, which may be
+// empty if it is unknown
+func NewRecord(e Entry, fnm string) Record {
+	r := Record{
+		Schema:  RecordSchema,
+		Key:     e.BibTeXkey,
+		Type:    e.Type,
+		File:    fnm,
+		Line:    e.Pos().Line,
+		Year:    e.Year,
+		Authors: recordNames(e.Authors()),
+		Editors: recordNames(e.Editors()),
+		Fields:  make(map[string]string, len(e.Fields)),
+	}
+	for _, f := range e.Fields {
+		r.Fields[strings.ToLower(f.Name)] = UnicodeBibValue(f.Value)
+	}
+	return r
+}
+
+func recordNames(people []Person) []RecordName {
+	names := make([]RecordName, 0, len(people))
+	for _, p := range people {
+		if p.IsOthers() {
+			names = append(names, RecordName{Others: true})
+			continue
+		}
+		names = append(names, RecordName{
+			Given:  UnicodeBibValue(p.First),
+			Von:    UnicodeBibValue(p.Von),
+			Family: UnicodeBibValue(p.Last),
+			Suffix: UnicodeBibValue(p.Jr),
+		})
+	}
+	return names
+}
+
+// Columns that come first in tables of records, in this order. Fields
+// outside this list follow in alphabetical order.
+var RecordColumns = []string{
+	"key", "type", "file", "line", "year", "authors", "editors",
+	"title", "journal", "booktitle", "publisher", "volume", "number", "pages",
+	"doi", "url",
+}
+
+// Return the columns for a table of records: RecordColumns, and then every
+// other field that any of the records has. The author and editor fields are
+// left out, as the authors and editors columns have them.
+func TableColumns(records []Record) []string {
+	columns := append([]string(nil), RecordColumns...)
+	seen := map[string]bool{"author": true, "editor": true}
+	for _, c := range columns {
+		seen[c] = true
+	}
+	var extra []string
+	for _, r := range records {
+		for name := range r.Fields {
+			if !seen[name] {
+				seen[name] = true
+				extra = append(extra, name)
+			}
+		}
+	}
+	sort.Strings(extra)
+	return append(columns, extra...)
+}
+
+// Return the values of a record in the given columns. Names are listed as
+// "von Family, Suffix, Given" separated by semicolons.
+func (r Record) Row(columns []string) []string {
+	row := make([]string, len(columns))
+	for i, c := range columns {
+		switch c {
+		case "key":
+			row[i] = r.Key
+		case "type":
+			row[i] = r.Type
+		case "file":
+			row[i] = r.File
+		case "line":
+			if r.Line != 0 {
+				row[i] = strconv.Itoa(r.Line)
+			}
+		case "year":
+			if r.Year != 0 {
+				row[i] = strconv.Itoa(r.Year)
+			}
+		case "authors":
+			row[i] = joinRecordNames(r.Authors)
+		case "editors":
+			row[i] = joinRecordNames(r.Editors)
+		default:
+			row[i] = r.Fields[c]
+		}
+	}
+	return row
+}
+
+func joinRecordNames(names []RecordName) string {
+	parts := make([]string, len(names))
+	for i, n := range names {
+		if n.Others {
+			parts[i] = "others"
+			continue
+		}
+		name := strings.TrimSpace(n.Von + " " + n.Family)
+		if n.Suffix != "" {
+			name += ", " + n.Suffix
+		}
+		if n.Given != "" {
+			name += ", " + n.Given
+		}
+		parts[i] = name
+	}
+	return strings.Join(parts, "; ")
+}
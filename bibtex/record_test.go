@@ -0,0 +1,51 @@
+package bibtex
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNewRecord(t *testing.T) {
+	src := `@article{lüthi2002,
+  author = {L{\"u}thi, Martin and van der Veen, C. J. and Smith, Jr., John and others},
+  title = {Ice {M}elange},
+  year = 2002,
+  Journal = {J. Glaciol.},
+  note = {first line
+second line}
+}`
+	bib, err := parseBibliography("test.bib", src)
+	if err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	r := NewRecord(bib.Entries[0], "test.bib")
+	if r.Schema != RecordSchema || r.Key != "lüthi2002" || r.Type != "article" || r.File != "test.bib" || r.Line != 1 || r.Year != 2002 {
+		fmt.Printf("%+v\n", r)
+		t.Fail()
+	}
+	if len(r.Authors) != 4 || r.Authors[0].Family != "Lüthi" || r.Authors[0].Given != "Martin" ||
+		r.Authors[1].Von != "van der" || r.Authors[2].Suffix != "Jr." || !r.Authors[3].Others {
+		fmt.Printf("%+v\n", r.Authors)
+		t.Fail()
+	}
+	if r.Editors == nil || len(r.Editors) != 0 {
+		t.Fail()
+	}
+	if r.Fields["title"] != "Ice Melange" || r.Fields["journal"] != "J. Glaciol." {
+		fmt.Println(r.Fields)
+		t.Fail()
+	}
+
+	columns := TableColumns([]Record{r})
+	if strings.Join(columns[:3], ",") != "key,type,file" || columns[len(columns)-1] != "note" {
+		fmt.Println(columns)
+		t.Fail()
+	}
+	row := r.Row(columns)
+	if row[3] != "1" || row[4] != "2002" || row[5] != "Lüthi, Martin; van der Veen, C. J.; Smith, Jr., John; others" || row[7] != "Ice Melange" {
+		fmt.Println(row)
+		t.Fail()
+	}
+}
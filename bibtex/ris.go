@@ -0,0 +1,504 @@
+package bibtex
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RIS type codes for entry types. Types not listed are written as GEN.
+var risTypes = map[string]string{
+	"article":       "JOUR",
+	"book":          "BOOK",
+	"mvbook":        "BOOK",
+	"collection":    "EDBOOK",
+	"booklet":       "PAMP",
+	"inbook":        "CHAP",
+	"incollection":  "CHAP",
+	"inproceedings": "CPAPER",
+	"conference":    "CPAPER",
+	"proceedings":   "CONF",
+	"mastersthesis": "THES",
+	"phdthesis":     "THES",
+	"thesis":        "THES",
+	"techreport":    "RPRT",
+	"report":        "RPRT",
+	"unpublished":   "UNPB",
+	"online":        "ELEC",
+	"electronic":    "ELEC",
+	"www":           "ELEC",
+	"patent":        "PAT",
+	"dataset":       "DATA",
+	"software":      "COMP",
+	"periodical":    "JFULL",
+}
+
+// Entry types for RIS type codes. Codes not listed become misc.
+var risEntryTypes = map[string]string{
+	"JOUR":    "article",
+	"EJOUR":   "article",
+	"JFULL":   "article",
+	"MGZN":    "article",
+	"NEWS":    "article",
+	"ABST":    "article",
+	"INPR":    "article",
+	"BOOK":    "book",
+	"EBOOK":   "book",
+	"EDBOOK":  "book",
+	"CHAP":    "incollection",
+	"ECHAP":   "incollection",
+	"CPAPER":  "inproceedings",
+	"CONF":    "proceedings",
+	"THES":    "phdthesis",
+	"RPRT":    "techreport",
+	"UNPB":    "unpublished",
+	"MANSCPT": "unpublished",
+	"PAMP":    "booklet",
+}
+
+// RIS type codes that are read as misc without a warning
+var risMiscTypes = map[string]bool{
+	"GEN": true, "ELEC": true, "WEB": true, "BLOG": true, "PAT": true, "DATA": true,
+	"COMP": true, "MAP": true, "SOUND": true, "VIDEO": true, "ART": true, "STAND": true,
+	"SER": true, "HEAR": true, "BILL": true, "CASE": true, "STAT": true, "GOVDOC": true,
+	"PCOMM": true, "ICOMM": true, "DICT": true, "ENCYC": true, "AGGR": true, "ANCIENT": true,
+	"CLSWK": true, "CTLG": true, "DBASE": true, "EQUA": true, "FIGURE": true, "LEGAL": true,
+	"MPCT": true, "MULTI": true, "MUSIC": true, "SLIDE": true, "UNBILL": true, "ADVS": true,
+	"CHART": true, "EDBOOK": true,
+}
+
+// Entry types where the SN tag is an ISBN rather than an ISSN
+var isbnTypes = map[string]bool{
+	"book": true, "mvbook": true, "collection": true, "mvcollection": true, "proceedings": true,
+	"inbook": true, "incollection": true, "booklet": true, "manual": true, "reference": true,
+	"inreference": true,
+}
+
+// RIS tags for fields whose tag doesn't depend on the entry type
+var risFieldTags = map[string]string{
+	"title":    "TI",
+	"abstract": "AB",
+	"volume":   "VL",
+	"number":   "IS",
+	"edition":  "ET",
+	"address":  "CY",
+	"url":      "UR",
+	"doi":      "DO",
+	"language": "LA",
+	"series":   "T3",
+	"type":     "M3",
+}
+
+// Fields for RIS tags whose field doesn't depend on the entry type. Tags
+// that are written differently by different sites are read as the same
+// field.
+var risTagFields = map[string]string{
+	"TI": "title", "T1": "title", "AB": "abstract", "N2": "abstract",
+	"VL": "volume", "IS": "number", "ET": "edition", "CY": "address",
+	"UR": "url", "DO": "doi", "LA": "language", "T3": "series", "M3": "type",
+}
+
+// Return the RIS tag for a field of an entry of type *typ*, or "" if there
+// is none. Names, pages, dates, keywords and notes are written separately.
+func risTag(typ, field string) string {
+	switch field {
+	case "journal", "journaltitle":
+		if !bookPartTypes[typ] {
+			return "JO"
+		}
+	case "booktitle":
+		if bookPartTypes[typ] {
+			return "T2"
+		}
+	case "publisher", "school", "institution":
+		if risField(typ, "PB") == field {
+			return "PB"
+		}
+	case "issn", "isbn":
+		if risField(typ, "SN") == field {
+			return "SN"
+		}
+	}
+	return risFieldTags[field]
+}
+
+// Return the field a RIS tag is read into for an entry of type *typ*, or ""
+// if it isn't read into a single field
+func risField(typ, tag string) string {
+	switch tag {
+	case "JO", "JF", "JA", "J2", "T2", "BT":
+		return bibtexField(typ, "container-title")
+	case "PB":
+		return bibtexField(typ, "publisher")
+	case "SN":
+		if isbnTypes[typ] {
+			return "isbn"
+		}
+		return "issn"
+	}
+	return risTagFields[tag]
+}
+
+// A tagged line of RIS, such as "TY  - JOUR". Some sites leave out one of
+// the two spaces, or the space after the hyphen when the value is empty.
+var risLine = regexp.MustCompile(`^([A-Z][A-Z0-9])  ?-(?: (.*))?$`)
+
+type risValue struct {
+	tag, value string
+	line, col  int
+	src        string
+}
+
+type risRecord struct {
+	start  risValue // the TY line
+	values []risValue
+}
+
+// Read entries from RIS. Values continued over several lines are joined
+// with spaces. Problems are reported as an ErrorList giving their place in
+// the file, as for BibTeX, and records that aren't closed with ER are still
+// read.
+func ReadRIS(rd io.Reader, fnm string) ([]Entry, error) {
+	var entries []Entry
+	var errs ErrorList
+	report := func(v risValue, key string, warning bool, format string, args ...interface{}) {
+		errs = append(errs, ParseError{
+			File: fnm, Line: v.line, Column: v.col, Key: key, Snippet: v.src,
+			Message: fmt.Sprintf(format, args...), Warning: warning,
+		})
+	}
+	finish := func(rec *risRecord) {
+		e, problems := risEntry(rec)
+		for _, p := range problems {
+			report(p.at, e.BibTeXkey, true, "%s", p.message)
+		}
+		entries = append(entries, e)
+	}
+
+	sc := bufio.NewScanner(rd)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var rec *risRecord
+	n := 0
+	for sc.Scan() {
+		n++
+		src := strings.TrimRight(sc.Text(), "\r")
+		if n == 1 {
+			src = strings.TrimPrefix(src, "\ufeff")
+		}
+		m := risLine.FindStringSubmatch(src)
+		if m == nil {
+			text := strings.TrimSpace(src)
+			switch {
+			case text == "":
+			case rec != nil && len(rec.values) > 0:
+				last := &rec.values[len(rec.values)-1]
+				last.value = strings.TrimSpace(last.value + " " + text)
+			default:
+				col := strings.Index(src, text) + 1
+				report(risValue{line: n, col: col, src: src}, "", false,
+					"expected a tag such as \"TY  - \" but found %q", text)
+			}
+			continue
+		}
+		v := risValue{tag: m[1], value: strings.TrimSpace(m[2]), line: n, col: 1, src: src}
+		switch {
+		case v.tag == "TY":
+			if rec != nil {
+				finish(rec)
+				report(v, risKey(rec), false, "record starting on line %d has no ER", rec.start.line)
+			}
+			rec = &risRecord{start: v}
+		case rec == nil:
+			report(v, "", false, "%s outside of a record, which starts with TY", v.tag)
+		case v.tag == "ER":
+			finish(rec)
+			rec = nil
+		default:
+			rec.values = append(rec.values, v)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return entries, err
+	}
+	if rec != nil {
+		finish(rec)
+		report(risValue{line: n, col: 1}, risKey(rec), false, "record starting on line %d has no ER", rec.start.line)
+	}
+	return entries, errs.Err()
+}
+
+// Return the ID of a record, or "" if it has none
+func risKey(rec *risRecord) string {
+	for _, v := range rec.values {
+		if v.tag == "ID" {
+			return v.value
+		}
+	}
+	return ""
+}
+
+type risProblem struct {
+	at      risValue
+	message string
+}
+
+// Return the entry for a record, and any problems with its values
+func risEntry(rec *risRecord) (Entry, []risProblem) {
+	var problems []risProblem
+	e := Entry{BibTeXkey: risKey(rec), Type: "misc"}
+	code := strings.ToUpper(rec.start.value)
+	if t, ok := risEntryTypes[code]; ok {
+		e.Type = t
+	} else if !risMiscTypes[code] {
+		problems = append(problems, risProblem{rec.start, fmt.Sprintf("unknown RIS type %q, read as misc", rec.start.value)})
+	}
+
+	// notes may hold fields that RIS has no tag for, including the entry
+	// type, which decides where some tags go
+	var note []string
+	var noteFields Fields
+	for _, v := range rec.values {
+		if v.tag != "N1" {
+			continue
+		}
+		if m := noteFieldLine.FindStringSubmatch(v.value); m != nil {
+			if m[1] == "entrytype" {
+				e.Type = strings.ToLower(m[2])
+			} else {
+				noteFields = append(noteFields, Field{m[1], noteValue(m[1], m[2])})
+			}
+		} else if v.value != "" {
+			note = append(note, v.value)
+		}
+	}
+
+	var authors, editors []Person
+	var keywords []string
+	var startPage, endPage string
+	month := 0
+	for _, v := range rec.values {
+		// empty values are kept only for fields that are read whole
+		if v.value == "" && risField(e.Type, v.tag) == "" {
+			continue
+		}
+		switch v.tag {
+		case "AU", "A1":
+			authors = append(authors, risPerson(v.value))
+		case "A2", "ED":
+			editors = append(editors, risPerson(v.value))
+		case "KW":
+			keywords = append(keywords, EncodeLaTeX(v.value))
+		case "SP":
+			startPage = v.value
+		case "EP":
+			endPage = v.value
+		case "PY", "Y1", "DA":
+			parts := strings.Split(v.value, "/")
+			year, ok := parseYear(parts[0])
+			if !ok {
+				if v.tag != "DA" && !e.Fields.Has("year") {
+					problems = append(problems, risProblem{v, fmt.Sprintf("%s %q doesn't start with a year", v.tag, v.value)})
+					e.Fields.Set("year", EncodeLaTeX(v.value))
+				}
+				continue
+			}
+			if !e.Fields.Has("year") {
+				e.Fields.Set("year", strconv.Itoa(year))
+			}
+			if len(parts) > 1 && month == 0 {
+				if m, err := strconv.Atoi(strings.TrimSpace(parts[1])); err == nil && m >= 1 && m <= 12 {
+					month = m
+				}
+			}
+		case "N1", "ID":
+		default:
+			field := risField(e.Type, v.tag)
+			if field != "" && !e.Fields.Has(field) {
+				e.Fields.Set(field, noteValue(field, v.value))
+			}
+		}
+	}
+	if month != 0 {
+		e.Fields.Set("month", strconv.Itoa(month))
+	}
+	if len(authors) > 0 {
+		e.Fields.Set("author", FormatNames(authors))
+	}
+	if len(editors) > 0 {
+		e.Fields.Set("editor", FormatNames(editors))
+	}
+	switch {
+	case startPage != "" && endPage != "":
+		e.Fields.Set("pages", EncodeLaTeX(startPage)+"--"+EncodeLaTeX(endPage))
+	case startPage != "":
+		e.Fields.Set("pages", EncodeLaTeX(startPage))
+	}
+	if len(keywords) > 0 {
+		e.Fields.Set("keywords", strings.Join(keywords, ", "))
+	}
+	if len(note) > 0 {
+		e.Fields.Set("note", EncodeLaTeX(strings.Join(note, "\n")))
+	}
+	for _, f := range noteFields {
+		if !e.Fields.Has(f.Name) {
+			e.Fields.Set(f.Name, f.Value)
+		}
+	}
+	e.Fields = orderFields(e.Fields, DefaultFieldOrder)
+
+	e.Title = UnicodeBibValue(e.Field("title"))
+	e.Author = UnicodeBibValue(e.Field("author"))
+	e.Journal = UnicodeBibValue(e.Field("journal"))
+	e.Year, _ = parseYear(UnicodeBibValue(e.Field("year")))
+	return e, problems
+}
+
+// Return a person from a RIS name, written as "Last, First" or
+// "Last, First, Suffix". A name of several words without a comma is taken
+// to be an organization.
+func risPerson(name string) Person {
+	parts := strings.Split(name, ",")
+	if len(parts) == 1 {
+		if strings.ContainsAny(strings.TrimSpace(name), " \t") {
+			return Person{Last: "{" + EncodeLaTeX(name) + "}"}
+		}
+		return Person{Last: EncodeLaTeX(name)}
+	}
+	for i := range parts {
+		parts[i] = EncodeLaTeX(strings.TrimSpace(parts[i]))
+	}
+	var p Person
+	p.Von, p.Last = splitVonLast(nameWords(parts[0]))
+	p.First = parts[1]
+	if len(parts) > 2 {
+		p.Jr = strings.Join(parts[2:], ", ")
+	}
+	return p
+}
+
+// Return a name as RIS writes it, "Last, First" or "Last, First, Suffix",
+// with the von part before the last name
+func risName(p Person) string {
+	name := strings.TrimSpace(UnicodeBibValue(p.Von) + " " + UnicodeBibValue(p.Last))
+	if first := UnicodeBibValue(p.First); first != "" || p.Jr != "" {
+		name += ", " + first
+	}
+	if p.Jr != "" {
+		name += ", " + UnicodeBibValue(p.Jr)
+	}
+	return name
+}
+
+// Return an entry as a RIS record. Values are decoded from LaTeX, and fields
+// that RIS has no tag for are written in N1 notes as lines such as
+// "tex.coden: JACOAH", along with the entry type where RIS would read it
+// back as a different one, so that ReadRIS can restore them. RIS splits
+// names on commas, so a name with a comma inside braces, such as
+// "{Symbolics, Inc.}", is read back as a different one.
+func FormatRIS(e Entry) string {
+	var buf strings.Builder
+	tag := func(tag, value string) {
+		value = strings.Join(strings.Fields(value), " ")
+		fmt.Fprintf(&buf, "%s  - %s\n", tag, value)
+	}
+
+	code, ok := risTypes[e.Type]
+	if !ok {
+		code = "GEN"
+	}
+	tag("TY", code)
+	if e.BibTeXkey != "" {
+		tag("ID", e.BibTeXkey)
+	}
+	var notes []string
+	readAs, ok := risEntryTypes[code]
+	if !ok {
+		readAs = "misc"
+	}
+	if readAs != e.Type {
+		notes = append(notes, noteFieldPrefix+"entrytype: "+e.Type)
+	}
+
+	written := map[string]bool{"author": true, "editor": true}
+	for _, p := range e.Authors() {
+		tag("AU", risName(p))
+	}
+	for _, p := range e.Editors() {
+		tag("A2", risName(p))
+	}
+	// a biblatex date is kept in a note, since RIS dates can't hold ranges
+	if r, ok := e.Date(); ok {
+		tag("PY", strconv.Itoa(r.Start.Year))
+		written["year"] = true
+		if r.Start.Month != 0 {
+			tag("DA", fmt.Sprintf("%04d/%02d//", r.Start.Year, r.Start.Month))
+			written["month"] = !e.hasDateField()
+		}
+	}
+	if pages, ok := e.Fields.Get("pages"); ok {
+		bounds := strings.FieldsFunc(UnicodeBibValue(pages), func(r rune) bool {
+			return r == '-' || r == '–' || r == '—'
+		})
+		if len(bounds) == 1 || len(bounds) == 2 {
+			tag("SP", strings.TrimSpace(bounds[0]))
+			if len(bounds) == 2 {
+				tag("EP", strings.TrimSpace(bounds[1]))
+			}
+			written["pages"] = true
+		}
+	}
+	if keywords, ok := e.Fields.Get("keywords"); ok {
+		for _, kw := range strings.Split(UnicodeBibValue(keywords), ",") {
+			if kw = strings.TrimSpace(kw); kw != "" {
+				tag("KW", kw)
+			}
+		}
+		written["keywords"] = true
+	}
+
+	var note string
+	for _, f := range orderFields(e.Fields, DefaultFieldOrder) {
+		name := strings.ToLower(f.Name)
+		if written[name] {
+			continue
+		}
+		value := UnicodeBibValue(f.Value)
+		if verbatimFields[name] {
+			value = strings.TrimSpace(f.Value)
+		}
+		if name == "note" && note == "" {
+			note = value
+			continue
+		}
+		t := risTag(e.Type, name)
+		if t == "" || written[risField(e.Type, t)] || risField(e.Type, t) != name {
+			notes = append(notes, noteFieldPrefix+name+": "+value)
+			continue
+		}
+		tag(t, value)
+		written[name] = true
+	}
+	if note != "" {
+		tag("N1", note)
+	}
+	for _, n := range notes {
+		tag("N1", n)
+	}
+	buf.WriteString("ER  - \n")
+	return buf.String()
+}
+
+// Write entries as RIS records separated by blank lines
+func WriteRIS(w io.Writer, entries []Entry) error {
+	bw := bufio.NewWriter(w)
+	for i, e := range entries {
+		if i > 0 {
+			bw.WriteString("\n")
+		}
+		bw.WriteString(FormatRIS(e))
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,220 @@
+package bibtex
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestReadRIS(t *testing.T) {
+	src := "\ufeffTY  - JOUR\r\n" + `AU  - van der Veen, C. J.
+AU  - Smith, John, Jr.
+AU  - British Antarctic Survey
+TI  - Ice shelves & oceans:
+  a review
+T2  - Journal of Glaciology
+PY  - 2019/03/15/
+VL  - 65
+IS  - 2
+SP  - 101
+EP  - 120
+DO  - 10.1017/jog.2019.1
+SN  - 0022-1430
+KW  - ice shelf
+KW  - ocean
+AB  - First line of the abstract,
+second line.
+N1  - Open access
+N1  - tex.coden: JGLAAT
+Y2  - 2020/01/01
+ER  - 
+
+TY  - CHAP
+ID  - smith2001
+AU  - Smith, A.
+A2  - Jones, B.
+TI  - Calving
+BT  - Glacier Dynamics
+PY  - in press
+SN  - 978-0-00-000000-0
+ER  -
+`
+	entries, err := ReadRIS(strings.NewReader(src), "cite.ris")
+	if len(entries) != 2 {
+		fmt.Println(len(entries), "entries read")
+		t.FailNow()
+	}
+	errs, _ := err.(ErrorList)
+	if len(errs) != 1 || errs.HasErrors() || errs[0].Line != 30 || errs[0].Column != 1 || errs[0].Key != "smith2001" {
+		fmt.Println(err)
+		t.Fail()
+	}
+
+	e := entries[0]
+	expected := map[string]string{
+		"author":   "van der Veen, C. J. and Smith, Jr., John and {British Antarctic Survey}",
+		"title":    `Ice shelves \& oceans: a review`,
+		"journal":  "Journal of Glaciology",
+		"year":     "2019",
+		"month":    "3",
+		"volume":   "65",
+		"number":   "2",
+		"pages":    "101--120",
+		"doi":      "10.1017/jog.2019.1",
+		"issn":     "0022-1430",
+		"keywords": "ice shelf, ocean",
+		"abstract": "First line of the abstract, second line.",
+		"note":     "Open access",
+		"coden":    "JGLAAT",
+	}
+	if e.Type != "article" || e.BibTeXkey != "" || e.Year != 2019 || e.Journal != "Journal of Glaciology" {
+		fmt.Printf("%+v\n", e)
+		t.Fail()
+	}
+	if len(e.Fields) != len(expected) {
+		fmt.Println(e.Fields)
+		t.Fail()
+	}
+	for name, value := range expected {
+		if v := e.Field(name); v != value {
+			fmt.Printf("%s is %q (should be %q)\n", name, v, value)
+			t.Fail()
+		}
+	}
+
+	e = entries[1]
+	if e.Type != "incollection" || e.BibTeXkey != "smith2001" || e.Field("booktitle") != "Glacier Dynamics" ||
+		e.Field("editor") != "Jones, B." || e.Field("year") != "in press" || e.Field("isbn") == "" {
+		fmt.Printf("%+v\n", e)
+		t.Fail()
+	}
+}
+
+func TestReadRISErrors(t *testing.T) {
+	src := `AU  - Nobody, A.
+TY  - JOUR
+TI  - First
+some stray text
+TY  - XYZ
+ID  - second
+TI  - Second
+junk before the end
+`
+	entries, err := ReadRIS(strings.NewReader(src), "bad.ris")
+	if len(entries) != 2 || entries[1].Type != "misc" || entries[0].Title != "First some stray text" {
+		fmt.Println(entries)
+		t.Fail()
+	}
+	errs, ok := err.(ErrorList)
+	if !ok {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	expected := []string{
+		"bad.ris:1:1: AU outside of a record, which starts with TY",
+		"bad.ris:5:1: record starting on line 2 has no ER",
+		`bad.ris:5:1: warning: unknown RIS type "XYZ", read as misc (in entry second)`,
+		"bad.ris:8:1: record starting on line 5 has no ER (in entry second)",
+	}
+	if len(errs) != len(expected) {
+		fmt.Println(errs)
+		t.FailNow()
+	}
+	for i, e := range errs {
+		if e.Error() != expected[i] {
+			fmt.Println(e)
+			t.Fail()
+		}
+	}
+	if errs[0].Snippet != "AU  - Nobody, A." {
+		t.Fail()
+	}
+
+	_, err = ReadRIS(strings.NewReader("not RIS at all\n"), "")
+	if errs, ok := err.(ErrorList); !ok || len(errs) != 1 || errs[0].Column != 1 {
+		fmt.Println(err)
+		t.Fail()
+	}
+}
+
+func TestRISRoundTrip(t *testing.T) {
+	entries := readtoarray("macsyma.bib")
+	var buf bytes.Buffer
+	if err := WriteRIS(&buf, entries); err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	written := buf.String()
+	read, err := ReadRIS(&buf, "macsyma.ris")
+	if err != nil {
+		fmt.Println(err)
+		t.Fail()
+	}
+	if len(read) != len(entries) {
+		fmt.Println(len(read), "entries read back from", len(entries))
+		t.FailNow()
+	}
+
+	for i, e := range entries {
+		back := read[i]
+		if back.BibTeXkey != e.BibTeXkey || back.Type != e.Type || back.Year != e.Year {
+			fmt.Printf("%s @%s %d read back as %s @%s %d\n", e.BibTeXkey, e.Type, e.Year, back.BibTeXkey, back.Type, back.Year)
+			t.Fail()
+		}
+		for _, f := range e.Fields {
+			name := strings.ToLower(f.Name)
+			v, ok := back.Fields.Get(name)
+			switch {
+			case !ok:
+				fmt.Printf("%s: %s was lost\n", e.BibTeXkey, name)
+				t.Fail()
+			case name == "author" || name == "editor":
+				// RIS can't hold a comma inside a name
+				if risCommaName(ParseNames(f.Value)) {
+					continue
+				}
+				if a, b := personStrings(ParseNames(f.Value)), personStrings(ParseNames(v)); a != b {
+					fmt.Printf("%s: %s %q read back as %q\n", e.BibTeXkey, name, a, b)
+					t.Fail()
+				}
+			case name == "month":
+				if parseMonth(f.Value) != parseMonth(v) {
+					fmt.Printf("%s: month %q read back as %q\n", e.BibTeXkey, f.Value, v)
+					t.Fail()
+				}
+			case strings.Join(strings.Fields(UnicodeBibValue(f.Value)), " ") != UnicodeBibValue(v):
+				fmt.Printf("%s: %s %q read back as %q\n", e.BibTeXkey, name, UnicodeBibValue(f.Value), UnicodeBibValue(v))
+				t.Fail()
+			}
+		}
+	}
+
+	// names with commas in them come back different, and so are written
+	// differently
+	for i := range read {
+		for _, name := range []string{"author", "editor"} {
+			if risCommaName(ParseNames(entries[i].Field(name))) {
+				read[i].Fields.Set(name, entries[i].Field(name))
+			}
+		}
+	}
+	buf.Reset()
+	WriteRIS(&buf, read)
+	if buf.String() != written {
+		fmt.Println("RIS changed when it was read and written again")
+		t.Fail()
+	}
+}
+
+// Reports whether any of the people has a comma inside a part of their name
+func risCommaName(people []Person) bool {
+	for _, p := range people {
+		for _, part := range []string{p.First, p.Von, p.Last, p.Jr} {
+			if strings.Contains(UnicodeBibValue(part), ",") {
+				return true
+			}
+		}
+	}
+	return false
+}
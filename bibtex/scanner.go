@@ -0,0 +1,97 @@
+package bibtex
+
+import (
+	"context"
+	"io"
+)
+
+// Reads the entries of a BibTeX file one at a time, in the style of
+// bufio.Scanner:
+//
+//	s := NewScanner(ctx, r, "refs.bib")
+//	for s.Next() {
+//		entry := s.Entry()
+//		...
+//	}
+//	if err := s.Err(); err != nil {
+//		...
+//	}
+//
+// Input is read as it is needed, so a Scanner can stop partway through a
+// file. Syntax errors don't stop the Scanner; they are collected and
+// available from Diagnostics.
+type Scanner struct {
+	ctx   context.Context
+	r     *bibReader
+	entry Entry
+	err   error
+	done  bool
+}
+
+// Return a Scanner reading from *r*. *fnm* is used in diagnostics and may be
+// empty. Cancelling *ctx* stops the Scanner before the next entry.
+func NewScanner(ctx context.Context, r io.Reader, fnm string) *Scanner {
+	return &Scanner{ctx: ctx, r: newBibReader(fnm, r)}
+}
+
+// Advance to the next entry, returning false at the end of the input, when
+// the context is cancelled or when the reader fails
+func (s *Scanner) Next() bool {
+	for !s.done {
+		if err := s.ctx.Err(); err != nil {
+			s.err = err
+			s.done = true
+			break
+		}
+		b, err := s.r.next()
+		if err != nil {
+			if err != io.EOF {
+				s.err = err
+			}
+			s.done = true
+			break
+		}
+		if b.kind == entryBlock {
+			s.entry = s.r.parseEntry(b)
+			return true
+		}
+	}
+	s.entry = Entry{}
+	return false
+}
+
+// Return the entry found by the last call to Next
+func (s *Scanner) Entry() Entry {
+	return s.entry
+}
+
+// Return the error that stopped the Scanner, which is nil at the end of the
+// input. Problems in the BibTeX itself are reported by Diagnostics instead.
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+// Return the syntax errors and warnings found so far
+func (s *Scanner) Diagnostics() ErrorList {
+	return s.r.errs
+}
+
+// Return the @string macros defined so far, including the predefined month
+// names, keyed by lower case name
+func (s *Scanner) Macros() map[string]string {
+	return s.r.macros
+}
+
+// Read all the entries from *r*. The error is the one that stopped reading,
+// if any, and otherwise an ErrorList of any problems in the BibTeX.
+func ReadEntries(ctx context.Context, r io.Reader, fnm string) ([]Entry, error) {
+	entries := make([]Entry, 0)
+	s := NewScanner(ctx, r, fnm)
+	for s.Next() {
+		entries = append(entries, s.Entry())
+	}
+	if err := s.Err(); err != nil {
+		return entries, err
+	}
+	return entries, s.Diagnostics().Err()
+}
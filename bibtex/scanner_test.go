@@ -0,0 +1,119 @@
+package bibtex
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestScannerSmallReads(t *testing.T) {
+	data, err := ioutil.ReadFile("macsyma.bib")
+	if err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	whole, err := ReadEntries(context.Background(), bytes.NewReader(data), "macsyma.bib")
+	if err != nil || len(whole) != 491 {
+		fmt.Println(len(whole), "entries read (should be 491)", err)
+		t.FailNow()
+	}
+	s := NewScanner(context.Background(), iotest.OneByteReader(bytes.NewReader(data)), "macsyma.bib")
+	i := 0
+	for s.Next() {
+		if s.Entry().BibTeXkey != whole[i].BibTeXkey || s.Entry().Title != whole[i].Title {
+			fmt.Println("entry", i, "differs when read a byte at a time:", s.Entry().BibTeXkey)
+			t.Fail()
+			break
+		}
+		i++
+	}
+	if i != len(whole) || s.Err() != nil {
+		fmt.Println(i, "entries read a byte at a time", s.Err())
+		t.Fail()
+	}
+
+	// a Bibliography read a byte at a time still writes back identically
+	bib, err := ParseBibliography(iotest.HalfReader(bytes.NewReader(data)), "macsyma.bib")
+	if err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	var buf bytes.Buffer
+	WriteBibliography(&buf, bib, WriteOptions{Mode: PreserveMode})
+	if !bytes.Equal(buf.Bytes(), data) {
+		fmt.Println("round trip through a slow reader is not byte-identical")
+		t.Fail()
+	}
+}
+
+func TestScannerStopsEarly(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := strings.Repeat("@misc{k, title = {T}}\n", 100)
+	s := NewScanner(ctx, strings.NewReader(src), "")
+	n := 0
+	for s.Next() {
+		n++
+		if n == 3 {
+			cancel()
+		}
+	}
+	if n != 3 || s.Err() != context.Canceled {
+		fmt.Println(n, "entries read after cancelling (should be 3)", s.Err())
+		t.Fail()
+	}
+}
+
+func TestScannerReaderError(t *testing.T) {
+	broken := errors.New("disk on fire")
+	s := NewScanner(context.Background(), &failingReader{strings.NewReader("@misc{a, title = {A}}\n"), broken}, "")
+	n := 0
+	for s.Next() {
+		n++
+	}
+	if n != 1 || s.Err() != broken {
+		fmt.Println(n, "entries read before the error", s.Err())
+		t.Fail()
+	}
+}
+
+func TestScannerGzip(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte("@string{jgr = {J. Geophys. Res.}}\n@article{a, journal = jgr}\n@article{b, title = {x\n"))
+	gz.Close()
+
+	r, err := gzip.NewReader(&buf)
+	if err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	entries, err := ReadEntries(context.Background(), r, "refs.bib.gz")
+	if len(entries) != 1 || entries[0].Journal != "J. Geophys. Res." {
+		fmt.Println("unexpected entries from gzip stream:", entries)
+		t.Fail()
+	}
+	if errs, ok := err.(ErrorList); !ok || len(errs) != 1 || errs[0].File != "refs.bib.gz" {
+		fmt.Println("expected one diagnostic but got", err)
+		t.Fail()
+	}
+}
+
+// Returns the contents of a reader and then fails
+type failingReader struct {
+	r   *strings.Reader
+	err error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.r.Len() == 0 {
+		return 0, f.err
+	}
+	return f.r.Read(p)
+}
@@ -0,0 +1,441 @@
+package bibtex
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// How a Bibliography is written back out
+type WriteMode int
+
+const (
+	// Keep the file as it was read: comments and other text between entries,
+	// field order, @string blocks, macros and value delimiters are all kept,
+	// and unchanged entries are copied byte for byte
+	PreserveMode WriteMode = iota
+	// Write every block in the canonical layout described by WriteOptions
+	NormalizeMode
+)
+
+// Layout for written BibTeX. In PreserveMode the layout options only apply to
+// entries that were not read from a file.
+type WriteOptions struct {
+	Mode          WriteMode
+	Indent        string   // before each field
+	AlignValues   bool     // pad field names so that the '=' signs line up
+	FieldOrder    []string // fields to write first, in this order
+	QuoteValues   bool     // delimit values with "..." rather than {...}
+	TrailingComma bool     // put a comma after the last field
+}
+
+// Options for a canonical layout: lower case names, braced values, aligned
+// '=' signs and a two space indent
+func DefaultWriteOptions() WriteOptions {
+	return WriteOptions{
+		Mode:          NormalizeMode,
+		Indent:        "  ",
+		AlignValues:   true,
+		TrailingComma: true,
+	}
+}
+
+// A conventional order for fields: who, what, where and when, then how to
+// find the work
+var DefaultFieldOrder = []string{
+	"author", "editor", "title", "subtitle", "booktitle", "journal", "journaltitle",
+	"year", "month", "date", "volume", "number", "issue", "pages", "chapter",
+	"edition", "series", "publisher", "organization", "institution", "school",
+	"address", "location", "howpublished", "type", "note", "isbn", "issn",
+	"doi", "eprint", "url", "urldate",
+}
+
+// What an entry looked like when it was read, so that the writer can tell
+// whether it has been changed
+type entrySource struct {
+	block  block
+	typ    string
+	key    string
+	fields Fields
+}
+
+func (src *entrySource) unchanged(e Entry) bool {
+	if src.typ != e.Type || src.key != e.BibTeXkey || len(src.fields) != len(e.Fields) {
+		return false
+	}
+	for i, f := range src.fields {
+		if f != e.Fields[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Return the source field matching a field of the current entry, if its value
+// is still the same
+func (src *entrySource) field(f Field) (rawField, bool) {
+	for i, raw := range src.block.fields {
+		if strings.EqualFold(raw.name, f.Name) {
+			return raw, src.fields[i].Value == f.Value
+		}
+	}
+	return rawField{}, false
+}
+
+// A top-level block of a file as it was read
+type sourceBlock struct {
+	block block
+	value string       // expanded value of @string and @preamble, body of @comment
+	entry *entrySource // nil unless the block was read into an entry
+}
+
+// Write a bibliography as BibTeX
+func WriteBibliography(w io.Writer, bib Bibliography, opts WriteOptions) error {
+	bw := bufio.NewWriter(w)
+	if opts.Mode == PreserveMode {
+		writePreserved(bw, bib, opts)
+	} else {
+		writeNormalized(bw, bib, opts)
+	}
+	return bw.Flush()
+}
+
+// Write a bibliography to a BibTeX file. As with OpenBibTeX, files ending in
+// ".gz" are compressed.
+func WriteBibTeX(fnm string, bib Bibliography, opts WriteOptions) error {
+	f, err := CreateBibTeX(fnm)
+	if err != nil {
+		return err
+	}
+	err = WriteBibliography(f, bib, opts)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Write the blocks in the order they were read, copying anything unchanged
+// from the source and appending new blocks at the end
+func writePreserved(w *bufio.Writer, bib Bibliography, opts WriteOptions) {
+	// entries are matched to the blocks they were read from, and copies of an
+	// entry after the first are treated as new
+	first := make(map[*entrySource]int, len(bib.Entries))
+	for i, e := range bib.Entries {
+		if _, seen := first[e.src]; e.src != nil && !seen {
+			first[e.src] = i
+		}
+	}
+	written := make(map[*entrySource]bool, len(bib.Entries))
+	writtenStrings := make(map[string]bool, len(bib.Strings))
+	npreamble, ncomment := 0, 0
+
+	for _, source := range bib.source {
+		b := source.block
+		switch b.kind {
+		case entryBlock:
+			if source.entry == nil {
+				// never turned into an entry, so keep it as it is
+				w.WriteString(b.leading + b.raw)
+				continue
+			}
+			i, ok := first[source.entry]
+			if !ok {
+				// deleted, but keep any comments that were before it
+				if strings.TrimSpace(b.leading) != "" {
+					w.WriteString(b.leading)
+				}
+				continue
+			}
+			w.WriteString(b.leading)
+			if e := bib.Entries[i]; source.entry.unchanged(e) {
+				w.WriteString(b.raw)
+			} else {
+				writeChangedEntry(w, bib.Entries[i], opts)
+			}
+			written[source.entry] = true
+		case stringBlock:
+			w.WriteString(b.leading)
+			name := b.fields[0].name
+			value, ok := bib.Strings.Get(name)
+			if !ok || writtenStrings[strings.ToLower(name)] {
+				continue
+			}
+			writtenStrings[strings.ToLower(name)] = true
+			if value == source.value {
+				w.WriteString(b.raw)
+			} else {
+				fmt.Fprintf(w, "@%s%c%s = %s%c", b.typ, b.open, name,
+					delimit(value, b.fields[0].parts[0].kind == quotedPart), closing(b.open))
+			}
+		case preambleBlock:
+			w.WriteString(b.leading)
+			if npreamble < len(bib.Preambles) {
+				if bib.Preambles[npreamble] == source.value {
+					w.WriteString(b.raw)
+				} else {
+					fmt.Fprintf(w, "@%s%c%s%c", b.typ, b.open, delimit(bib.Preambles[npreamble], true), closing(b.open))
+				}
+			}
+			npreamble++
+		case commentBlock:
+			w.WriteString(b.leading)
+			if ncomment < len(bib.Comments) {
+				if bib.Comments[ncomment] == source.value {
+					w.WriteString(b.raw)
+				} else {
+					fmt.Fprintf(w, "@%s{%s}", b.typ, bib.Comments[ncomment])
+				}
+			}
+			ncomment++
+		}
+	}
+	w.WriteString(bib.trailer)
+
+	// anything that was added since the file was read
+	for _, s := range bib.Strings {
+		if !writtenStrings[strings.ToLower(s.Name)] {
+			writtenStrings[strings.ToLower(s.Name)] = true
+			writeString(w, s, opts)
+		}
+	}
+	for ; npreamble < len(bib.Preambles); npreamble++ {
+		writePreamble(w, bib.Preambles[npreamble], opts)
+	}
+	for ; ncomment < len(bib.Comments); ncomment++ {
+		writeComment(w, bib.Comments[ncomment])
+	}
+	for i, e := range bib.Entries {
+		if e.src != nil && written[e.src] && first[e.src] == i {
+			continue
+		}
+		w.WriteString("\n")
+		writeEntry(w, e, opts)
+	}
+}
+
+// Write an entry that was read from a file and has since been changed, in
+// the style of the original: unchanged fields are copied from the source and
+// changed ones keep their name and delimiter
+func writeChangedEntry(w *bufio.Writer, e Entry, opts WriteOptions) {
+	src := e.src
+	b := src.block
+	typ := e.Type
+	if strings.EqualFold(src.typ, e.Type) {
+		typ = b.typ
+	}
+	indent := opts.Indent
+	width := 0 // of the names, if the source lines up its '=' signs
+	if len(b.fields) > 0 {
+		indent = strings.Repeat(" ", b.fields[0].pos.Column-1)
+		first := b.fields[0]
+		if eq := strings.IndexByte(first.raw, '='); eq > len(first.name)+1 {
+			width = eq - 1
+		}
+	}
+
+	fmt.Fprintf(w, "@%s%c%s", typ, b.open, e.BibTeXkey)
+	for _, f := range e.Fields {
+		w.WriteString(",\n" + indent)
+		raw, same := src.field(f)
+		switch {
+		case same:
+			w.WriteString(raw.raw)
+		case raw.name != "":
+			quoted := len(raw.parts) > 0 && raw.parts[0].kind == quotedPart
+			fmt.Fprintf(w, "%-*s = %s", width, raw.name, delimit(f.Value, quoted))
+		default:
+			fmt.Fprintf(w, "%-*s = %s", width, f.Name, delimit(f.Value, opts.QuoteValues))
+		}
+	}
+	if b.comma {
+		w.WriteString(",")
+	}
+	fmt.Fprintf(w, "\n%c", closing(b.open))
+}
+
+// Text outside of @ blocks and the line it starts on
+type StrayBlock struct {
+	Line int
+	Text string
+}
+
+// Return the text outside of @ blocks that isn't blank, such as comments
+// written with %. BibTeX ignores it, and NormalizeMode leaves it out.
+func (bib Bibliography) StrayText() []StrayBlock {
+	stray := make([]StrayBlock, 0)
+	line := 1
+	for _, src := range bib.source {
+		b := src.block
+		if text := strings.TrimSpace(b.leading); text != "" {
+			after := b.leading[strings.Index(b.leading, text):]
+			stray = append(stray, StrayBlock{b.start.Line - strings.Count(after, "\n"), text})
+		}
+		line = b.end.Line
+	}
+	if text := strings.TrimSpace(bib.trailer); text != "" {
+		before := bib.trailer[:strings.Index(bib.trailer, text)]
+		stray = append(stray, StrayBlock{line + strings.Count(before, "\n"), text})
+	}
+	return stray
+}
+
+// Write the preambles, macros, comments and entries in canonical form
+func writeNormalized(w *bufio.Writer, bib Bibliography, opts WriteOptions) {
+	sep := ""
+	for _, p := range bib.Preambles {
+		w.WriteString(sep)
+		writePreamble(w, p, opts)
+		sep = "\n"
+	}
+	for _, s := range bib.Strings {
+		w.WriteString(sep)
+		writeString(w, s, opts)
+		sep = "\n"
+	}
+	for _, c := range bib.Comments {
+		w.WriteString(sep)
+		writeComment(w, c)
+		sep = "\n"
+	}
+	for _, e := range bib.Entries {
+		w.WriteString(sep)
+		writeEntry(w, e, opts)
+		sep = "\n"
+	}
+}
+
+func writeString(w *bufio.Writer, s Field, opts WriteOptions) {
+	fmt.Fprintf(w, "@string{%s = %s}\n", strings.ToLower(s.Name), delimit(s.Value, opts.QuoteValues))
+}
+
+func writePreamble(w *bufio.Writer, value string, opts WriteOptions) {
+	fmt.Fprintf(w, "@preamble{%s}\n", delimit(value, true))
+}
+
+func writeComment(w *bufio.Writer, body string) {
+	fmt.Fprintf(w, "@comment{%s}\n", body)
+}
+
+// Write an entry in the layout given by *opts*
+func writeEntry(w *bufio.Writer, e Entry, opts WriteOptions) {
+	w.WriteString(FormatEntry(e, opts))
+	w.WriteString("\n")
+}
+
+// Return an entry as BibTeX in the layout given by *opts*, with lower case
+// type and field names. Fields whose value hasn't changed since they were
+// read keep any macros they used.
+func FormatEntry(e Entry, opts WriteOptions) string {
+	var buf strings.Builder
+	fields := orderFields(e.Fields, opts.FieldOrder)
+	width := 0
+	if opts.AlignValues {
+		for _, f := range fields {
+			if len(f.Name) > width {
+				width = len(f.Name)
+			}
+		}
+	}
+
+	fmt.Fprintf(&buf, "@%s{%s", strings.ToLower(e.Type), e.BibTeXkey)
+	for _, f := range fields {
+		fmt.Fprintf(&buf, ",\n%s%-*s = %s", opts.Indent, width, strings.ToLower(f.Name), formatValue(e, f, opts))
+	}
+	if opts.TrailingComma && len(fields) > 0 {
+		buf.WriteString(",")
+	}
+	buf.WriteString("\n}")
+	return buf.String()
+}
+
+// Put the fields named in *order* first, and leave the rest as they are
+func orderFields(fields Fields, order []string) Fields {
+	if len(order) == 0 {
+		return fields
+	}
+	ordered := make(Fields, 0, len(fields))
+	for _, name := range order {
+		if i := fields.index(name); i != -1 {
+			ordered = append(ordered, fields[i])
+		}
+	}
+	for _, f := range fields {
+		if ordered.index(f.Name) == -1 {
+			ordered = append(ordered, f)
+		}
+	}
+	return ordered
+}
+
+// Delimit a value, reusing the macros and concatenations from the source if
+// the value hasn't changed
+func formatValue(e Entry, f Field, opts WriteOptions) string {
+	if e.src != nil {
+		if raw, same := e.src.field(f); same && len(raw.parts) > 0 {
+			pieces := make([]string, len(raw.parts))
+			for i, part := range raw.parts {
+				if part.kind == macroPart {
+					pieces[i] = part.text
+				} else {
+					pieces[i] = delimit(squeezeSpace(part.text), opts.QuoteValues)
+				}
+			}
+			return strings.Join(pieces, " # ")
+		}
+	}
+	return delimit(f.Value, opts.QuoteValues)
+}
+
+// Wrap a value in braces or double quotes. Double quotes outside of braces
+// are protected with braces so that they don't end the value.
+func delimit(value string, quoted bool) string {
+	if !quoted {
+		return "{" + value + "}"
+	}
+	var buf strings.Builder
+	buf.WriteByte('"')
+	depth := 0
+	var prev rune
+	for _, r := range value {
+		switch {
+		case r == '{':
+			depth++
+		case r == '}':
+			depth--
+		case r == '"' && depth == 0 && prev != '\\':
+			buf.WriteString(`{"}`)
+			prev = r
+			continue
+		}
+		buf.WriteRune(r)
+		prev = r
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// Replace runs of whitespace with a single space, keeping a space at either end
+func squeezeSpace(str string) string {
+	squeezed := collapseSpace(str)
+	if squeezed == "" {
+		if str != "" {
+			return " "
+		}
+		return squeezed
+	}
+	if isSpace(str[0]) {
+		squeezed = " " + squeezed
+	}
+	if isSpace(str[len(str)-1]) {
+		squeezed += " "
+	}
+	return squeezed
+}
+
+func closing(open byte) byte {
+	if open == '(' {
+		return ')'
+	}
+	return '}'
+}
@@ -0,0 +1,177 @@
+package bibtex
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWritePreserveRoundTrip(t *testing.T) {
+	for _, fnm := range []string{"macsyma.bib", "test.bib"} {
+		data, err := ioutil.ReadFile(fnm)
+		if err != nil {
+			fmt.Println(err)
+			t.FailNow()
+		}
+		bib, err := parseBibliography("", string(data))
+		if err != nil {
+			fmt.Println(err)
+			t.FailNow()
+		}
+		var buf bytes.Buffer
+		if err := WriteBibliography(&buf, bib, WriteOptions{Mode: PreserveMode}); err != nil {
+			fmt.Println(err)
+			t.FailNow()
+		}
+		if buf.String() != string(data) {
+			fmt.Println("round trip of", fnm, "is not byte-identical")
+			t.Fail()
+		}
+	}
+}
+
+func TestWriteBibTeXGzip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bibtex")
+	if err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	bib, _ := parseBibliography("", "@article{a, title = {Gzipped}}\n")
+	fnm := filepath.Join(dir, "refs.bib.gz")
+	if err := WriteBibTeX(fnm, bib, DefaultWriteOptions()); err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	data, _ := ioutil.ReadFile(fnm)
+	if !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		fmt.Println("refs.bib.gz was not compressed")
+		t.Fail()
+	}
+	back, err := ReadBibliography(fnm)
+	if err != nil || len(back.Entries) != 1 || back.Entries[0].Title != "Gzipped" {
+		fmt.Println(back.Entries, err)
+		t.Fail()
+	}
+}
+
+func TestWritePreserveChanges(t *testing.T) {
+	src := `% my references
+@String{jgr = "J. Geophys. Res."}
+
+@Article{one,
+  Title   = "First",
+  Journal = jgr,
+  Year    = 1999
+}
+
+@Article{two,
+  Title = {Second},
+}
+`
+	bib, err := parseBibliography("", src)
+	if err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	bib.Entries[0].Fields.Set("title", "First, revised")
+	bib.Entries[0].Fields.Set("doi", "10.1000/1")
+	bib.Entries = bib.Entries[:1]
+	bib.Entries = append(bib.Entries, Entry{Type: "misc", BibTeXkey: "three", Fields: Fields{{"note", "New"}}})
+
+	var buf bytes.Buffer
+	WriteBibliography(&buf, bib, WriteOptions{Mode: PreserveMode, Indent: "  "})
+	expected := `% my references
+@String{jgr = "J. Geophys. Res."}
+
+@Article{one,
+  Title   = "First, revised",
+  Journal = jgr,
+  Year    = 1999,
+  doi     = {10.1000/1}
+}
+
+@misc{three,
+  note = {New}
+}
+`
+	if buf.String() != expected {
+		fmt.Printf("unexpected output:\n%s\n", buf.String())
+		t.Fail()
+	}
+}
+
+func TestWriteNormalize(t *testing.T) {
+	src := `@String{jgr = "J. Geophys. Res."}
+@Comment{keep me}
+@ARTICLE(Wilson2013a, Year = 2013,
+  TITLE = "Thermal structure of {"}alpine{"} glaciers",
+  journal = jgr # " Oceans", Doi={10.5194/tc-7-167-2013})`
+	bib, err := parseBibliography("", src)
+	if err != nil {
+		fmt.Println(err)
+		t.FailNow()
+	}
+	var buf bytes.Buffer
+	opts := DefaultWriteOptions()
+	opts.FieldOrder = []string{"title", "journal"}
+	WriteBibliography(&buf, bib, opts)
+	expected := `@string{jgr = {J. Geophys. Res.}}
+
+@comment{keep me}
+
+@article{Wilson2013a,
+  title   = {Thermal structure of {"}alpine{"} glaciers},
+  journal = jgr # { Oceans},
+  year    = {2013},
+  doi     = {10.5194/tc-7-167-2013},
+}
+`
+	if buf.String() != expected {
+		fmt.Printf("unexpected output:\n%s\n", buf.String())
+		t.Fail()
+	}
+
+	// normalized output reads back to the same entries
+	again, err := parseBibliography("", buf.String())
+	if err != nil || len(again.Entries) != 1 {
+		fmt.Println("normalized output does not parse:", err)
+		t.FailNow()
+	}
+	for i, f := range bib.Entries[0].Fields {
+		g := again.Entries[0].Fields[0]
+		if j := again.Entries[0].Fields.index(f.Name); j != -1 {
+			g = again.Entries[0].Fields[j]
+		}
+		if g.Value != f.Value {
+			fmt.Println("field", i, "changed from", f.Value, "to", g.Value)
+			t.Fail()
+		}
+	}
+}
+
+func TestStrayText(t *testing.T) {
+	src := "% references for chapter 2\n@article{a, title = {A}}\n\n@article{b, title = {B}}\ntrailing\n"
+	bib, _ := parseBibliography("", src)
+	stray := bib.StrayText()
+	if len(stray) != 2 || stray[0] != (StrayBlock{1, "% references for chapter 2"}) ||
+		stray[1] != (StrayBlock{5, "trailing"}) {
+		fmt.Printf("unexpected stray text: %+v\n", stray)
+		t.Fail()
+	}
+}
+
+func TestDelimit(t *testing.T) {
+	if v := delimit(`say "hi" {"}`, true); v != `"say {"}hi{"} {"}"` {
+		fmt.Println("unexpected quoted value:", v)
+		t.Fail()
+	}
+	if v := delimit(`{Braced} text`, false); v != `{{Braced} text}` {
+		fmt.Println("unexpected braced value:", v)
+		t.Fail()
+	}
+}
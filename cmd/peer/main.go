@@ -7,6 +7,7 @@ import (
 	"os/exec"
 	"strings"
 
+	"github.com/njwilson23/peer2/library"
 	"gopkg.in/urfave/cli.v1"
 )
 
@@ -47,28 +48,28 @@ func main() {
 			return errors.New("at least one search term must be provided")
 		}
 		roots := []string{c.String("path")}
-		results := search(roots, searchTerms)
+		results := library.Search(roots, searchTerms)
 
 		if c.Int("open") != -1 {
 			idx := c.Int("open")
 			if idx > len(results) {
 				return errors.New("invalid index to open")
 			}
-			cmd := exec.Command("evince", results[idx-1].path)
+			cmd := exec.Command("evince", results[idx-1].Path)
 			cmd.Start()
 		}
 
 		if c.Bool("print0") {
 			names := make([]string, len(results))
 			for i, r := range results {
-				names[i] = r.path
+				names[i] = r.Path
 			}
 			fmt.Println(strings.Join(names, " "))
 			return nil
 		}
 
 		for _, result := range results {
-			fmt.Printf("%70s\t%.2f\n", result, result.score)
+			fmt.Printf("%70s\t%.2f\n", result, result.Score)
 		}
 		return nil
 	}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/njwilson23/peer2/bibtex"
+	"github.com/njwilson23/peer2/config"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// Return the files named on the command line, the global --bibtex file, or
+// else the files listed in the configuration file
+func bibfileArgs(c *cli.Context) []string {
+	if c.NArg() > 0 {
+		return c.Args()
+	}
+	return configuredBibfiles(c)
+}
+
+// Return the global --bibtex file, or else the files listed in the
+// configuration file
+func configuredBibfiles(c *cli.Context) []string {
+	if fnm := c.GlobalString("bibtex"); fnm != "" {
+		return []string{fnm}
+	}
+	return loadConfig().Bibfiles
+}
+
+// Return the configuration, or an empty one if there is no configuration file
+func loadConfig() config.Config {
+	if fnm, err := config.FindConfig(); err == nil {
+		return config.ParseConfig(fnm)
+	}
+	return config.Config{}
+}
+
+func checkCommand() cli.Command {
+	return cli.Command{
+		Name:      "check",
+		Usage:     "Report syntax errors and suspicious values in BibTeX files",
+		ArgsUsage: "[BIBFILE...]",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "strict",
+				Usage: "Exit with an error status for warnings too",
+			},
+			cli.BoolFlag{
+				Name:  "quiet, q",
+				Usage: "Don't show the source line for each problem",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			fnms := bibfileArgs(c)
+			if len(fnms) == 0 {
+				return cli.NewExitError("no BibTeX files given", 2)
+			}
+
+			nerrors, nwarnings := 0, 0
+			for _, fnm := range fnms {
+				bib, err := bibtex.ReadBibliography(fnm)
+				errs, ok := err.(bibtex.ErrorList)
+				if err != nil && !ok {
+					errs = bibtex.ErrorList{bibtex.ParseError{File: fnm, Message: err.Error()}}
+				}
+				_, crossrefErrs := bibtex.ResolveCrossrefs(bib.Entries, fnm)
+				errs = append(errs, crossrefErrs...)
+				for _, e := range errs {
+					if e.Warning {
+						nwarnings++
+					} else {
+						nerrors++
+					}
+					fmt.Println(e)
+					if e.Snippet != "" && !c.Bool("quiet") {
+						fmt.Println("    " + e.Snippet)
+						fmt.Println("    " + caretLine(e.Snippet, e.Column))
+					}
+				}
+			}
+
+			if nerrors > 0 || (c.Bool("strict") && nwarnings > 0) {
+				return cli.NewExitError(fmt.Sprintf("%d errors, %d warnings", nerrors, nwarnings), 1)
+			}
+			return nil
+		},
+	}
+}
+
+// Return a line with a caret under a column of *line*, keeping tabs so the
+// caret lines up
+func caretLine(line string, column int) string {
+	var buf strings.Builder
+	i := 1
+	for _, r := range line {
+		if i >= column {
+			break
+		}
+		if r == '\t' {
+			buf.WriteRune('\t')
+		} else {
+			buf.WriteRune(' ')
+		}
+		i++
+	}
+	buf.WriteString("^")
+	return buf.String()
+}
+
+// Read a whole BibTeX file, printing any problems with it to standard error.
+// Entries with syntax errors are skipped but kept as text, so a file with
+// errors can still be written back out in PreserveMode.
+func loadBibliography(fnm string) (bibtex.Bibliography, error) {
+	bib, err := bibtex.ReadBibliography(fnm)
+	if errs, ok := err.(bibtex.ErrorList); ok {
+		for _, e := range errs {
+			fmt.Fprintln(os.Stderr, e)
+		}
+		return bib, nil
+	}
+	return bib, err
+}
+
+// Write a bibliography to *fnm*, or to standard output if *fnm* is empty or "-"
+func saveBibliography(fnm string, bib bibtex.Bibliography, opts bibtex.WriteOptions) error {
+	if fnm == "" || fnm == "-" {
+		return bibtex.WriteBibliography(os.Stdout, bib, opts)
+	}
+	return bibtex.WriteBibTeX(fnm, bib, opts)
+}
+
+func convertCommand() cli.Command {
+	return cli.Command{
+		Name:      "convert",
+		Usage:     "Convert entries between BibTeX and biblatex conventions",
+		ArgsUsage: "[BIBFILE]",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "to, t",
+				Value: "biblatex",
+				Usage: "Conventions to convert to: biblatex or bibtex",
+			},
+			cli.StringFlag{
+				Name:  "output, o",
+				Usage: "File to write to instead of standard output",
+			},
+			cli.BoolFlag{
+				Name:  "normalize",
+				Usage: "Rewrite the whole file in the canonical layout",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			fnms := bibfileArgs(c)
+			if len(fnms) != 1 {
+				return cli.NewExitError("exactly one BibTeX file must be given", 2)
+			}
+			var convert func(bibtex.Entry) bibtex.Entry
+			switch strings.ToLower(c.String("to")) {
+			case "biblatex":
+				convert = bibtex.ToBibLaTeX
+			case "bibtex":
+				convert = bibtex.ToBibTeX
+			default:
+				return cli.NewExitError(fmt.Sprintf("unknown conventions %q", c.String("to")), 2)
+			}
+
+			bib, err := loadBibliography(fnms[0])
+			if err != nil {
+				return err
+			}
+			for i, entry := range bib.Entries {
+				bib.Entries[i] = convert(entry)
+			}
+			opts := bibtex.DefaultWriteOptions()
+			if !c.Bool("normalize") {
+				opts.Mode = bibtex.PreserveMode
+			}
+			return saveBibliography(c.String("output"), bib, opts)
+		},
+	}
+}
+
+func dupesCommand() cli.Command {
+	return cli.Command{
+		Name:      "dupes",
+		Usage:     "Find entries that look like the same work, and repeated keys",
+		ArgsUsage: "[BIBFILE...]",
+		Flags: []cli.Flag{
+			cli.Float64Flag{
+				Name:  "threshold",
+				Value: 0.5,
+				Usage: "Lowest confidence, from 0 to 1, to report",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			fnms := bibfileArgs(c)
+			if len(fnms) == 0 {
+				return cli.NewExitError("no BibTeX files given or configured", 2)
+			}
+			var entries []bibtex.FileEntry
+			for _, fnm := range fnms {
+				bib, err := loadBibliography(fnm)
+				if err != nil {
+					return err
+				}
+				resolved, _ := bibtex.ResolveCrossrefs(bib.Entries, fnm)
+				for _, entry := range resolved {
+					entries = append(entries, bibtex.FileEntry{File: fnm, Entry: entry})
+				}
+			}
+
+			for i, group := range bibtex.FindDuplicates(entries, c.Float64("threshold")) {
+				fmt.Printf("Group %d (confidence %.2f)\n", i+1, group.Score)
+				for _, j := range group.Entries {
+					e := entries[j].Entry
+					fmt.Printf("  %-30s %-20s %s (%d) %q\n", entries[j], e.BibTeXkey, e.Author, e.Year, e.Title)
+				}
+				for _, pair := range group.Pairs {
+					fmt.Printf("    %s ~ %s: %.2f, %s\n", entries[pair.A].Entry.BibTeXkey,
+						entries[pair.B].Entry.BibTeXkey, pair.Score, strings.Join(pair.Reasons, ", "))
+				}
+				fmt.Println()
+			}
+
+			for _, group := range bibtex.DuplicateKeys(entries) {
+				places := make([]string, len(group))
+				for i, j := range group {
+					places[i] = entries[j].String()
+				}
+				fmt.Printf("Repeated key %s: %s\n", entries[group[0]].Entry.BibTeXkey, strings.Join(places, ", "))
+			}
+			return nil
+		},
+	}
+}
+
+func genkeyCommand() cli.Command {
+	return cli.Command{
+		Name:      "genkey",
+		Usage:     "Rebuild citation keys from a pattern such as [auth][year][shorttitle]",
+		ArgsUsage: "[BIBFILE...]",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "pattern",
+				Usage: "Key pattern (defaults to the configured keypattern, or " + bibtex.DefaultKeyPattern + ")",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run, n",
+				Usage: "Only list the keys that would change",
+			},
+			cli.BoolFlag{
+				Name:  "in-place, i",
+				Usage: "Rewrite the files rather than printing the result",
+			},
+			cli.BoolFlag{
+				Name:  "aliases",
+				Usage: "Keep the old keys in the ids field of each entry",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			fnms := bibfileArgs(c)
+			if len(fnms) == 0 {
+				return cli.NewExitError("no BibTeX files given or configured", 2)
+			}
+			if len(fnms) > 1 && !c.Bool("dry-run") && !c.Bool("in-place") {
+				return cli.NewExitError("more than one file can only be used with --dry-run or --in-place", 2)
+			}
+			pattern := c.String("pattern")
+			if pattern == "" {
+				pattern = loadConfig().KeyPattern
+			}
+			if pattern == "" {
+				pattern = bibtex.DefaultKeyPattern
+			}
+			p, err := bibtex.ParseKeyPattern(pattern)
+			if err != nil {
+				return cli.NewExitError(err.Error(), 2)
+			}
+
+			// keys are generated across all the files so that they don't
+			// collide when the files are used together
+			bibs := make([]bibtex.Bibliography, len(fnms))
+			var entries []bibtex.Entry
+			for i, fnm := range fnms {
+				if bibs[i], err = loadBibliography(fnm); err != nil {
+					return err
+				}
+				entries = append(entries, bibs[i].Entries...)
+			}
+			keys := bibtex.GenerateKeys(entries, p, nil)
+
+			// crossref and xdata fields are updated to the new keys, in
+			// any of the files
+			renames := make(map[string]string)
+			n := 0
+			for i := range bibs {
+				for j, entry := range bibs[i].Entries {
+					key := keys[n]
+					n++
+					if key == entry.BibTeXkey {
+						continue
+					}
+					if c.Bool("dry-run") {
+						fmt.Printf("%s: %s -> %s\n", fnms[i], entry.BibTeXkey, key)
+						continue
+					}
+					if c.Bool("aliases") {
+						ids := strings.TrimSpace(entry.Field("ids"))
+						if ids != "" {
+							ids += ", "
+						}
+						entry.Fields = entry.Fields.Copy()
+						entry.Fields.Set("ids", ids+entry.BibTeXkey)
+					}
+					if _, ok := renames[strings.ToLower(entry.BibTeXkey)]; !ok {
+						renames[strings.ToLower(entry.BibTeXkey)] = key
+					}
+					entry.BibTeXkey = key
+					bibs[i].Entries[j] = entry
+				}
+			}
+			if c.Bool("dry-run") {
+				return nil
+			}
+			for i := range bibs {
+				bibtex.RenameReferences(bibs[i].Entries, renames)
+			}
+
+			opts := bibtex.DefaultWriteOptions()
+			opts.Mode = bibtex.PreserveMode
+			for i, fnm := range fnms {
+				if !c.Bool("in-place") {
+					fnm = ""
+				}
+				if err := saveBibliography(fnm, bibs[i], opts); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
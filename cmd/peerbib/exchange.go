@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/njwilson23/peer2/bibtex"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// Formats that entries can be exported to and imported from
+var exchangeFormats = []string{"csljson", "ris"}
+
+func isExchangeFormat(format string) bool {
+	for _, f := range exchangeFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+func exportCommand() cli.Command {
+	return cli.Command{
+		Name:      "export",
+		Usage:     "Write BibTeX entries in another format",
+		ArgsUsage: "[BIBFILE...]",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "to, t",
+				Value: "csljson",
+				Usage: "Format to write: " + strings.Join(exchangeFormats, ", "),
+			},
+			cli.StringFlag{
+				Name:  "output, o",
+				Value: "-",
+				Usage: "File to write to, or - for standard output",
+			},
+			cli.BoolFlag{
+				Name:  "raw",
+				Usage: "Don't fill in fields inherited through crossref and xdata",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			to := c.String("to")
+			if !isExchangeFormat(to) {
+				return cli.NewExitError(fmt.Sprintf("unknown format %q", to), 2)
+			}
+			fnms := bibfileArgs(c)
+			if len(fnms) == 0 {
+				return cli.NewExitError("no BibTeX files given or configured", 2)
+			}
+			var all []bibtex.Entry
+			for _, fnm := range fnms {
+				bib, err := loadBibliography(fnm)
+				if err != nil {
+					return err
+				}
+				entries := bib.Entries
+				if !c.Bool("raw") {
+					entries, _ = bibtex.ResolveCrossrefs(entries, fnm)
+				}
+				all = append(all, entries...)
+			}
+			var buf bytes.Buffer
+			var err error
+			switch to {
+			case "ris":
+				err = bibtex.WriteRIS(&buf, all)
+			default:
+				items := make([]bibtex.CSLItem, len(all))
+				for i, entry := range all {
+					items[i] = bibtex.ToCSL(entry)
+				}
+				err = bibtex.WriteCSLJSON(&buf, items)
+			}
+			if err != nil {
+				return err
+			}
+			return writeOutput(c.String("output"), buf.Bytes())
+		},
+	}
+}
+
+func importCommand() cli.Command {
+	return cli.Command{
+		Name:      "import",
+		Usage:     "Read entries written in another format as BibTeX",
+		ArgsUsage: "FILE...",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "from, f",
+				Value: "csljson",
+				Usage: "Format to read: " + strings.Join(exchangeFormats, ", "),
+			},
+			cli.StringFlag{
+				Name:  "output, o",
+				Value: "-",
+				Usage: "BibTeX file to write to, or - for standard output. An existing file is only replaced with --force",
+			},
+			cli.BoolFlag{
+				Name:  "force",
+				Usage: "Replace an existing output file with the imported entries, losing what it held",
+			},
+			cli.StringFlag{
+				Name:  "pattern",
+				Usage: "Key pattern for entries without an id (defaults to the configured keypattern, or " + bibtex.DefaultKeyPattern + ")",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			from := c.String("from")
+			if !isExchangeFormat(from) {
+				return cli.NewExitError(fmt.Sprintf("unknown format %q", from), 2)
+			}
+			if c.NArg() == 0 {
+				return cli.NewExitError("no files given", 2)
+			}
+			if out := c.String("output"); out != "-" && !c.Bool("force") {
+				if _, err := os.Stat(out); err == nil {
+					return cli.NewExitError(fmt.Sprintf("%s already exists; use --force to replace it", out), 1)
+				}
+			}
+			var bib bibtex.Bibliography
+			for _, fnm := range c.Args() {
+				f, err := bibtex.OpenBibTeX(fnm)
+				if err != nil {
+					return err
+				}
+				entries, err := readEntries(f, fnm, from)
+				f.Close()
+				if err != nil {
+					return err
+				}
+				bib.Entries = append(bib.Entries, entries...)
+			}
+
+			// an id given more than once gets a suffix after the first
+			// time, and generated keys must not clash with any of them
+			var keyless []int
+			taken := make(map[string]bool)
+			for _, entry := range bib.Entries {
+				taken[strings.ToLower(entry.BibTeXkey)] = entry.BibTeXkey != ""
+			}
+			seen := make(map[string]bool)
+			for i, entry := range bib.Entries {
+				id := entry.BibTeXkey
+				switch {
+				case id == "":
+					keyless = append(keyless, i)
+				case seen[strings.ToLower(id)]:
+					bib.Entries[i].BibTeXkey = bibtex.UniqueKey(id, taken)
+					fmt.Fprintf(os.Stderr, "id %s is used more than once, so it was changed to %s\n", id, bib.Entries[i].BibTeXkey)
+				default:
+					seen[strings.ToLower(id)] = true
+				}
+			}
+			if len(keyless) > 0 {
+				pattern := c.String("pattern")
+				if pattern == "" {
+					pattern = loadConfig().KeyPattern
+				}
+				if pattern == "" {
+					pattern = bibtex.DefaultKeyPattern
+				}
+				p, err := bibtex.ParseKeyPattern(pattern)
+				if err != nil {
+					return cli.NewExitError(err.Error(), 2)
+				}
+				entries := make([]bibtex.Entry, len(keyless))
+				for k, i := range keyless {
+					entries[k] = bib.Entries[i]
+				}
+				keys := bibtex.GenerateKeys(entries, p, taken)
+				for _, key := range keys {
+					taken[strings.ToLower(key)] = true
+				}
+				n := 0
+				for k, key := range keys {
+					// the pattern gives no key for an entry without an
+					// author, year or title
+					for key == "" {
+						n++
+						if !taken[fmt.Sprintf("item%d", n)] {
+							key = fmt.Sprintf("item%d", n)
+						}
+					}
+					bib.Entries[keyless[k]].BibTeXkey = key
+				}
+				fmt.Fprintf(os.Stderr, "generated keys for %d entries without an id\n", len(keyless))
+			}
+			return saveBibliography(c.String("output"), bib, bibtex.DefaultWriteOptions())
+		},
+	}
+}
+
+// Read the entries in a file written in one of exchangeFormats. Problems
+// with RIS records are printed, as they are for BibTeX files, and the
+// records are still read.
+func readEntries(rd io.Reader, fnm, format string) ([]bibtex.Entry, error) {
+	switch format {
+	case "ris":
+		entries, err := bibtex.ReadRIS(rd, fnm)
+		if errs, ok := err.(bibtex.ErrorList); ok {
+			for _, e := range errs {
+				fmt.Fprintln(os.Stderr, e)
+			}
+			return entries, nil
+		}
+		return entries, err
+	default:
+		items, err := bibtex.ReadCSLJSON(rd)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fnm, err)
+		}
+		entries := make([]bibtex.Entry, len(items))
+		for i, item := range items {
+			entries[i] = bibtex.FromCSL(item)
+		}
+		return entries, nil
+	}
+}
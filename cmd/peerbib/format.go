@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/njwilson23/peer2/bibtex"
+	"gopkg.in/urfave/cli.v1"
+)
+
+func fmtCommand() cli.Command {
+	return cli.Command{
+		Name:      "fmt",
+		Usage:     "Rewrite BibTeX files in a canonical layout",
+		ArgsUsage: "[BIBFILE...]",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "check, c",
+				Usage: "List the files that aren't formatted and exit with an error status, changing nothing",
+			},
+			cli.StringFlag{
+				Name:  "sort, s",
+				Usage: "Sort entries by key, year or author, or none to keep their order",
+			},
+			cli.StringFlag{
+				Name:  "field-order",
+				Usage: "Comma separated fields to write first, in order",
+			},
+			cli.StringFlag{
+				Name:  "output, o",
+				Usage: "Write to this file, or - for standard output, rather than in place",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			fnms := bibfileArgs(c)
+			if len(fnms) == 0 {
+				return cli.NewExitError("no BibTeX files given or configured", 2)
+			}
+			out := c.String("output")
+			if out != "" && len(fnms) != 1 {
+				return cli.NewExitError("--output needs exactly one BibTeX file", 2)
+			}
+			if out != "" && c.Bool("check") {
+				return cli.NewExitError("--check changes nothing, so it can't be used with --output", 2)
+			}
+			for _, fnm := range fnms {
+				if fnm == "-" && out == "" && !c.Bool("check") {
+					return cli.NewExitError("standard input can only be formatted with --output or --check", 2)
+				}
+			}
+
+			conf := loadConfig().Format
+			opts := bibtex.DefaultWriteOptions()
+			opts.FieldOrder = bibtex.DefaultFieldOrder
+			if len(conf.FieldOrder) > 0 {
+				opts.FieldOrder = conf.FieldOrder
+			}
+			if order := c.String("field-order"); order != "" {
+				opts.FieldOrder = strings.Split(order, ",")
+				for i, name := range opts.FieldOrder {
+					opts.FieldOrder[i] = strings.TrimSpace(name)
+				}
+			}
+			sortBy := conf.Sort
+			if c.String("sort") != "" {
+				sortBy = c.String("sort")
+			}
+			var sorter func([]bibtex.Entry) sort.Interface
+			switch strings.ToLower(sortBy) {
+			case "", "none":
+			case "key":
+				sorter = func(e []bibtex.Entry) sort.Interface { return bibtex.ByKey(e) }
+			case "year":
+				sorter = func(e []bibtex.Entry) sort.Interface { return bibtex.ByYear(e) }
+			case "author":
+				sorter = func(e []bibtex.Entry) sort.Interface { return bibtex.ByAuthor(e) }
+			default:
+				return cli.NewExitError(fmt.Sprintf("unknown sort order %q", sortBy), 2)
+			}
+
+			unformatted := 0
+			for _, fnm := range fnms {
+				// the file is read once, as standard input can't be read
+				// again to compare with
+				old, err := readBibTeX(fnm)
+				if err != nil {
+					return err
+				}
+				bib, err := bibtex.ParseBibliography(bytes.NewReader(old), fnm)
+				if errs, ok := err.(bibtex.ErrorList); ok && errs.HasErrors() {
+					// the broken entries would be lost
+					for _, e := range errs {
+						fmt.Fprintln(os.Stderr, e)
+					}
+					return cli.NewExitError(fmt.Sprintf("%s has syntax errors, so it was not formatted", fnm), 1)
+				} else if err != nil && !ok {
+					return err
+				}
+				if !c.Bool("check") {
+					for _, stray := range bib.StrayText() {
+						fmt.Fprintf(os.Stderr, "%s:%d: dropping %d bytes of text outside entries\n", fnm, stray.Line, len(stray.Text))
+					}
+				}
+				if sorter != nil {
+					sort.Stable(sorter(bib.Entries))
+				}
+
+				var buf bytes.Buffer
+				if err := bibtex.WriteBibliography(&buf, bib, opts); err != nil {
+					return err
+				}
+				if out != "" {
+					if err := writeOutput(out, buf.Bytes()); err != nil {
+						return err
+					}
+					continue
+				}
+				if bytes.Equal(old, buf.Bytes()) {
+					continue
+				}
+				unformatted++
+				if c.Bool("check") {
+					fmt.Println(fnm)
+				} else if err := writeOutput(fnm, buf.Bytes()); err != nil {
+					return err
+				}
+			}
+
+			if c.Bool("check") && unformatted > 0 {
+				return cli.NewExitError(fmt.Sprintf("%d of %d files need formatting", unformatted, len(fnms)), 1)
+			}
+			return nil
+		},
+	}
+}
+
+// Return the contents of a BibTeX file, decompressed if its name ends in
+// ".gz", or of standard input if the name is "-"
+func readBibTeX(fnm string) ([]byte, error) {
+	f, err := bibtex.OpenBibTeX(fnm)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// Write *data* to a file, keeping its permissions if it exists and
+// compressing it if the name ends in ".gz", or to standard output if the
+// name is "-"
+func writeOutput(fnm string, data []byte) error {
+	if fnm == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(fnm); err == nil {
+		mode = info.Mode()
+	}
+	if strings.HasSuffix(fnm, ".gz") {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(data)
+		if err := gz.Close(); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	}
+	return ioutil.WriteFile(fnm, data, mode)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/njwilson23/peer2/bibtex"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// A lint problem as written by --format json
+type lintRecord struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Key      string `json:"key"`
+	Field    string `json:"field,omitempty"`
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+func lintCommand() cli.Command {
+	return cli.Command{
+		Name:      "lint",
+		Usage:     "Check entries for missing fields and badly written values",
+		ArgsUsage: "[BIBFILE...]",
+		Flags: []cli.Flag{
+			cli.StringSliceFlag{
+				Name:  "enable, e",
+				Usage: "Run a rule that is off by default or in the configuration (repeatable, or \"all\")",
+			},
+			cli.StringSliceFlag{
+				Name:  "disable, d",
+				Usage: "Skip a rule (repeatable, or \"all\")",
+			},
+			cli.StringFlag{
+				Name:  "format",
+				Value: "text",
+				Usage: "Output format: text or json",
+			},
+			cli.BoolFlag{
+				Name:  "list-rules",
+				Usage: "List the rules and whether they would run",
+			},
+			cli.BoolFlag{
+				Name:  "strict",
+				Usage: "Exit with an error status for warnings too",
+			},
+			cli.BoolFlag{
+				Name:  "quiet, q",
+				Usage: "Don't show the source line for each problem",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			conf := loadConfig().Lint
+			// the command line overrides the configuration
+			rules, err := bibtex.SelectLintRulesLayered([][2][]string{
+				{conf.Enable, conf.Disable},
+				{c.StringSlice("enable"), c.StringSlice("disable")},
+			})
+			if err != nil {
+				return cli.NewExitError(err.Error(), 2)
+			}
+			format := c.String("format")
+			if format != "text" && format != "json" {
+				return cli.NewExitError(fmt.Sprintf("unknown format %q", format), 2)
+			}
+
+			if c.Bool("list-rules") {
+				on := make(map[string]bool)
+				for _, rule := range rules {
+					on[rule.Name] = true
+				}
+				for _, rule := range bibtex.LintRules {
+					state := "off"
+					if on[rule.Name] {
+						state = "on"
+					}
+					fmt.Printf("%-16s %-3s %s\n", rule.Name, state, rule.Description)
+				}
+				return nil
+			}
+
+			fnms := bibfileArgs(c)
+			if len(fnms) == 0 {
+				return cli.NewExitError("no BibTeX files given", 2)
+			}
+			problems := make([]bibtex.LintProblem, 0)
+			for _, fnm := range fnms {
+				// syntax errors are reported too, since the entries they
+				// are in can't be checked
+				bib, err := bibtex.ReadBibliography(fnm)
+				errs, ok := err.(bibtex.ErrorList)
+				if err != nil && !ok {
+					return err
+				}
+				for _, e := range errs {
+					if !e.Warning {
+						problems = append(problems, bibtex.LintProblem{
+							File: fnm, Line: e.Line, Column: e.Column, Key: e.Key,
+							Rule: "syntax", Snippet: e.Snippet, Message: e.Message,
+						})
+					}
+				}
+				problems = append(problems, bibtex.Lint(bib.Entries, fnm, rules)...)
+			}
+
+			nerrors, nwarnings := 0, 0
+			records := make([]lintRecord, 0, len(problems))
+			for _, p := range problems {
+				severity := "error"
+				if p.Warning {
+					severity = "warning"
+					nwarnings++
+				} else {
+					nerrors++
+				}
+				if format == "json" {
+					records = append(records, lintRecord{p.File, p.Line, p.Column, p.Key, p.Field, p.Rule, severity, p.Message})
+					continue
+				}
+				fmt.Println(p)
+				if p.Snippet != "" && !c.Bool("quiet") {
+					fmt.Println("    " + p.Snippet)
+					fmt.Println("    " + caretLine(p.Snippet, p.Column))
+				}
+			}
+			if format == "json" {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(records); err != nil {
+					return err
+				}
+			}
+
+			if nerrors > 0 || (c.Bool("strict") && nwarnings > 0) {
+				return cli.NewExitError(fmt.Sprintf("%d errors, %d warnings", nerrors, nwarnings), 1)
+			}
+			return nil
+		},
+	}
+}
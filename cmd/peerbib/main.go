@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/njwilson23/peer2/bibtex"
+	"gopkg.in/urfave/cli.v1"
+)
+
+func main() {
+
+	app := cli.NewApp()
+	app.Name = "peerbib"
+	app.Version = "0.3.0dev"
+	app.Usage = "peer [--bibtex BIBFILE] [--author AUTHOR] [--first-author SURNAME] [--year YEAR[-YEAR]] [--since DATE] [--until DATE] [--title TITLE] [--type TYPE] [--field NAME:TEXT] [--query QUERY] [--format FORMAT] [query...]"
+
+	wd, err := os.Getwd()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "path, p",
+			Value: wd,
+			Usage: "Search root",
+		},
+		cli.StringFlag{
+			Name:  "bibtex, b",
+			Value: "",
+			Usage: "Search a configured BibTeX file (- for standard input)",
+		},
+		cli.StringFlag{
+			Name:  "author",
+			Value: "",
+			Usage: "Author filter for BibTeX searches",
+		},
+		cli.StringFlag{
+			Name:  "first-author",
+			Value: "",
+			Usage: "First author surname filter for BibTeX searches",
+		},
+		cli.BoolFlag{
+			Name:  "exact",
+			Usage: "Match --author against whole surnames rather than substrings",
+		},
+		cli.StringFlag{
+			Name:  "title",
+			Value: "",
+			Usage: "Title filter for BibTeX searches",
+		},
+		cli.StringFlag{
+			Name:  "year",
+			Value: "",
+			Usage: "Published year or range filter for BibTeX searches, e.g. 1995, 1990-2000 or 2019-03/2020",
+		},
+		cli.StringFlag{
+			Name:  "since",
+			Value: "",
+			Usage: "Only entries published in or after a year or date, e.g. 2010 or 2019-06",
+		},
+		cli.StringFlag{
+			Name:  "until",
+			Value: "",
+			Usage: "Only entries published in or before a year or date",
+		},
+		cli.StringFlag{
+			Name:  "undated",
+			Value: "pending",
+			Usage: "With a date filter, keep entries without a date: pending (only those in press, as this year), exclude or include",
+		},
+		cli.StringFlag{
+			Name:  "type",
+			Value: "",
+			Usage: "Entry type filter for BibTeX searches, e.g. article",
+		},
+		cli.StringSliceFlag{
+			Name:  "field, f",
+			Usage: "Filter on any field as NAME:TEXT, e.g. doi:10.1029 (repeatable)",
+		},
+		cli.StringFlag{
+			Name:  "query, q",
+			Value: "",
+			Usage: "Search query, e.g. 'author:jenkins AND (title:\"ice shelf\" OR keywords:calving) year:1995..2005 -journal:nature'. Free text matches any field, and results are sorted by how well it matches the boosted fields (title, keywords, author, abstract and journal unless configured), so entries that match only in other fields come last",
+		},
+		cli.BoolFlag{
+			Name:  "raw",
+			Usage: "Don't fill in fields inherited through crossref and xdata",
+		},
+		cli.BoolFlag{
+			Name:  "key-only, k",
+			Usage: "Only print BibTeX key",
+		},
+		cli.BoolFlag{
+			Name:  "full",
+			Usage: "Print every field of the matching entries",
+		},
+		cli.BoolFlag{
+			Name:  "emit, e",
+			Usage: "Print the matching entries as BibTeX",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Value: "text",
+			Usage: "Output format: text, or json, jsonl, csv, tsv or yaml for every field of each entry",
+		},
+	}
+
+	app.Action = func(c *cli.Context) error {
+
+		var bibtexResults []bibtex.Entry
+		searchAuthor := c.String("author")
+		searchFirstAuthor := c.String("first-author")
+		exactAuthor := c.Bool("exact")
+		searchTitle := c.String("title")
+		searchType := c.String("type")
+
+		fieldFilters := make([][2]string, 0)
+		for _, filter := range c.StringSlice("field") {
+			pieces := strings.SplitN(filter, ":", 2)
+			if len(pieces) != 2 {
+				return fmt.Errorf("field filter %q is not of the form NAME:TEXT", filter)
+			}
+			fieldFilters = append(fieldFilters, [2]string{pieces[0], pieces[1]})
+		}
+
+		// the --query flag and any arguments must all match
+		var queries []string
+		for _, q := range append([]string{c.String("query")}, c.Args()...) {
+			if strings.TrimSpace(q) != "" {
+				queries = append(queries, q)
+			}
+		}
+		var query bibtex.Predicate
+		var terms []string
+		if len(queries) > 0 {
+			combined := queries[0]
+			if len(queries) > 1 {
+				combined = "(" + strings.Join(queries, ") AND (") + ")"
+			}
+			if query, err = bibtex.CompileQuery(combined); err != nil {
+				return cli.NewExitError(err.Error(), 2)
+			}
+			terms, _ = bibtex.QueryTerms(combined)
+		}
+
+		dates, err := dateFilter(c)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 2)
+		}
+
+		format := c.String("format")
+		if format != "text" && !isRecordFormat(format) {
+			return cli.NewExitError(fmt.Sprintf("unknown format %q", format), 2)
+		}
+
+		bibfile := c.String("bibtex")
+		f, err := bibtex.OpenBibTeX(bibfile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		scanner := bibtex.NewScanner(context.Background(), f, bibfile)
+		var entries []bibtex.Entry
+		for scanner.Next() {
+			entries = append(entries, scanner.Entry())
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		diagnostics := scanner.Diagnostics()
+		if !c.Bool("raw") {
+			// parents usually come after the entries that refer to them, so
+			// this needs the whole file
+			var errs bibtex.ErrorList
+			entries, errs = bibtex.ResolveCrossrefs(entries, bibfile)
+			diagnostics = append(diagnostics, errs...)
+		}
+		for _, diagnostic := range diagnostics {
+			fmt.Fprintln(os.Stderr, diagnostic)
+		}
+
+		for _, entry := range entries {
+
+			if exactAuthor {
+				if !entry.TestAuthorSurname(searchAuthor) {
+					continue
+				}
+			} else if !entry.TestAuthor(searchAuthor) {
+				continue
+			}
+
+			if !(entry.TestFirstAuthor(searchFirstAuthor) &&
+				entry.TestTitle(searchTitle) &&
+				entry.TestType(searchType)) {
+				continue
+			}
+
+			if dates != nil && !dates.Match(entry) {
+				continue
+			}
+
+			if query != nil && !query(entry) {
+				continue
+			}
+
+			matched := true
+			for _, filter := range fieldFilters {
+				if !entry.TestField(filter[0], filter[1]) {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				bibtexResults = append(bibtexResults, entry)
+			}
+
+		}
+
+		// ranking only orders the results, so an entry that matched
+		// free text only in a field without a boost scores nothing and
+		// comes last
+		if len(terms) > 0 {
+			boosts := make(map[string]float64)
+			for field, boost := range bibtex.DefaultSearchBoosts {
+				boosts[field] = boost
+			}
+			for field, boost := range loadConfig().Search.Boosts {
+				boosts[strings.ToLower(field)] = boost
+			}
+			bibtex.NewRanker(entries, boosts).Sort(bibtexResults, terms)
+		} else {
+			sort.Sort(bibtex.ByYear(bibtexResults))
+		}
+
+		if format != "text" {
+			source := bibfile
+			if source == "-" {
+				source = ""
+			}
+			records := make([]bibtex.Record, len(bibtexResults))
+			for i, entry := range bibtexResults {
+				records[i] = bibtex.NewRecord(entry, source)
+			}
+			if err := writeRecords(os.Stdout, format, records); err != nil {
+				return cli.NewExitError(err.Error(), 2)
+			}
+			return nil
+		}
+
+		if c.Bool("key-only") {
+			for _, entry := range bibtexResults {
+				fmt.Println(entry.BibTeXkey)
+			}
+
+			return nil
+		}
+
+		if c.Bool("emit") {
+			opts := bibtex.DefaultWriteOptions()
+			for _, entry := range bibtexResults {
+				fmt.Println(bibtex.FormatEntry(entry, opts))
+				fmt.Println()
+			}
+			return nil
+		}
+
+		if c.Bool("full") {
+			for _, entry := range bibtexResults {
+				fmt.Printf("@%v{%v}\n", entry.Type, entry.BibTeXkey)
+				for _, field := range entry.Fields {
+					fmt.Printf("  %-12v %v\n", strings.ToLower(field.Name), bibtex.UnicodeBibValue(field.Value))
+				}
+				fmt.Println()
+			}
+			return nil
+		}
+
+		for _, entry := range bibtexResults {
+			fmt.Println(fmt.Sprintf("@%v\n%v (%v)\n\"%v\"\n",
+				entry.BibTeXkey,
+				entry.Author,
+				entry.Year,
+				entry.Title))
+		}
+
+		return nil
+	}
+
+	app.Commands = []cli.Command{
+		checkCommand(),
+		convertCommand(),
+		dupesCommand(),
+		mergeCommand(),
+		mergeFilesCommand(),
+		genkeyCommand(),
+		rekeyCommand(),
+		lintCommand(),
+		fmtCommand(),
+		exportCommand(),
+		importCommand(),
+	}
+
+	err = app.Run(os.Args)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// Return the filter given by the --year, --since and --until flags, or nil if
+// none of them were given
+func dateFilter(c *cli.Context) (*bibtex.DateFilter, error) {
+	var f bibtex.DateFilter
+	if c.String("year") == "" && c.String("since") == "" && c.String("until") == "" {
+		return nil, nil
+	}
+	if year := c.String("year"); year != "" {
+		var err error
+		if f, err = bibtex.ParseDateFilter(year); err != nil {
+			return nil, err
+		}
+	}
+	// --since and --until narrow the range from --year
+	var bounds [2]bibtex.Date
+	for i, flag := range []string{"since", "until"} {
+		if v := c.String(flag); v != "" {
+			r, err := bibtex.ParseDate(v)
+			if err != nil || !r.Single() {
+				return nil, fmt.Errorf("--%s %q is not a year or date", flag, v)
+			}
+			bounds[i] = r.Start
+		}
+	}
+	f.Narrow(bounds[0], bounds[1])
+	policy, err := bibtex.ParseUndatedPolicy(c.String("undated"))
+	if err != nil {
+		return nil, err
+	}
+	f.Undated = policy
+	return &f, nil
+}
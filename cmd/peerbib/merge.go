@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/njwilson23/peer2/bibtex"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// An entry to be merged and where it was found
+type mergeSource struct {
+	file  int // index into the files that were read
+	index int // index into the entries of that file
+	entry bibtex.Entry
+}
+
+func mergeCommand() cli.Command {
+	return cli.Command{
+		Name:      "merge",
+		Usage:     "Merge duplicate entries into the first one, keeping the old keys as aliases",
+		ArgsUsage: "KEY1 KEY2...",
+		Flags: []cli.Flag{
+			cli.StringSliceFlag{
+				Name:  "file, f",
+				Usage: "BibTeX file to look for the entries in (repeatable, defaults to --bibtex or the configured files)",
+			},
+			cli.StringFlag{
+				Name:  "policy",
+				Value: "longest",
+				Usage: "How to choose between conflicting values: longest, recent, doi or prompt",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run, n",
+				Usage: "Show the merged entry without changing any files",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			keys := c.Args()
+			if len(keys) < 2 {
+				return cli.NewExitError("at least two keys must be given", 2)
+			}
+			fnms := c.StringSlice("file")
+			if len(fnms) == 0 {
+				fnms = configuredBibfiles(c)
+			}
+			if len(fnms) == 0 {
+				return cli.NewExitError("no BibTeX files given or configured", 2)
+			}
+
+			bibs := make([]bibtex.Bibliography, len(fnms))
+			for i, fnm := range fnms {
+				bib, err := loadBibliography(fnm)
+				if err != nil {
+					return err
+				}
+				bibs[i] = bib
+			}
+			sources, err := findMergeSources(bibs, keys)
+			if err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			entries := make([]bibtex.Entry, len(sources))
+			for i, src := range sources {
+				entries[i] = src.entry
+			}
+
+			policy, err := mergePolicy(c.String("policy"), fnms, sources)
+			if err != nil {
+				return cli.NewExitError(err.Error(), 2)
+			}
+			merged, conflicts := bibtex.MergeEntries(entries, policy)
+			if c.String("policy") != "prompt" {
+				for _, conflict := range conflicts {
+					printConflict(os.Stderr, conflict, fnms, sources)
+				}
+			}
+
+			if c.Bool("dry-run") {
+				fmt.Println(bibtex.FormatEntry(merged, bibtex.DefaultWriteOptions()))
+				return nil
+			}
+
+			// the merged entry replaces the first, and the others are removed
+			changed := make(map[int]bool)
+			first := sources[0]
+			bibs[first.file].Entries[first.index] = merged
+			changed[first.file] = true
+			removed := make(map[[2]int]bool)
+			retired := make(map[string]string)
+			for _, src := range sources[1:] {
+				removed[[2]int{src.file, src.index}] = true
+				changed[src.file] = true
+				retired[src.entry.BibTeXkey] = merged.BibTeXkey
+			}
+			// entries that inherit from a merged entry follow it
+			for i := range bibs {
+				if bibtex.RenameReferences(bibs[i].Entries, retired) > 0 {
+					changed[i] = true
+				}
+			}
+			opts := bibtex.DefaultWriteOptions()
+			opts.Mode = bibtex.PreserveMode
+			for i := range bibs {
+				if !changed[i] {
+					continue
+				}
+				kept := make([]bibtex.Entry, 0, len(bibs[i].Entries))
+				for j, entry := range bibs[i].Entries {
+					if !removed[[2]int{i, j}] {
+						kept = append(kept, entry)
+					}
+				}
+				bibs[i].Entries = kept
+				if err := bibtex.WriteBibTeX(fnms[i], bibs[i], opts); err != nil {
+					return err
+				}
+			}
+			fmt.Printf("merged %s into %s\n", strings.Join(keys[1:], ", "), merged.BibTeXkey)
+			return nil
+		},
+	}
+}
+
+// Find the entry for each key, taking the first file an entry appears in. A
+// key that was retired by an earlier merge finds the entry that replaced it.
+func findMergeSources(bibs []bibtex.Bibliography, keys []string) ([]mergeSource, error) {
+	var all []bibtex.Entry
+	for _, bib := range bibs {
+		all = append(all, bib.Entries...)
+	}
+	aliases := bibtex.KeyAliases(all)
+	sources := make([]mergeSource, 0, len(keys))
+	seen := make(map[[2]int]bool)
+	for _, key := range keys {
+		if !hasEqualKey(all, key) {
+			if current, ok := aliases[strings.ToLower(key)]; ok {
+				key = current
+			}
+		}
+		found := false
+		for i := 0; i < len(bibs) && !found; i++ {
+			for j, entry := range bibs[i].Entries {
+				if strings.EqualFold(entry.BibTeXkey, key) {
+					if seen[[2]int{i, j}] {
+						return nil, fmt.Errorf("key %s given twice", key)
+					}
+					seen[[2]int{i, j}] = true
+					sources = append(sources, mergeSource{i, j, entry})
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no entry with key %s", key)
+		}
+	}
+	return sources, nil
+}
+
+// Return the merge policy with the given name
+func mergePolicy(name string, fnms []string, sources []mergeSource) (bibtex.MergePolicy, error) {
+	switch name {
+	case "longest":
+		return bibtex.LongestValue, nil
+	case "recent":
+		// entries from the most recently modified file first
+		modified := make([]int64, len(fnms))
+		for i, fnm := range fnms {
+			if info, err := os.Stat(fnm); err == nil {
+				modified[i] = info.ModTime().UnixNano()
+			}
+		}
+		order := make([]int, len(sources))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			return modified[sources[order[i]].file] > modified[sources[order[j]].file]
+		})
+		return bibtex.PreferEntries(order...), nil
+	case "doi":
+		var order []int
+		for i, src := range sources {
+			if src.entry.Field("doi") != "" {
+				order = append(order, i)
+			}
+		}
+		return bibtex.PreferEntries(order...), nil
+	case "prompt":
+		in := bufio.NewReader(os.Stdin)
+		return func(field string, values []string) int {
+			return promptValue(in, os.Stderr, field, values, fnms, sources)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown merge policy %q", name)
+}
+
+// Show the values of a conflicting field, marking the one that was kept
+func printConflict(w io.Writer, conflict bibtex.MergeConflict, fnms []string, sources []mergeSource) {
+	fmt.Fprintf(w, "%s:\n", conflict.Field)
+	for i, v := range conflict.Values {
+		mark := " "
+		if i == conflict.Chosen {
+			mark = "*"
+		}
+		src := sources[i]
+		fmt.Fprintf(w, "  %s [%d] %s (%s): %s\n", mark, i+1, src.entry.BibTeXkey, fnms[src.file], v)
+	}
+}
+
+// Ask which value of a field to keep, defaulting to the longest
+func promptValue(in *bufio.Reader, out io.Writer, field string, values []string, fnms []string, sources []mergeSource) int {
+	def := bibtex.LongestValue(field, values)
+	for {
+		printConflict(out, bibtex.MergeConflict{Field: field, Values: values, Chosen: def}, fnms, sources)
+		fmt.Fprintf(out, "keep which value? [%d] ", def+1)
+		line, err := in.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return def
+		}
+		if n, convErr := strconv.Atoi(line); convErr == nil && n >= 1 && n <= len(values) && values[n-1] != "" {
+			return n - 1
+		}
+		if err != nil {
+			return def
+		}
+		fmt.Fprintf(out, "enter a number from 1 to %d\n", len(values))
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/njwilson23/peer2/bibtex"
+	"gopkg.in/urfave/cli.v1"
+)
+
+func mergeFilesCommand() cli.Command {
+	return cli.Command{
+		Name:      "merge-files",
+		Usage:     "Combine BibTeX files into one, merging duplicates and renaming clashing keys",
+		ArgsUsage: "[BIBFILE...]",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "output, o",
+				Value: "-",
+				Usage: "File to write the combined bibliography to, or - for standard output",
+			},
+			cli.BoolFlag{
+				Name:  "dedupe",
+				Usage: "Also merge likely duplicates that have different keys",
+			},
+			cli.Float64Flag{
+				Name:  "threshold",
+				Value: bibtex.DefaultMergeFilesOptions().Threshold,
+				Usage: "Lowest duplicate score, from 0 to 1, at which entries are merged",
+			},
+			cli.StringFlag{
+				Name:  "policy",
+				Value: "longest",
+				Usage: "How to choose between conflicting values: longest, or first to prefer earlier files",
+			},
+			cli.StringFlag{
+				Name:  "source-field",
+				Value: bibtex.DefaultMergeFilesOptions().SourceField,
+				Usage: "Field to record each entry's source files in, or empty for none",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run, n",
+				Usage: "Report what would change without writing anything",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			fnms := bibfileArgs(c)
+			if len(fnms) == 0 {
+				return cli.NewExitError("no BibTeX files given or configured", 2)
+			}
+			opts := bibtex.DefaultMergeFilesOptions()
+			opts.Dedupe = c.Bool("dedupe")
+			opts.Threshold = c.Float64("threshold")
+			opts.SourceField = c.String("source-field")
+			switch c.String("policy") {
+			case "longest":
+				opts.Policy = bibtex.LongestValue
+			case "first":
+				opts.Policy = bibtex.FirstValue
+			default:
+				return cli.NewExitError(fmt.Sprintf("unknown merge policy %q", c.String("policy")), 2)
+			}
+
+			bib, report, err := bibtex.MergeFiles(fnms, opts)
+			if errs, ok := err.(bibtex.ErrorList); ok {
+				for _, e := range errs {
+					fmt.Fprintln(os.Stderr, e)
+				}
+			} else if err != nil {
+				return err
+			}
+
+			out := c.String("output")
+			var w io.Writer = os.Stdout
+			if out == "-" && !c.Bool("dry-run") {
+				w = os.Stderr
+			}
+			printMergeReport(w, report)
+			fmt.Fprintf(w, "%d entries from %d files\n", len(bib.Entries), len(fnms))
+			if c.Bool("dry-run") {
+				return nil
+			}
+			return saveBibliography(out, bib, bibtex.DefaultWriteOptions())
+		},
+	}
+}
+
+// Describe the entries that were merged and renamed and the macros that
+// clashed
+func printMergeReport(w io.Writer, report bibtex.MergeFilesReport) {
+	for i, sources := range report.Sources {
+		if len(sources) < 2 {
+			continue
+		}
+		from := make([]string, len(sources))
+		for k, src := range sources {
+			from[k] = fmt.Sprintf("%s (%v)", src.Entry.BibTeXkey, src)
+		}
+		fmt.Fprintf(w, "merged %s\n", strings.Join(from, ", "))
+		for _, conflict := range report.Conflicts[i] {
+			fmt.Fprintf(w, "  %s:\n", conflict.Field)
+			for k, v := range conflict.Values {
+				mark := " "
+				if k == conflict.Chosen {
+					mark = "*"
+				}
+				if v != "" {
+					fmt.Fprintf(w, "    %s %s: %s\n", mark, sources[k].File, v)
+				}
+			}
+		}
+	}
+	for _, r := range report.Renamed {
+		fmt.Fprintf(w, "renamed %s in %s to %s\n", r.Old, r.File, r.New)
+	}
+	for _, m := range report.Macros {
+		fmt.Fprintf(w, "macro %s in %s is %q rather than %q, so its entries use the value in full\n",
+			m.Name, m.File, m.Value, m.Kept)
+	}
+}
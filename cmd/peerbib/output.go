@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/njwilson23/peer2/bibtex"
+	"gopkg.in/yaml.v2"
+)
+
+// Formats that --format writes records in, besides text
+var recordFormats = []string{"json", "jsonl", "csv", "tsv", "yaml"}
+
+// Reports whether records can be written in a format
+func isRecordFormat(format string) bool {
+	for _, f := range recordFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// Write records in one of recordFormats. JSON is a single array, JSON Lines
+// has one record per line, YAML is a list of records, and CSV and TSV have a
+// header row naming the columns from bibtex.TableColumns.
+func writeRecords(w io.Writer, format string, records []bibtex.Record) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case "jsonl":
+		enc := json.NewEncoder(w)
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "yaml":
+		data, err := yaml.Marshal(records)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	case "csv":
+		columns := bibtex.TableColumns(records)
+		cw := csv.NewWriter(w)
+		cw.Write(columns)
+		for _, r := range records {
+			cw.Write(r.Row(columns))
+		}
+		cw.Flush()
+		return cw.Error()
+	case "tsv":
+		// TSV has no quoting, so tabs and line breaks in values become spaces
+		clean := strings.NewReplacer("\t", " ", "\r\n", " ", "\n", " ", "\r", " ")
+		columns := bibtex.TableColumns(records)
+		rows := [][]string{columns}
+		for _, r := range records {
+			rows = append(rows, r.Row(columns))
+		}
+		for _, row := range rows {
+			for i := range row {
+				row[i] = clean.Replace(row[i])
+			}
+			if _, err := fmt.Fprintln(w, strings.Join(row, "\t")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown format %q, expected text or one of %s", format, strings.Join(recordFormats, ", "))
+}
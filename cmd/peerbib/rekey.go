@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/njwilson23/peer2/bibtex"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// Extensions of the LaTeX and Markdown sources that rekey rewrites
+var latexExtensions = map[string]bool{".tex": true, ".ltx": true}
+var markdownExtensions = map[string]bool{".md": true, ".markdown": true, ".rmd": true, ".qmd": true}
+
+func rekeyCommand() cli.Command {
+	return cli.Command{
+		Name:      "rekey",
+		Usage:     "Rename a citation key in BibTeX files and in the LaTeX and Markdown that cites it",
+		ArgsUsage: "OLD NEW",
+		Flags: []cli.Flag{
+			cli.StringSliceFlag{
+				Name:  "file, f",
+				Usage: "BibTeX file to rename the key in (repeatable, defaults to --bibtex or the configured files)",
+			},
+			cli.StringSliceFlag{
+				Name:  "tex",
+				Usage: "File or directory of .tex and .md sources to update (repeatable)",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run, n",
+				Usage: "Show what would change without changing any files",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 2 {
+				return cli.NewExitError("the old and new keys must be given", 2)
+			}
+			old, new := c.Args()[0], c.Args()[1]
+			fnms := c.StringSlice("file")
+			if len(fnms) == 0 {
+				fnms = configuredBibfiles(c)
+			}
+			dryRun := c.Bool("dry-run")
+
+			bibs := make([]bibtex.Bibliography, len(fnms))
+			var all []bibtex.Entry
+			for i, fnm := range fnms {
+				bib, err := loadBibliography(fnm)
+				if err != nil {
+					return err
+				}
+				all = append(all, bib.Entries...)
+				bibs[i] = bib
+			}
+			renames := map[string]string{old: new}
+			// a key retired by a merge stands for the entry that replaced
+			// it, which is renamed along with citations of either key
+			if current, ok := bibtex.KeyAliases(all)[strings.ToLower(old)]; ok && !hasEqualKey(all, old) {
+				fmt.Printf("%s is an alias of %s\n", old, current)
+				old = current
+				renames[old] = new
+			}
+			foundOld, foundNew := hasEqualKey(all, old), hasEqualKey(all, new)
+			switch {
+			case foundOld && foundNew && !strings.EqualFold(old, new):
+				return cli.NewExitError(fmt.Sprintf("an entry with key %s already exists", new), 1)
+			case !foundOld && !foundNew:
+				return cli.NewExitError(fmt.Sprintf("no entry with key %s", old), 1)
+			case !foundOld:
+				fmt.Printf("no entry with key %s, only updating citations\n", old)
+			}
+
+			// files that only refer to the key by crossref or xdata are
+			// written too
+			opts := bibtex.DefaultWriteOptions()
+			opts.Mode = bibtex.PreserveMode
+			for i, fnm := range fnms {
+				refers := bibtex.RenameReferences(bibs[i].Entries, renames) > 0
+				if !bibtex.RenameKey(bibs[i].Entries, old, new) && !refers {
+					continue
+				}
+				fmt.Printf("%s: %s -> %s\n", fnm, old, new)
+				if !dryRun {
+					if err := bibtex.WriteBibTeX(fnm, bibs[i], opts); err != nil {
+						return err
+					}
+				}
+			}
+
+			files, citations := 0, 0
+			for _, root := range c.StringSlice("tex") {
+				err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+					if err != nil {
+						return err
+					}
+					if info.IsDir() {
+						if path != root && strings.HasPrefix(info.Name(), ".") {
+							return filepath.SkipDir
+						}
+						return nil
+					}
+					n, err := rekeySource(path, renames, dryRun)
+					if n > 0 {
+						files++
+						citations += n
+					}
+					return err
+				})
+				if err != nil {
+					return err
+				}
+			}
+			verb := "renamed"
+			if dryRun {
+				verb = "would rename"
+			}
+			fmt.Printf("%s %d citations in %d files\n", verb, citations, files)
+			return nil
+		},
+	}
+}
+
+// Reports whether one of the entries has the key, ignoring case
+func hasEqualKey(entries []bibtex.Entry, key string) bool {
+	for _, entry := range entries {
+		if strings.EqualFold(entry.BibTeXkey, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rename the citations in a LaTeX or Markdown file, printing the lines that
+// change, and return the number of citations renamed. Other files are left
+// alone.
+func rekeySource(path string, renames map[string]string, dryRun bool) (int, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if !latexExtensions[ext] && !markdownExtensions[ext] {
+		return 0, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	src := string(data)
+	out, n := bibtex.RenameLaTeXCitations(src, renames)
+	if markdownExtensions[ext] {
+		var m int
+		out, m = bibtex.RenamePandocCitations(out, renames)
+		n += m
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	// renaming never adds or removes lines, so they can be compared in turn
+	before, after := strings.Split(src, "\n"), strings.Split(out, "\n")
+	for i := range before {
+		if before[i] != after[i] {
+			fmt.Printf("%s:%d\n- %s\n+ %s\n", path, i+1, before[i], after[i])
+		}
+	}
+	if dryRun {
+		return n, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return n, err
+	}
+	return n, ioutil.WriteFile(path, []byte(out), info.Mode())
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/njwilson23/peer2/pdftext"
+	"github.com/njwilson23/peer2/stopwords"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Printf("Usage: peerextract input.pdf\n")
+		os.Exit(1)
+	}
+
+	inputPath := os.Args[1]
+
+	counts, err := pdftext.CountWords(inputPath, stopwords.English())
+	for k, v := range counts {
+		fmt.Println(k, v)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -12,6 +12,27 @@ type Config struct {
 	Reader      string
 	Bibfiles    []string
 	SearchRoots []string
+	KeyPattern  string // for peerbib genkey, e.g. "[auth][year]"
+	Lint        LintConfig
+	Format      FormatConfig
+	Search      SearchConfig
+}
+
+// Ranking for peerbib searches
+type SearchConfig struct {
+	Boosts map[string]float64 // weight of each field, added to or replacing the defaults
+}
+
+// Layout for peerbib fmt
+type FormatConfig struct {
+	FieldOrder []string // fields to write first, in this order
+	Sort       string   // key, year, author or none
+}
+
+// Lint rules to turn on or off, by name, for peerbib lint
+type LintConfig struct {
+	Enable  []string
+	Disable []string
 }
 
 type ConfigNotFoundError struct {
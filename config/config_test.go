@@ -29,4 +29,26 @@ func TestLoadConfig(t *testing.T) {
 	if config.SearchRoots[1] != "~/Documents/pdfs" {
 		t.Fail()
 	}
+
+	if config.KeyPattern != "[auth][year][shorttitle]" {
+		t.Fail()
+	}
+
+	if len(config.Lint.Enable) != 1 || config.Lint.Enable[0] != "unknown-fields" {
+		t.Fail()
+	}
+	if len(config.Lint.Disable) != 1 || config.Lint.Disable[0] != "all-caps-title" {
+		t.Fail()
+	}
+
+	if len(config.Format.FieldOrder) != 4 || config.Format.FieldOrder[3] != "year" {
+		t.Fail()
+	}
+	if config.Format.Sort != "key" {
+		t.Fail()
+	}
+
+	if config.Search.Boosts["title"] != 4 || config.Search.Boosts["abstract"] != 0.5 {
+		t.Fail()
+	}
 }
@@ -1,22 +1,25 @@
-package main
+// Package library finds documents in a collection of directories.
+package library
 
 import (
-	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
+// A PDF matched by Search, with the number of search terms in its path
 type SearchResult struct {
-	path  string
-	score float64
+	Path  string
+	Score float64
 }
 
 func (r SearchResult) String() string {
-	return fmt.Sprintf("%s", r.path)
+	return r.Path
 }
 
-func search(roots []string, searchTerms []string) []SearchResult {
+// Return the PDFs under *roots* whose paths contain at least one of
+// *searchTerms*
+func Search(roots []string, searchTerms []string) []SearchResult {
 
 	var results []SearchResult
 
@@ -34,8 +37,8 @@ func search(roots []string, searchTerms []string) []SearchResult {
 			}
 			if score != 0 {
 				results = append(results, SearchResult{
-					path:  path,
-					score: score,
+					Path:  path,
+					Score: score,
 				})
 			}
 			return nil
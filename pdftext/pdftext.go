@@ -1,8 +1,8 @@
-package main
+// Package pdftext extracts the text of PDF files and counts the words in it.
+package pdftext
 
 import (
 	"bytes"
-	"fmt"
 	"os"
 	"strings"
 
@@ -10,25 +10,8 @@ import (
 	pdf "github.com/njwilson23/unidoc/pdf/model"
 )
 
-func main() {
-	if len(os.Args) < 2 {
-		fmt.Printf("Usage: go run pdf_extract_text.go input.pdf\n")
-		os.Exit(1)
-	}
-
-	inputPath := os.Args[1]
-
-	counts, err := processContentStreams(inputPath, stopWordsMongoDB())
-	for k, v := range counts {
-		fmt.Println(k, v)
-	}
-	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
-	}
-}
-
-func mergeCounts(counts ...map[string]int) map[string]int {
+// Return the sum of several word counts
+func MergeCounts(counts ...map[string]int) map[string]int {
 	merged := make(map[string]int)
 	for _, count := range counts {
 		for word, n := range count {
@@ -42,13 +25,15 @@ func mergeCounts(counts ...map[string]int) map[string]int {
 	return merged
 }
 
-func wordCount(text string, stopWords map[string]bool) (map[string]int, error) {
+// Count the words of at least three letters in *text*, ignoring case,
+// punctuation, digits and any word in *stopWords*
+func WordCount(text string, stopWords map[string]bool) (map[string]int, error) {
 
 	counts := make(map[string]int)
 
 	for _, substr := range strings.Split(strings.Replace(wordNormalize(text), "\n", " ", -1), " ") {
 
-		if len(substr) < 3 {
+		if len(substr) < 3 || stopWords[substr] {
 			continue
 		}
 
@@ -77,7 +62,9 @@ func wordNormalize(word string) string {
 	return buffer.String()
 }
 
-func processContentStreams(inputPath string, stopWords map[string]bool) (map[string]int, error) {
+// Count the words in the text of every page of the PDF at *inputPath*. The
+// counts gathered so far are returned along with any error.
+func CountWords(inputPath string, stopWords map[string]bool) (map[string]int, error) {
 	f, err := os.Open(inputPath)
 	if err != nil {
 		return nil, err
@@ -136,11 +123,11 @@ func processContentStreams(inputPath string, stopWords map[string]bool) (map[str
 			return counts, err
 		}
 
-		pageCounts, err := wordCount(txt, stopWords)
+		pageCounts, err := WordCount(txt, stopWords)
 		if err != nil {
 			return counts, err
 		}
-		counts = mergeCounts(counts, pageCounts)
+		counts = MergeCounts(counts, pageCounts)
 	}
 
 	return counts, nil
@@ -1,6 +1,10 @@
-package main
+// Package stopwords holds word lists that are ignored when indexing or
+// summarizing text.
+package stopwords
 
-func stopWordsMongoDB() map[string]bool {
+// Return the set of common English words that carry little meaning on their
+// own. Each call returns a new map that the caller may modify.
+func English() map[string]bool {
 	// english stop words taken from https://github.com/igorbrigadir/stopwords/blob/21fb2ef149216e3c8cac097975223604ae1e2310/en/snowball_original.txt
 	stopwords := map[string]bool{
 		"":           true,